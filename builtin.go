@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+)
+
+// builtin is a predeclared function. It has no value and each call is type
+// checked on its own.
+type builtin struct {
+	name string
+}
+
+// parseBuiltinCall parses the arguments of a call of b. The first argument
+// of make and new is a type.
+func parseBuiltinCall(b *builtin) expression {
+	ret := &builtinCall{name: b.name}
+	if b.name == "make" || b.name == "new" {
+		if peek(")") {
+			panic(fmt.Sprintf("not enough arguments for %s", b.name))
+		}
+		ret.typeArg = parseType()
+		if !consume(",") {
+			expect(")")
+			return ret
+		}
+	}
+
+	for !consume(")") {
+		ret.args = append(ret.args, parseExpression())
+		if consume("...") {
+			ret.spread = true
+			expect(")")
+			break
+		}
+		if !peek(")") {
+			expect(",")
+		}
+	}
+	if ret.spread && b.name != "append" {
+		panic(fmt.Sprintf("invalid use of ... with built-in %s", b.name))
+	}
+	switch b.name {
+	case "unsafe.Alignof", "unsafe.Offsetof", "unsafe.Sizeof":
+		return unsafeCall(ret)
+	}
+
+	if b.name == "append" {
+		ret.tmp = createLocalVar(newUniqueName())
+	}
+	if (b.name == "len" || b.name == "cap") && len(ret.args) == 1 {
+		// the length of an array is a constant unless it takes calls
+		// to evaluate
+		addType(ret.args[0])
+		if ty := indexedType(ret.args[0].getType()); ty.kind == typeKindArray && !hasCall(ret.args[0]) {
+			return newIntConst(ty.length)
+		}
+	}
+	return ret
+}
+
+func hasCall(e expression) bool {
+	found := false
+	inspect(e, func(n interface{}) {
+		switch n.(type) {
+		case *funcCall, *indirectCall, *ifaceCall, *builtinCall:
+			found = true
+		}
+	})
+	return found
+}
+
+func checkArgCount(e *builtinCall, min, max int) {
+	if len(e.args) < min {
+		panic(fmt.Sprintf("not enough arguments for %s", e.name))
+	}
+	if len(e.args) > max {
+		panic(fmt.Sprintf("too many arguments for %s", e.name))
+	}
+}
+
+func addBuiltinType(e *builtinCall) {
+	for _, arg := range e.args {
+		addType(arg)
+	}
+
+	switch e.name {
+	case "len", "cap":
+		checkArgCount(e, 1, 1)
+		ty := indexedType(e.args[0].getType())
+		if ty.kind == typeKindMap && e.name == "len" {
+			e.setType(newLiteralType("int"))
+			return
+		}
+		if ty.kind != typeKindArray && ty.kind != typeKindSlice {
+			panic(fmt.Sprintf("invalid argument: %s for built-in %s", e.args[0].getType(), e.name))
+		}
+		e.setType(newLiteralType("int"))
+
+	case "make":
+		ty := e.typeArg
+		if ty.kind == typeKindMap {
+			checkArgCount(e, 0, 1)
+			if len(e.args) == 1 {
+				checkIndex(e.args[0])
+			}
+			e.setType(ty)
+			return
+		}
+		if ty.kind != typeKindSlice {
+			panic(fmt.Sprintf("invalid argument: cannot make %s; type must be slice or map", ty))
+		}
+		if len(e.args) == 0 {
+			panic(fmt.Sprintf("invalid operation: make(%s) expects 2 or 3 arguments; found 1", ty))
+		}
+		checkArgCount(e, 1, 2)
+		var sizes []int64
+		for _, arg := range e.args {
+			if n, ok := checkIndex(arg); ok {
+				sizes = append(sizes, n)
+			}
+		}
+		if len(sizes) == 2 && sizes[0] > sizes[1] {
+			panic("invalid argument: length and capacity swapped")
+		}
+		e.setType(ty)
+
+	case "new":
+		checkArgCount(e, 0, 0)
+		e.setType(pointerTo(e.typeArg))
+
+	case "append":
+		checkArgCount(e, 1, len(e.args))
+		ty := e.args[0].getType()
+		if ty.kind != typeKindSlice {
+			panic(fmt.Sprintf("invalid argument: %s is not a slice", ty))
+		}
+		if e.spread {
+			checkArgCount(e, 2, 2)
+			e.args[1] = checkAssignable(e.args[1], sliceOf(ty.base), "argument to append")
+			e.tmp.setType(e.args[1].getType())
+		} else {
+			for i, arg := range e.args[1:] {
+				e.args[i+1] = checkAssignable(arg, ty.base, "argument to append")
+			}
+			e.tmp.setType(ty)
+		}
+		e.setType(ty)
+
+	case "delete":
+		checkArgCount(e, 2, 2)
+		ty := e.args[0].getType()
+		if ty.kind != typeKindMap {
+			panic(fmt.Sprintf("invalid argument: %s is not a map", ty))
+		}
+		e.args[1] = checkAssignable(e.args[1], ty.key, "argument to delete")
+
+	case "copy":
+		checkArgCount(e, 2, 2)
+		dst, src := e.args[0].getType(), e.args[1].getType()
+		if dst.kind != typeKindSlice || src.kind != typeKindSlice {
+			panic(fmt.Sprintf("invalid argument: copy expects slice arguments; found %s and %s", dst, src))
+		}
+		if !identical(dst.base, src.base) {
+			panic(fmt.Sprintf("invalid argument: arguments to copy have different element types %s and %s", dst.base, src.base))
+		}
+		e.setType(newLiteralType("int"))
+
+	case "panic":
+		checkArgCount(e, 1, 1)
+		e.args[0] = checkAssignable(e.args[0], newType(typeKindInterface, 16), "argument to panic")
+
+	case "recover":
+		checkArgCount(e, 0, 0)
+		e.setType(newType(typeKindInterface, 16))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A function literal is compiled to a function of its own. Its value is a
+// closure whose first word is the code address, like any function value,
+// followed by the addresses of the variables of the enclosing function it
+// refers to. Escape analysis moves those variables to the heap. Inside the
+// literal a captured variable x is *ctx.x, where ctx is the closure the
+// literal is called through.
+
+// funcLit is a function literal. captures are the captured variables as
+// expressions in the enclosing function.
+type funcLit struct {
+	expression
+	ty       *typ
+	fn       *function
+	captures []expression
+
+	vars    []*obj
+	members []*member
+	outer   *funcLit
+	// outerLocals are the local variables of the enclosing function
+	outerLocals []*obj
+}
+
+func (e *funcLit) getType() *typ   { return e.ty }
+func (e *funcLit) setType(ty *typ) { e.ty = ty }
+
+// curFunc is the function whose body is parsed, and curLit the innermost
+// function literal in it, if any.
+var curFunc *function
+var curLit *funcLit
+
+// funcLits are the functions of all the function literals.
+var funcLits []*function
+
+// FunctionLit = "func" Signature FunctionBody .
+func parseFuncLit() expression {
+	fl := &funcLit{outer: curLit, outerLocals: locals}
+	fl.fn = &function{name: funcLitName()}
+	savedResults := results
+	locals, results = []*obj{}, []*obj{}
+	curLit = fl
+
+	enterScope()
+	expect("(")
+	fl.fn.params, fl.fn.results = parseSignature()
+	fl.ty = funcType(fl.fn.params, fl.fn.results)
+
+	fl.members = []*member{{name: "F", ty: newLiteralType("int")}}
+	fl.fn.ctx = &obj{name: ".ctx", ty: pointerTo(newStructType(fl.members))}
+	locals = append(locals, fl.fn.ctx)
+
+	expect("{")
+	fl.fn.body = parseBlockStmt()
+	addType(fl.fn.body)
+	leaveScope()
+	fl.fn.locals = locals
+	fl.fn.isValue = len(fl.captures) == 0
+	funcLits = append(funcLits, fl.fn)
+
+	locals, results = fl.outerLocals, savedResults
+	curLit = fl.outer
+	return fl
+}
+
+// funcLitName names the function literals of f f.func1, f.func2 and so on,
+// and those nested in a literal after it, as the Go toolchain does.
+func funcLitName() string {
+	if curLit != nil {
+		curLit.fn.nlits++
+		return fmt.Sprintf("%s.%d", curLit.fn.name, curLit.fn.nlits)
+	}
+	if curFunc == nil {
+		globLits++
+		return fmt.Sprintf("glob..func%d", globLits)
+	}
+	curFunc.nlits++
+	return fmt.Sprintf("%s.func%d", curFunc.name, curFunc.nlits)
+}
+
+var globLits int
+
+// refVar returns the expression that refers to the variable v where it is
+// used.
+func refVar(v *obj) expression {
+	if curLit == nil || v.global || containsObj(locals, v) {
+		return v
+	}
+	return curLit.capture(v)
+}
+
+// capture returns *ctx.v for the variable v of an enclosing function, and
+// captures it in the enclosing literals up to the function declaring it.
+func (fl *funcLit) capture(v *obj) expression {
+	i := 0
+	for i < len(fl.vars) && fl.vars[i] != v {
+		i++
+	}
+	if i == len(fl.vars) {
+		var outer expression = v
+		if !containsObj(fl.outerLocals, v) {
+			outer = fl.outer.capture(v)
+		}
+		fl.vars = append(fl.vars, v)
+		fl.captures = append(fl.captures, outer)
+		fl.members = append(fl.members, &member{name: v.name, ty: pointerTo(v.ty)})
+		*fl.fn.ctx.ty.base = *newStructType(fl.members)
+	}
+	m := fl.members[i+1]
+	ret := &deref{child: &memberRef{child: &deref{child: fl.fn.ctx}, name: m.name, member: m}}
+	addType(ret)
+	return ret
+}
+
+func containsObj(objs []*obj, v *obj) bool {
+	for _, o := range objs {
+		if o == v {
+			return true
+		}
+	}
+	return false
+}
+
+// genFuncLit makes a closure for e. A literal that captures nothing shares
+// a static one.
+func genFuncLit(e *funcLit) {
+	if e.fn.isValue {
+		fmt.Printf("\tlea rax, [rip+main.%s..f]\n", e.fn.name)
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, false)
+		return
+	}
+	ty := e.fn.ctx.ty.base
+	genAlloc(ty, ty.size)
+	fmt.Printf("\tmov rdi, [rsp]\n")
+	fmt.Printf("\tlea rax, [rip+main.%s]\n", e.fn.name)
+	fmt.Printf("\tmov [rdi], rax\n")
+	for i, c := range e.captures {
+		genAddr(c)
+		fmt.Printf("\tpop rax\n")
+		popped(8)
+		fmt.Printf("\tmov rdi, [rsp]\n")
+		fmt.Printf("\tmov [rdi+%d], rax\n", e.members[i+1].offset)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 )
 
 var funcName string
@@ -10,12 +11,21 @@ func codegen(prog *program) {
 
 	fmt.Printf(".intel_syntax noprefix\n")
 
+	emitData(prog)
+
 	fmt.Printf("\t.text\n")
 	fmt.Printf("\tjmp main\n")
 
-	for _, f := range prog.funcs {
+	emitEntry(prog)
+	emitRuntime()
 
-		funcName = f.name
+	funcs := prog.funcs
+	if prog.init != nil {
+		funcs = append([]*function{prog.init}, funcs...)
+	}
+	for _, f := range funcs {
+
+		funcName = "main." + f.name
 
 		fmt.Printf("\t.globl %s\n", funcName)
 		fmt.Printf("%s:\n", funcName)
@@ -23,14 +33,104 @@ func codegen(prog *program) {
 		fmt.Printf("\tmov rbp, rsp\n")
 
 		fmt.Printf("\tsub rsp, %d\n", f.stackSize)
+		temps = nil
+		genFrameZero(f)
+		if f.ctx != nil {
+			fmt.Printf("\tmov [rbp%+d], rdx\n", f.ctx.offset)
+		}
 
 		genStmt(f.body)
+		if len(temps) != 0 {
+			panic("internal error: unbalanced stack")
+		}
 
 		fmt.Printf(".Lreturn.%s:\n", funcName)
+		if f.defers != nil {
+			fmt.Printf("\tcall %s..deferreturn\n", funcName)
+		}
+		if f.copyResults != nil {
+			genStmt(f.copyResults)
+		}
 		fmt.Printf("\tmov rsp, rbp\n")
 		fmt.Printf("\tpop rbp\n")
 		fmt.Printf("\tret\n")
+		if f.defers != nil {
+			genDeferReturn(f)
+		}
 	}
+
+	emitItabs()
+	emitTypeDescs()
+	emitMapTypes()
+	emitGCData(prog, funcs)
+	emitStrings()
+	emitFloats()
+}
+
+// emitData lays out the package-level variables: constant initial values
+// go in .data and everything else starts zeroed in .bss.
+func emitData(prog *program) {
+	for _, gv := range prog.globals {
+		if gv.initData != nil {
+			fmt.Printf("\t.data\n")
+		} else {
+			fmt.Printf("\t.bss\n")
+		}
+		if gv.ty.align > 0 {
+			fmt.Printf("\t.align %d\n", gv.ty.align)
+		}
+		fmt.Printf("main.%s:\n", gv.name)
+
+		if gv.initData == nil {
+			fmt.Printf("\t.zero %d\n", gv.ty.size)
+			continue
+		}
+		for _, b := range gv.initData {
+			fmt.Printf("\t.byte %d\n", b)
+		}
+	}
+
+	// A function value points to a closure whose first word is the code
+	// address; functions without context share a static one.
+	for _, f := range prog.funcs {
+		if !f.isValue {
+			continue
+		}
+		fmt.Printf("\t.section .data.rel.ro\n")
+		fmt.Printf("\t.align 8\n")
+		fmt.Printf("main.%s..f:\n", f.name)
+		fmt.Printf("\t.quad main.%s\n", f.name)
+	}
+}
+
+// emitEntry emits the C entry point, which reads the runtime options,
+// initializes the package-level variables, calls main.main and returns its
+// result as the exit status.
+func emitEntry(prog *program) {
+	fmt.Printf("\t.globl main\n")
+	fmt.Printf("main:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tmov [rip+runtime.stackTop], rbp\n")
+	fmt.Printf("\tcall runtime.args\n")
+	if prog.init != nil {
+		fmt.Printf("\tcall main.init\n")
+	}
+
+	f := funcs["main"]
+	genResultSpace(funcType(f.params, f.results).results)
+	fmt.Printf("\tcall main.main\n")
+	if len(f.results) > 0 {
+		fmt.Printf("\tpop rax\n")
+	} else {
+		fmt.Printf("\tmov rax, 0\n")
+	}
+	fmt.Printf("\tpush rax\n")
+	fmt.Printf("\tcall runtime.gcExit\n")
+	fmt.Printf("\tpop rax\n")
+	fmt.Printf("\tmov rsp, rbp\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
 }
 
 var labelCnt = 0
@@ -56,6 +156,7 @@ func genStmt(stmt statement) {
 
 		genExpr(s.cond)
 		fmt.Printf("\tpop rax\n")
+		popped(8)
 		fmt.Printf("\tcmp rax, 0\n")
 
 		if s.els != nil {
@@ -80,6 +181,7 @@ func genStmt(stmt statement) {
 		if s.cond != nil {
 			genExpr(s.cond)
 			fmt.Printf("\tpop rax\n")
+			popped(8)
 			fmt.Printf("\tcmp rax, 0\n")
 			fmt.Printf("\tje .Lend%d\n", cnt)
 		}
@@ -89,22 +191,74 @@ func genStmt(stmt statement) {
 		}
 		fmt.Printf("\tjmp .Lbegin%d\n", cnt)
 		fmt.Printf(".Lend%d:\n", cnt)
+	case *mapIterStmt:
+		genMapIter(s)
+	case *heapVarStmt:
+		genAlloc(s.v.ty, s.v.ty.size)
+		fmt.Printf("\tpop rax\n")
+		popped(8)
+		fmt.Printf("\tmov [rbp%+d], rax\n", s.v.offset)
+	case *deferStmt:
+		genDefer(s)
 	case *expressionStmt:
 		genExpr(s.child)
-		fmt.Printf("\tadd rsp, 8\n") // discard
+		// discard
+		size := 8
+		switch c := s.child.(type) {
+		case *funcCall:
+			size = c.target.resultsSize
+		case *indirectCall:
+			size = argsSize(c.fn.getType().results)
+		case *ifaceCall:
+			size = argsSize(c.method.ty.results)
+		case *builtinCall:
+			size = 0
+			if c.ty != nil {
+				size = valueSize(c.ty)
+			}
+		}
+		if size > 0 {
+			fmt.Printf("\tadd rsp, %d\n", size)
+			popped(size)
+		}
 	case *assignment:
 		if se := s.rhs.convertSingleMultiValuedExpression(); se != nil {
 			for i := range s.rhs {
 				genExpr(s.rhs[i])
 			}
-			for i := len(s.lhs) - 1; i >= 0; i-- {
-				genAddr(s.lhs[i])
+			if s.temps == nil {
+				for i := len(s.lhs) - 1; i >= 0; i-- {
+					genStoreAddr(s.lhs[i])
+					store(s.lhs[i].getType())
+				}
+				return
+			}
+			for i := len(s.temps) - 1; i >= 0; i-- {
+				genAddr(s.temps[i])
+				store(s.temps[i].ty)
+			}
+			for i := range s.lhs {
+				genExpr(s.convs[i])
+				genStoreAddr(s.lhs[i])
 				store(s.lhs[i].getType())
 			}
 		} else {
-			for i := range s.rhs {
-				genExpr(s.rhs[i])
-				genAddr(s.lhs[i])
+			// all the values are evaluated before any of them is stored
+			isZero := func(e expression) bool {
+				c, ok := e.(*constExpr)
+				return ok && isAggregate(c.ty)
+			}
+			for _, e := range s.rhs {
+				if !isZero(e) {
+					genExpr(e)
+				}
+			}
+			for i := len(s.lhs) - 1; i >= 0; i-- {
+				genStoreAddr(s.lhs[i])
+				if isZero(s.rhs[i]) {
+					genZero(s.rhs[i].getType())
+					continue
+				}
 				store(s.lhs[i].getType())
 			}
 		}
@@ -116,30 +270,169 @@ func genStmt(stmt statement) {
 func genExpr(expr expression) {
 	switch e := expr.(type) {
 	case *funcCall:
-		fmt.Printf("\tsub rsp, %d\n", e.target.resultsSize)
+		genResultSpace(funcType(e.target.params, e.target.results).results)
 		for i := len(e.args) - 1; i >= 0; i-- {
 			genExpr(e.args[i])
 		}
-		fmt.Printf("\tcall %s\n", e.name)
+		genCall("main." + e.name)
 		fmt.Printf("\tadd rsp, %d\n", e.target.paramsSize)
-	case *intLit:
-		fmt.Printf("\tpush %d\n", e.val)
+		popped(e.target.paramsSize)
+	case *indirectCall:
+		ty := e.fn.getType()
+		genResultSpace(ty.results)
+		for i := len(e.args) - 1; i >= 0; i-- {
+			genExpr(e.args[i])
+		}
+		genExpr(e.fn)
+		genNilCheck()
+		fmt.Printf("\tpop rdx\n")
+		popped(8)
+		genCall("[rdx]")
+		fmt.Printf("\tadd rsp, %d\n", argsSize(ty.params))
+		popped(argsSize(ty.params))
+	case *ifaceCall:
+		genResultSpace(e.method.ty.results)
+		for i := len(e.args) - 1; i >= 0; i-- {
+			genExpr(e.args[i])
+		}
+		// the data word is the receiver
+		genExpr(e.recv)
+		genNilCheck()
+		fmt.Printf("\tpop rax\n")
+		popped(8)
+		genCall(fmt.Sprintf("[rax+%d]", 8+8*e.index))
+		fmt.Printf("\tadd rsp, %d\n", argsSize(e.method.ty.params)+8)
+		popped(argsSize(e.method.ty.params) + 8)
+	case *toIface:
+		genToIface(e)
+	case *typeAssert:
+		genTypeAssert(e)
+	case *typeCheck:
+		genTypeCheck(e)
+	case *indexExpr:
+		if e.child.getType().kind == typeKindMap {
+			genMapIndex(e)
+			return
+		}
+		if e.child.getType().kind == typeKindArray && !hasAddr(e.child) {
+			genIndexValue(e)
+			return
+		}
+		genAddr(e)
+		load(e.ty)
+	case *sliceExpr:
+		genSliceExpr(e)
+	case *compositeLit:
+		genCompositeLit(e)
+	case *mapLit:
+		genMapLit(e)
+	case *mapIterOk:
+		genMapIterOk(e)
+	case *builtinCall:
+		genBuiltinCall(e)
+	case *funcLit:
+		genFuncLit(e)
+	case *funcVal:
+		fmt.Printf("\tlea rax, [rip+main.%s..f]\n", e.fn.name)
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, false)
+	case *methodVal:
+		// the closure holds the wrapper and a copy of the receiver
+		fn := e.method.fn
+		genAlloc(fn.valueWrapper.ctx.ty.base, 8+alignTo(fn.params[0].ty.size, 8))
+		genExpr(e.recv)
+		fmt.Printf("\tmov rax, [rsp+%d]\n", valueSize(e.recv.getType()))
+		fmt.Printf("\tadd rax, 8\n")
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, true)
+		store(e.recv.getType())
+		fmt.Printf("\tmov rdi, [rsp]\n")
+		fmt.Printf("\tlea rsi, [rip+main.%s]\n", fn.valueWrapper.name)
+		fmt.Printf("\tmov [rdi], rsi\n")
+	case *constExpr:
+		if isAggregate(e.ty) {
+			// the zero value of an array or struct
+			fmt.Printf("\tsub rsp, %d\n", valueSize(e.ty))
+			fmt.Printf("\tpush rsp\n")
+			pushed(e.ty)
+			pushedWords(1, false)
+			genZero(e.ty)
+			return
+		}
+		// only the zero value of a multi-word type is a constant
+		for i := 8; i < e.ty.size; i += 8 {
+			fmt.Printf("\tpush 0\n")
+		}
+		val := constBits(e)
+		if isFloat(defaultType(e.ty)) {
+			fmt.Printf("\tmov rax, [rip+%s]\n", floatLabel(val))
+			fmt.Printf("\tpush rax\n")
+			pushed(e.ty)
+			return
+		}
+		if val == int64(int32(val)) {
+			fmt.Printf("\tpush %d\n", val)
+		} else {
+			fmt.Printf("\tmov rax, %d\n", val)
+			fmt.Printf("\tpush rax\n")
+		}
+		pushed(e.ty)
 	case *obj:
 		genAddr(e)
 		load(e.ty)
 	case *memberRef:
+		if !hasAddr(e.child) {
+			// the field is taken from the struct value
+			genExpr(e.child)
+			fmt.Printf("\tlea rsi, [rsp+%d]\n", e.member.offset)
+			genExtract(e.child.getType(), e.ty)
+			return
+		}
 		genAddr(e)
 		load(e.ty)
 	case *deref:
 		genExpr(e.child)
+		genNilCheck()
 		load(e.ty)
+	case *nilExpr:
+		for i := 0; i < valueSize(e.ty); i += 8 {
+			fmt.Printf("\tpush 0\n")
+		}
+		pushedWords(valueSize(e.ty)/8, false)
 	case *addr:
+		if _, ok := e.child.(*compositeLit); ok {
+			genHeapLit(e.child)
+			return
+		}
 		genAddr(e.child)
 	case *binary:
+		if e.op == "&&" || e.op == "||" {
+			genLogical(e)
+			return
+		}
+		if isNil(e.lhs) {
+			genNilCompare(e.op, e.rhs)
+			return
+		}
+		if isNil(e.rhs) {
+			genNilCompare(e.op, e.lhs)
+			return
+		}
 		genExpr(e.lhs)
 		genExpr(e.rhs)
 		fmt.Printf("\tpop rdi\n")
 		fmt.Printf("\tpop rax\n")
+		popped(16)
+		if isFloat(e.lhs.getType()) {
+			genFloatBinary(e.op, e.lhs.getType())
+			fmt.Printf("\tpush rax\n")
+			pushedWords(1, false)
+			return
+		}
+		unsigned := isUnsigned(e.lhs.getType())
+		if (e.op == "<<" || e.op == ">>") && !isUnsigned(e.rhs.getType()) {
+			genShiftCheck()
+		}
 		switch e.op {
 		case "+":
 			fmt.Printf("\tadd rax, rdi\n")
@@ -147,16 +440,54 @@ func genExpr(expr expression) {
 			fmt.Printf("\tsub rax, rdi\n")
 		case "*":
 			fmt.Printf("\timul rax, rdi\n")
-		case "/":
-			fmt.Printf("\tcqo\n")
-			fmt.Printf("\tidiv rdi\n")
+		case "/", "%":
+			genDiv(e.op, unsigned)
+		case "&":
+			fmt.Printf("\tand rax, rdi\n")
+		case "|":
+			fmt.Printf("\tor rax, rdi\n")
+		case "^":
+			fmt.Printf("\txor rax, rdi\n")
+		case "&^":
+			fmt.Printf("\tnot rdi\n")
+			fmt.Printf("\tand rax, rdi\n")
+		case "<<":
+			// counts of 64 or more shift out every bit
+			fmt.Printf("\tmov rcx, rdi\n")
+			fmt.Printf("\tshl rax, cl\n")
+			fmt.Printf("\tmov rdx, 0\n")
+			fmt.Printf("\tcmp rdi, 64\n")
+			fmt.Printf("\tcmovae rax, rdx\n")
+		case ">>":
+			if unsigned {
+				// counts of 64 or more shift out every bit
+				fmt.Printf("\tmov rcx, rdi\n")
+				fmt.Printf("\tshr rax, cl\n")
+				fmt.Printf("\tmov rdx, 0\n")
+				fmt.Printf("\tcmp rdi, 64\n")
+				fmt.Printf("\tcmovae rax, rdx\n")
+				break
+			}
+			// counts of 64 or more leave only the sign
+			fmt.Printf("\tmov rcx, 63\n")
+			fmt.Printf("\tcmp rdi, 63\n")
+			fmt.Printf("\tcmovbe rcx, rdi\n")
+			fmt.Printf("\tsar rax, cl\n")
 		case "<":
 			fmt.Printf("\tcmp rax, rdi\n")
-			fmt.Printf("\tsetl al\n")
+			if unsigned {
+				fmt.Printf("\tsetb al\n")
+			} else {
+				fmt.Printf("\tsetl al\n")
+			}
 			fmt.Printf("\tmovzb rax, al\n")
 		case "<=":
 			fmt.Printf("\tcmp rax, rdi\n")
-			fmt.Printf("\tsetle al\n")
+			if unsigned {
+				fmt.Printf("\tsetbe al\n")
+			} else {
+				fmt.Printf("\tsetle al\n")
+			}
 			fmt.Printf("\tmovzb rax, al\n")
 		case "==":
 			fmt.Printf("\tcmp rax, rdi\n")
@@ -167,53 +498,916 @@ func genExpr(expr expression) {
 			fmt.Printf("\tsetne al\n")
 			fmt.Printf("\tmovzb rax, al\n")
 		}
+		genWrap(e.ty)
 		fmt.Printf("\tpush rax\n")
+		pushedWords(1, false)
 		return
+	case *conversion:
+		genConversion(e)
+	case *unary:
+		genExpr(e.child)
+		fmt.Printf("\tpop rax\n")
+		popped(8)
+		switch {
+		case e.op == "^":
+			fmt.Printf("\tnot rax\n")
+		case isFloat(e.ty):
+			// flip the sign bit, so that -0 and NaN negate too
+			fmt.Printf("\tbtc rax, %d\n", 8*e.ty.size-1)
+		default:
+			fmt.Printf("\tneg rax\n")
+		}
+		genWrap(e.ty)
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, false)
 	default:
 		panic(fmt.Sprintf("Unsupport expression type: %T\n", e))
 	}
 }
 
-func load(ty *typ) {
+// genShiftCheck panics if the signed shift count in rdi is negative.
+func genShiftCheck() {
+	labelCnt++
+	fmt.Printf("\ttest rdi, rdi\n")
+	fmt.Printf("\tjns .Lshift.nonneg%d\n", labelCnt)
+	genCall("runtime.panicShift")
+	fmt.Printf(".Lshift.nonneg%d:\n", labelCnt)
+}
+
+// genDiv divides rax by rdi and leaves the quotient or, for op %, the
+// remainder in rax. The most negative signed value divided by -1 is itself
+// with remainder 0 instead of a fault, and a zero divisor panics.
+func genDiv(op string, unsigned bool) {
+	labelCnt++
+	fmt.Printf("\ttest rdi, rdi\n")
+	fmt.Printf("\tjnz .Ldiv.nonzero%d\n", labelCnt)
+	genCall("runtime.panicDivide")
+	fmt.Printf(".Ldiv.nonzero%d:\n", labelCnt)
+	if unsigned {
+		fmt.Printf("\tmov rdx, 0\n")
+		fmt.Printf("\tdiv rdi\n")
+		if op == "%" {
+			fmt.Printf("\tmov rax, rdx\n")
+		}
+		return
+	}
+	labelCnt++
+	cnt := labelCnt
+	fmt.Printf("\tcmp rdi, -1\n")
+	fmt.Printf("\tjne .Ldiv%d\n", cnt)
+	if op == "%" {
+		fmt.Printf("\tmov rax, 0\n")
+	} else {
+		fmt.Printf("\tneg rax\n")
+	}
+	fmt.Printf("\tjmp .Ldiv.end%d\n", cnt)
+	fmt.Printf(".Ldiv%d:\n", cnt)
+	fmt.Printf("\tcqo\n")
+	fmt.Printf("\tidiv rdi\n")
+	if op == "%" {
+		fmt.Printf("\tmov rax, rdx\n")
+	}
+	fmt.Printf(".Ldiv.end%d:\n", cnt)
+}
+
+// genWrap truncates the result in rax to the size of the integer type ty
+// and extends it back to 64 bits, so that it wraps around like the type.
+// Integers are kept extended to 64 bits on the stack.
+func genWrap(ty *typ) {
+	if !isInteger(ty) {
+		return
+	}
+	signed := !isUnsigned(ty)
+	switch {
+	case ty.size == 1 && signed:
+		fmt.Printf("\tmovsx rax, al\n")
+	case ty.size == 1:
+		fmt.Printf("\tmovzx eax, al\n")
+	case ty.size == 2 && signed:
+		fmt.Printf("\tmovsx rax, ax\n")
+	case ty.size == 2:
+		fmt.Printf("\tmovzx eax, ax\n")
+	case ty.size == 4 && signed:
+		fmt.Printf("\tmovsxd rax, eax\n")
+	case ty.size == 4:
+		fmt.Printf("\tmov eax, eax\n")
+	}
+}
+
+// genLoadScalar loads the scalar of type ty at the address in reg into rax,
+// sign-extended if it is a signed integer and zero-extended otherwise.
+func genLoadScalar(ty *typ, reg string) {
+	signed := isInteger(ty) && !isUnsigned(ty)
+	switch {
+	case ty.size == 1 && signed:
+		fmt.Printf("\tmovsx rax, byte ptr [%s]\n", reg)
+	case ty.size == 1:
+		fmt.Printf("\tmovzx rax, byte ptr [%s]\n", reg)
+	case ty.size == 2 && signed:
+		fmt.Printf("\tmovsx rax, word ptr [%s]\n", reg)
+	case ty.size == 2:
+		fmt.Printf("\tmovzx rax, word ptr [%s]\n", reg)
+	case ty.size == 4 && signed:
+		fmt.Printf("\tmovsxd rax, dword ptr [%s]\n", reg)
+	case ty.size == 4:
+		fmt.Printf("\tmov eax, dword ptr [%s]\n", reg)
+	default:
+		fmt.Printf("\tmov rax, [%s]\n", reg)
+	}
+}
+
+// genResultSpace reserves the results of types tys of a call. They start
+// zeroed, so results narrower than a word are read back as whole words.
+func genResultSpace(tys []*typ) {
+	for i := 0; i < argsSize(tys); i += 8 {
+		fmt.Printf("\tpush 0\n")
+	}
+	pushedArgs(tys)
+}
+
+// genLogical evaluates the right operand of && and || only if the left one
+// does not decide the result.
+func genLogical(e *binary) {
+	labelCnt++
+	cnt := labelCnt
+
+	genExpr(e.lhs)
+	fmt.Printf("\tpop rax\n")
+	popped(8)
+	fmt.Printf("\tcmp rax, 0\n")
+	if e.op == "&&" {
+		fmt.Printf("\tje .Lfalse%d\n", cnt)
+	} else {
+		fmt.Printf("\tjne .Ltrue%d\n", cnt)
+	}
+	genExpr(e.rhs)
+	fmt.Printf("\tpop rax\n")
+	popped(8)
+	fmt.Printf("\tcmp rax, 0\n")
+	fmt.Printf("\tje .Lfalse%d\n", cnt)
+	fmt.Printf(".Ltrue%d:\n", cnt)
+	fmt.Printf("\tpush 1\n")
+	fmt.Printf("\tjmp .Lend%d\n", cnt)
+	fmt.Printf(".Lfalse%d:\n", cnt)
+	fmt.Printf("\tpush 0\n")
+	fmt.Printf(".Lend%d:\n", cnt)
+	pushedWords(1, false)
+}
+
+// genToIface converts a value to an interface. A concrete value is paired
+// with its itab or type descriptor; an interface value gets the itab of its
+// dynamic type for the new interface.
+func genToIface(e *toIface) {
+	ty := e.child.getType()
+	if ty.kind == typeKindInterface {
+		genExpr(e.child)
+		fmt.Printf("\tpop rax\n")
+		popped(8)
+		genDynamicType(ty)
+		if !isEmptyInterface(e.ty) {
+			fmt.Printf("\tlea rsi, [rip+itabs.%s]\n", typeDesc(e.ty))
+			genCall("runtime.getitab")
+		}
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, false)
+		return
+	}
+
+	if isPointerShaped(ty) {
+		genExpr(e.child)
+	} else {
+		genAlloc(ty, ty.size)
+		genExpr(e.child)
+		fmt.Printf("\tmov rax, [rsp+%d]\n", valueSize(ty))
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, true)
+		store(ty)
+	}
+	if isEmptyInterface(e.ty) {
+		fmt.Printf("\tlea rax, [rip+%s]\n", typeDesc(ty))
+	} else {
+		fmt.Printf("\tlea rax, [rip+itab.%d]\n", getItab(ty, e.ty).id)
+	}
+	fmt.Printf("\tpush rax\n")
+	pushedWords(1, false)
+}
+
+// genDynamicType replaces the first word of an interface value of type ty
+// in rax with its type descriptor, or 0 if the interface is nil.
+func genDynamicType(ty *typ) {
+	if isEmptyInterface(ty) {
+		return
+	}
+	labelCnt++
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lnil%d\n", labelCnt)
+	fmt.Printf("\tmov rax, [rax]\n")
+	fmt.Printf(".Lnil%d:\n", labelCnt)
+}
+
+func genTypeAssert(e *typeAssert) {
+	ity := e.child.getType()
+	ty := e.ty
+	genExpr(e.child)
+	fmt.Printf("\tpop rax\n")
+	genDynamicType(ity)
+	fmt.Printf("\tpop rdi\n")
+	popped(16)
+	base := len(temps)
+
+	labelCnt++
+	cnt := labelCnt
+	switch {
+	case isEmptyInterface(ty):
+		fmt.Printf("\ttest rax, rax\n")
+		fmt.Printf("\tjz .Lfail%d\n", cnt)
+		fmt.Printf("\tpush rdi\n")
+		fmt.Printf("\tpush rax\n")
+		pushed(ty)
+	case ty.kind == typeKindInterface:
+		fmt.Printf("\tmov r8, rax\n")
+		fmt.Printf("\tlea rsi, [rip+itabs.%s]\n", typeDesc(ty))
+		genCall("runtime.getitab")
+		fmt.Printf("\ttest rax, rax\n")
+		fmt.Printf("\tjz .Lfail%d\n", cnt)
+		fmt.Printf("\tpush rdi\n")
+		fmt.Printf("\tpush rax\n")
+		pushed(ty)
+	default:
+		fmt.Printf("\tlea rsi, [rip+%s]\n", typeDesc(ty))
+		fmt.Printf("\tcmp rax, rsi\n")
+		fmt.Printf("\tjne .Lfail%d\n", cnt)
+		fmt.Printf("\tpush rdi\n")
+		pushedWords(1, true)
+		if !isPointerShaped(ty) {
+			load(ty)
+		}
+	}
+	if e.commaOk {
+		fmt.Printf("\tpush 1\n")
+		pushedWords(1, false)
+	}
+	fmt.Printf("\tjmp .Lend%d\n", cnt)
+
+	// the failure starts from the stack before the value
+	after := append([]bool{}, temps...)
+	temps = temps[:base]
+	fmt.Printf(".Lfail%d:\n", cnt)
+	if e.commaOk {
+		for i := 0; i < ty.size; i += 8 {
+			fmt.Printf("\tpush 0\n")
+		}
+		fmt.Printf("\tpush 0\n")
+	} else if ty.kind == typeKindInterface {
+		if isEmptyInterface(ty) {
+			fmt.Printf("\tmov r8, 0\n")
+		}
+		fmt.Printf("\tmov rax, r8\n")
+		fmt.Printf("\tlea rsi, [rip+%s]\n", typeDesc(ty))
+		genCall("runtime.panicAssertI")
+	} else {
+		fmt.Printf("\tlea rdx, [rip+%s]\n", typeDesc(ity))
+		genCall("runtime.panicAssert")
+	}
+	fmt.Printf(".Lend%d:\n", cnt)
+	temps = after
+}
+
+// genTypeCheck compares the dynamic type of an interface value with the
+// type descriptors of the cases of a type switch.
+func genTypeCheck(e *typeCheck) {
+	labelCnt++
+	cnt := labelCnt
+	genExpr(e.child)
+	fmt.Printf("\tpop rax\n")
+	fmt.Printf("\tpop rdi\n")
+	popped(16)
+	genDynamicType(e.child.getType())
+	for _, ty := range e.types {
+		switch {
+		case ty == nil:
+			fmt.Printf("\ttest rax, rax\n")
+			fmt.Printf("\tjz .Ltrue%d\n", cnt)
+		case isEmptyInterface(ty):
+			fmt.Printf("\ttest rax, rax\n")
+			fmt.Printf("\tjnz .Ltrue%d\n", cnt)
+		case ty.kind == typeKindInterface:
+			fmt.Printf("\tmov r8, rax\n")
+			fmt.Printf("\tlea rsi, [rip+itabs.%s]\n", typeDesc(ty))
+			genCall("runtime.getitab")
+			fmt.Printf("\ttest rax, rax\n")
+			fmt.Printf("\tmov rax, r8\n")
+			fmt.Printf("\tjnz .Ltrue%d\n", cnt)
+		default:
+			fmt.Printf("\tlea rsi, [rip+%s]\n", typeDesc(ty))
+			fmt.Printf("\tcmp rax, rsi\n")
+			fmt.Printf("\tje .Ltrue%d\n", cnt)
+		}
+	}
+	fmt.Printf("\tpush 0\n")
+	fmt.Printf("\tjmp .Lend%d\n", cnt)
+	fmt.Printf(".Ltrue%d:\n", cnt)
+	fmt.Printf("\tpush 1\n")
+	fmt.Printf(".Lend%d:\n", cnt)
+	pushedWords(1, false)
+}
+
+// isMultiWord reports whether a value of type ty takes several stack
+// words, which are in memory order on the stack.
+func isMultiWord(ty *typ) bool {
+	return ty.kind == typeKindInterface || ty.kind == typeKindSlice
+}
+
+// isAggregate reports whether a value of type ty is an array or a struct,
+// which are copied as blocks of memory.
+func isAggregate(ty *typ) bool {
+	return ty.kind == typeKindArray || ty.kind == typeKindStruct
+}
+
+// genSliceHeader pushes the pointer, length and capacity of the array or
+// slice e in the order of a slice value.
+func genSliceHeader(e expression) {
+	ty := e.getType()
 	if ty.kind == typeKindArray {
+		genAddr(e)
+	} else {
+		genExpr(e)
+	}
+	if ty.kind == typeKindPtr {
+		genNilCheck()
+	}
+	if ty := indexedType(ty); ty.kind == typeKindArray {
+		fmt.Printf("\tpop rax\n")
+		fmt.Printf("\tpush %d\n", ty.length)
+		fmt.Printf("\tpush %d\n", ty.length)
+		fmt.Printf("\tpush rax\n")
+		popped(8)
+		pushedWords(2, false)
+		pushedWords(1, true)
+	}
+}
+
+// genIndexAddr pushes the address of an element after checking the index
+// against the length.
+func genIndexAddr(e *indexExpr) {
+	ty := indexedType(e.child.getType())
+	genSliceHeader(e.child)
+	genExpr(e.idx)
+	fmt.Printf("\tpop rdi\n")
+	fmt.Printf("\tpop rax\n")
+	fmt.Printf("\tpop rcx\n")
+	fmt.Printf("\tadd rsp, 8\n")
+	popped(32)
+
+	labelCnt++
+	fmt.Printf("\tcmp rdi, rcx\n")
+	fmt.Printf("\tjb .Linbounds%d\n", labelCnt)
+	fmt.Printf("\tmov rax, rdi\n")
+	genCall("runtime.panicIndex")
+	fmt.Printf(".Linbounds%d:\n", labelCnt)
+	fmt.Printf("\timul rdi, rdi, %d\n", ty.base.size)
+	fmt.Printf("\tadd rax, rdi\n")
+	fmt.Printf("\tpush rax\n")
+	pushedWords(1, true)
+}
+
+// genIndexValue pushes an element of an array value that has no address
+// after checking the index against the length.
+func genIndexValue(e *indexExpr) {
+	ty := e.child.getType()
+	genExpr(e.child)
+	genExpr(e.idx)
+	fmt.Printf("\tpop rdi\n")
+	popped(8)
+
+	labelCnt++
+	fmt.Printf("\tcmp rdi, %d\n", ty.length)
+	fmt.Printf("\tjb .Linbounds%d\n", labelCnt)
+	fmt.Printf("\tmov rax, rdi\n")
+	fmt.Printf("\tmov rcx, %d\n", ty.length)
+	genCall("runtime.panicIndex")
+	fmt.Printf(".Linbounds%d:\n", labelCnt)
+	fmt.Printf("\timul rdi, rdi, %d\n", ty.base.size)
+	fmt.Printf("\tlea rsi, [rsp+rdi]\n")
+	genExtract(ty, e.ty)
+}
+
+// genExtract replaces the array or struct value of type outer on top of the
+// stack with its part of type ty at the address in rsi.
+func genExtract(outer, ty *typ) {
+	size := valueSize(outer)
+	if isMultiWord(ty) || isAggregate(ty) {
+		rest := size - valueSize(ty)
+		fmt.Printf("\tlea rdi, [rsp+%d]\n", rest)
+		fmt.Printf("\tmov rcx, %d\n", ty.size)
+		genCall("runtime.memmove")
+		fmt.Printf("\tadd rsp, %d\n", rest)
+		popped(size)
+		pushed(ty)
 		return
 	}
+	genLoadScalar(ty, "rsi")
+	fmt.Printf("\tadd rsp, %d\n", size)
+	fmt.Printf("\tpush rax\n")
+	popped(size)
+	pushed(ty)
+}
+
+// hasAddr reports whether the address of e can be pushed, which is the case
+// for addressable operands and composite literals.
+func hasAddr(e expression) bool {
+	_, ok := e.(*compositeLit)
+	return ok || addressable(e)
+}
+
+// genSliceExpr checks 0 <= lo <= hi <= max <= cap and pushes the slice
+// value.
+func genSliceExpr(e *sliceExpr) {
+	size := indexedType(e.child.getType()).base.size
+	genSliceHeader(e.child)
+	if e.lo != nil {
+		genExpr(e.lo)
+	} else {
+		fmt.Printf("\tpush 0\n")
+		pushedWords(1, false)
+	}
+	if e.hi != nil {
+		genExpr(e.hi)
+	} else {
+		// the length
+		fmt.Printf("\tpush [rsp+16]\n")
+		pushedWords(1, false)
+	}
+	if e.max != nil {
+		genExpr(e.max)
+	} else {
+		// the capacity
+		fmt.Printf("\tpush [rsp+32]\n")
+		pushedWords(1, false)
+	}
+	fmt.Printf("\tpop r8\n")
+	fmt.Printf("\tpop rsi\n")
+	fmt.Printf("\tpop rdi\n")
 	fmt.Printf("\tpop rax\n")
-	if ty.size == 1 {
-		fmt.Printf("\tmovzx rax, byte ptr [rax]\n")
+	fmt.Printf("\tadd rsp, 8\n")
+	fmt.Printf("\tpop rdx\n")
+	popped(48)
+
+	labelCnt++
+	cnt := labelCnt
+	check := func(x, y, fn string) {
+		fmt.Printf("\tcmp %s, %s\n", x, y)
+		fmt.Printf("\tjbe .Lslice%d.%s\n", cnt, x)
+		fmt.Printf("\tmov rax, %s\n", x)
+		fmt.Printf("\tmov rcx, %s\n", y)
+		genCall("runtime." + fn)
+		fmt.Printf(".Lslice%d.%s:\n", cnt, x)
+	}
+	if e.max != nil {
+		check("r8", "rdx", "panicSlice3Acap")
+		check("rsi", "r8", "panicSlice3B")
+		check("rdi", "rsi", "panicSlice3C")
 	} else {
-		fmt.Printf("\tmov rax, [rax]\n")
+		check("rsi", "rdx", "panicSliceAcap")
+		check("rdi", "rsi", "panicSliceB")
 	}
+
+	// the pointer stays at the base if the new slice has no capacity so
+	// that it never points past the array
+	fmt.Printf("\tsub r8, rdi\n")
+	fmt.Printf("\tsub rsi, rdi\n")
+	fmt.Printf("\timul rdi, rdi, %d\n", size)
+	fmt.Printf("\tmov rcx, 0\n")
+	fmt.Printf("\ttest r8, r8\n")
+	fmt.Printf("\tcmovz rdi, rcx\n")
+	fmt.Printf("\tadd rax, rdi\n")
+	fmt.Printf("\tpush r8\n")
+	fmt.Printf("\tpush rsi\n")
+	fmt.Printf("\tpush rax\n")
+	pushedWords(2, false)
+	pushedWords(1, true)
+}
+
+// genCompositeLit pushes the value of a composite literal. An array or
+// struct is built in its temporary and a slice in a new array.
+func genCompositeLit(e *compositeLit) {
+	if e.ty.kind == typeKindSlice {
+		genAlloc(e.ty.base, e.length*e.ty.base.size)
+		genLitElems(e, 0)
+		fmt.Printf("\tpop rax\n")
+		fmt.Printf("\tpush %d\n", e.length)
+		fmt.Printf("\tpush %d\n", e.length)
+		fmt.Printf("\tpush rax\n")
+		popped(8)
+		pushedWords(2, false)
+		pushedWords(1, true)
+		return
+	}
+	genLitAddr(e)
+	load(e.ty)
+}
+
+// genLitAddr builds the array or struct literal e in its temporary and
+// pushes the address of the temporary.
+func genLitAddr(e *compositeLit) {
+	genAddr(e.tmp)
+	genAddr(e.tmp)
+	genZero(e.ty)
+	genLitElems(e, 0)
+}
+
+// genHeapLit pushes the address of a new variable holding the value of the
+// literal e, which is what &e yields.
+func genHeapLit(e expression) {
+	ty := e.getType()
+	genAlloc(ty, ty.size)
+	if c, ok := e.(*compositeLit); ok && c.ty.kind != typeKindSlice {
+		genLitElems(c, 0)
+		return
+	}
+	genExpr(e)
+	fmt.Printf("\tpush [rsp+%d]\n", valueSize(ty))
+	pushedWords(1, true)
+	store(ty)
+}
+
+// genLitElems stores the elements of e in zeroed memory at the address on
+// top of the stack plus off. Nested array and struct literals are stored
+// in place.
+func genLitElems(e *compositeLit, off int) {
+	for i, el := range e.elems {
+		if c, ok := el.(*compositeLit); ok && c.ty.kind != typeKindSlice {
+			genLitElems(c, off+e.offsets[i])
+			continue
+		}
+		genExpr(el)
+		fmt.Printf("\tmov rax, [rsp+%d]\n", valueSize(el.getType()))
+		fmt.Printf("\tadd rax, %d\n", off+e.offsets[i])
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, true)
+		store(el.getType())
+	}
+}
+
+// valueSize is the size of the stack words holding a value of type ty.
+func valueSize(ty *typ) int {
+	if isMultiWord(ty) || isAggregate(ty) {
+		return alignTo(ty.size, 8)
+	}
+	return 8
+}
+
+func genBuiltinCall(e *builtinCall) {
+	switch e.name {
+	case "len", "cap":
+		ty := indexedType(e.args[0].getType())
+		genExpr(e.args[0])
+		if ty.kind == typeKindMap {
+			// the count is the first word of the header
+			labelCnt++
+			fmt.Printf("\tpop rax\n")
+			fmt.Printf("\ttest rax, rax\n")
+			fmt.Printf("\tjz .Llen%d\n", labelCnt)
+			fmt.Printf("\tmov rax, [rax]\n")
+			fmt.Printf(".Llen%d:\n", labelCnt)
+			fmt.Printf("\tpush rax\n")
+			popped(8)
+			pushedWords(1, false)
+			return
+		}
+		if ty.kind == typeKindArray {
+			fmt.Printf("\tadd rsp, %d\n", valueSize(e.args[0].getType()))
+			fmt.Printf("\tpush %d\n", ty.length)
+			popped(valueSize(e.args[0].getType()))
+			pushedWords(1, false)
+			return
+		}
+		fmt.Printf("\tpop rax\n")
+		fmt.Printf("\tpop rax\n")
+		fmt.Printf("\tpop rcx\n")
+		if e.name == "cap" {
+			fmt.Printf("\tmov rax, rcx\n")
+		}
+		fmt.Printf("\tpush rax\n")
+		popped(24)
+		pushedWords(1, false)
+
+	case "new":
+		genAlloc(e.typeArg, e.typeArg.size)
+
+	case "make":
+		if e.ty.kind == typeKindMap {
+			if len(e.args) == 1 {
+				genExpr(e.args[0])
+				fmt.Printf("\tpop rsi\n")
+				popped(8)
+			} else {
+				fmt.Printf("\tmov rsi, 0\n")
+			}
+			fmt.Printf("\tlea rdi, [rip+%s]\n", mapType(e.ty))
+			genCall("runtime.makemap")
+			fmt.Printf("\tpush rax\n")
+			pushedWords(1, true)
+			return
+		}
+		fmt.Printf("\tsub rsp, 24\n")
+		pushedWords(3, true)
+		if len(e.args) == 2 {
+			genExpr(e.args[1])
+			genExpr(e.args[0])
+		} else {
+			genExpr(e.args[0])
+			fmt.Printf("\tpush [rsp]\n")
+			pushedWords(1, false)
+		}
+		fmt.Printf("\tlea rax, [rip+%s]\n", typeDesc(e.ty.base))
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, false)
+		genCall("runtime.makeslice")
+		fmt.Printf("\tadd rsp, 24\n")
+		popped(48)
+		pushed(e.ty)
+
+	case "append":
+		genAppend(e)
+
+	case "delete":
+		genMapCall("mapdelete", e.args[0], e.args[1])
+
+	case "copy":
+		genExpr(e.args[0])
+		genExpr(e.args[1])
+		fmt.Printf("\tpop rsi\n")
+		fmt.Printf("\tpop rcx\n")
+		fmt.Printf("\tadd rsp, 8\n")
+		fmt.Printf("\tpop rdi\n")
+		fmt.Printf("\tpop rdx\n")
+		fmt.Printf("\tadd rsp, 8\n")
+		fmt.Printf("\tcmp rdx, rcx\n")
+		fmt.Printf("\tcmovb rcx, rdx\n")
+		fmt.Printf("\tpush rcx\n")
+		popped(48)
+		pushedWords(1, false)
+		fmt.Printf("\timul rcx, rcx, %d\n", e.args[0].getType().base.size)
+		genCall("runtime.memmove")
+
+	case "panic":
+		// runtime.gopanic does not return
+		genExpr(e.args[0])
+		genCall("runtime.gopanic")
+		popped(16)
+
+	case "recover":
+		fmt.Printf("\tmov rax, rbp\n")
+		genCall("runtime.gorecover")
+		fmt.Printf("\tpush rdx\n")
+		fmt.Printf("\tpush rax\n")
+		pushed(e.ty)
+	}
+}
+
+// genAppend grows the slice to hold the new elements, then stores them
+// after the old ones.
+func genAppend(e *builtinCall) {
+	elem := e.ty.base
+	fmt.Printf("\tsub rsp, 24\n")
+	pushedWords(3, true)
+	genExpr(e.args[0])
+	if e.spread {
+		genExpr(e.args[1])
+		genAddr(e.tmp)
+		store(e.tmp.ty)
+		fmt.Printf("\tlea rax, [rip+%s]\n", typeDesc(elem))
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, false)
+		genAddr(e.tmp)
+		fmt.Printf("\tpop rax\n")
+		popped(8)
+		fmt.Printf("\tpush [rax+8]\n")
+	} else {
+		fmt.Printf("\tlea rax, [rip+%s]\n", typeDesc(elem))
+		fmt.Printf("\tpush rax\n")
+		fmt.Printf("\tpush %d\n", len(e.args)-1)
+		pushedWords(1, false)
+	}
+	pushedWords(1, false)
+	genCall("runtime.growslice")
+	fmt.Printf("\tadd rsp, 40\n")
+	popped(64)
+	pushed(e.ty)
+
+	if e.spread {
+		genAddr(e.tmp)
+		fmt.Printf("\tpop rdx\n")
+		popped(8)
+		fmt.Printf("\tmov rcx, [rdx+8]\n")
+		fmt.Printf("\timul rcx, rcx, %d\n", elem.size)
+		fmt.Printf("\tmov rdi, [rsp+8]\n")
+		fmt.Printf("\timul rdi, rdi, %d\n", elem.size)
+		fmt.Printf("\tsub rdi, rcx\n")
+		fmt.Printf("\tadd rdi, [rsp]\n")
+		fmt.Printf("\tmov rsi, [rdx]\n")
+		genCall("runtime.memmove")
+		return
+	}
+
+	n := len(e.args) - 1
+	if n == 0 {
+		return
+	}
+	genAddr(e.tmp)
+	store(e.tmp.ty)
+	for i, arg := range e.args[1:] {
+		genExpr(arg)
+		genAddr(e.tmp)
+		fmt.Printf("\tpop rdx\n")
+		popped(8)
+		fmt.Printf("\tmov rax, [rdx+8]\n")
+		fmt.Printf("\tsub rax, %d\n", n-i)
+		fmt.Printf("\timul rax, rax, %d\n", elem.size)
+		fmt.Printf("\tadd rax, [rdx]\n")
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, true)
+		store(elem)
+	}
+	genAddr(e.tmp)
+	load(e.tmp.ty)
+}
+
+func load(ty *typ) {
+	if isAggregate(ty) {
+		// the value is copied to the stack in memory order
+		fmt.Printf("\tpop rsi\n")
+		fmt.Printf("\tsub rsp, %d\n", valueSize(ty))
+		popped(8)
+		pushed(ty)
+		fmt.Printf("\tmov rdi, rsp\n")
+		fmt.Printf("\tmov rcx, %d\n", ty.size)
+		genCall("runtime.memmove")
+		return
+	}
+	fmt.Printf("\tpop rax\n")
+	popped(8)
+	pushed(ty)
+	if isMultiWord(ty) {
+		// the value is in memory order on the stack
+		for i := ty.size - 8; i >= 0; i -= 8 {
+			fmt.Printf("\tpush [rax+%d]\n", i)
+		}
+		return
+	}
+	genLoadScalar(ty, "rax")
 	fmt.Printf("\tpush rax\n")
 }
 
 func store(ty *typ) {
 	fmt.Printf("\tpop rdi\n")
+	popped(8)
+	if isAggregate(ty) {
+		fmt.Printf("\tmov rsi, rsp\n")
+		fmt.Printf("\tmov rcx, %d\n", ty.size)
+		genCall("runtime.memmove")
+		fmt.Printf("\tadd rsp, %d\n", valueSize(ty))
+		popped(valueSize(ty))
+		return
+	}
+	popped(valueSize(ty))
+	if isMultiWord(ty) {
+		for i := 0; i < ty.size; i += 8 {
+			fmt.Printf("\tpop rax\n")
+			fmt.Printf("\tmov [rdi+%d], rax\n", i)
+		}
+		return
+	}
 	fmt.Printf("\tpop rax\n")
-	if ty.size == 1 {
+	switch ty.size {
+	case 1:
 		fmt.Printf("\tmov [rdi], al\n")
-	} else {
+	case 2:
+		fmt.Printf("\tmov [rdi], ax\n")
+	case 4:
+		fmt.Printf("\tmov [rdi], eax\n")
+	default:
 		fmt.Printf("\tmov [rdi], rax\n")
 	}
 }
 
+// genZero stores the zero value of type ty at the address on top of the
+// stack.
+func genZero(ty *typ) {
+	fmt.Printf("\tpop rdi\n")
+	popped(8)
+	fmt.Printf("\tmov rcx, %d\n", ty.size)
+	fmt.Printf("\tmov al, 0\n")
+	fmt.Printf("\trep stosb\n")
+}
+
 func genAddr(expr expression) {
 	switch e := expr.(type) {
 	case *obj:
-		fmt.Printf("\tlea rax, [rbp%+d]\n", e.offset)
+		if e.global {
+			fmt.Printf("\tlea rax, [rip+main.%s]\n", e.name)
+		} else if e.onHeap {
+			fmt.Printf("\tmov rax, [rbp%+d]\n", e.offset)
+		} else {
+			fmt.Printf("\tlea rax, [rbp%+d]\n", e.offset)
+		}
 		fmt.Printf("\tpush rax\n")
+		pushedWords(1, true)
 	case *compositeLit:
-		for _, elem := range e.elems {
-			genExpr(elem)
+		if e.ty.kind == typeKindSlice {
+			panic("not a value")
 		}
+		genLitAddr(e)
 	case *deref:
 		genExpr(e.child)
+		genNilCheck()
 	case *memberRef:
 		genAddr(e.child)
 		fmt.Printf("\tpop rax\n")
 		fmt.Printf("\tadd rax, %d\n", e.member.offset)
 		fmt.Printf("\tpush rax\n")
+	case *indexExpr:
+		if e.child.getType().kind == typeKindMap {
+			genMapCall("mapaccess", e.child, e.idx)
+			fmt.Printf("\tpush rax\n")
+			pushedWords(1, true)
+			return
+		}
+		genIndexAddr(e)
 	default:
 		panic("not a value")
 	}
 }
+
+// emitItabs emits the itabs, and for every interface type that is looked
+// up at run time the table of all dynamic types with their itabs or the
+// method they are missing.
+func emitItabs() {
+	for _, it := range itabs {
+		fmt.Printf("\t.section .data.rel.ro\n")
+		fmt.Printf("\t.align 8\n")
+		fmt.Printf("itab.%d:\n", it.id)
+		fmt.Printf("\t.quad %s\n", typeDesc(it.concrete))
+		for _, fn := range it.fns {
+			fmt.Printf("\t.quad main.%s\n", fn.name)
+		}
+	}
+
+	for _, iface := range assertIfaces {
+		fmt.Printf("\t.section .data.rel.ro\n")
+		fmt.Printf("\t.align 8\n")
+		fmt.Printf("itabs.%s:\n", typeDesc(iface))
+		for _, ty := range boxedTypes {
+			fmt.Printf("\t.quad %s\n", typeDesc(ty))
+			if m := missingMethod(ty, iface); m != "" {
+				fmt.Printf("\t.quad 0\n")
+				fmt.Printf("\t.quad %s\n", stringLabel(m))
+				fmt.Printf("\t.quad %d\n", len(m))
+			} else {
+				fmt.Printf("\t.quad itab.%d\n", getItab(ty, iface).id)
+				fmt.Printf("\t.quad 0\n")
+				fmt.Printf("\t.quad 0\n")
+			}
+		}
+		fmt.Printf("\t.quad 0\n")
+	}
+}
+
+// emitTypeDescs emits a descriptor for each type that needs one at run
+// time: its size, kind, name and pointer bitmap.
+func emitTypeDescs() {
+	for i := 0; i < len(typeDescs); i++ {
+		ty := typeDescs[i]
+		name := ty.format("main.")
+		fmt.Printf("\t.section .data.rel.ro\n")
+		fmt.Printf("\t.align 8\n")
+		fmt.Printf("type.%d:\n", i)
+		fmt.Printf("\t.quad %d\n", ty.size)
+		fmt.Printf("\t.quad %d\n", ty.kind)
+		fmt.Printf("\t.quad %s\n", stringLabel(name))
+		fmt.Printf("\t.quad %d\n", len(name))
+		fmt.Printf("\t.quad %s\n", typeGCData(ty))
+	}
+}
+
+var stringLits []string
+
+// stringLabel returns the label of the bytes of s in .rodata.
+func stringLabel(s string) string {
+	for i, lit := range stringLits {
+		if lit == s {
+			return fmt.Sprintf("str.%d", i)
+		}
+	}
+	stringLits = append(stringLits, s)
+	return fmt.Sprintf("str.%d", len(stringLits)-1)
+}
+
+func emitStrings() {
+	fmt.Printf("\t.section .rodata\n")
+	for i, lit := range stringLits {
+		fmt.Printf("str.%d:\n", i)
+		fmt.Printf("\t.ascii %s\n", strconv.Quote(lit))
+	}
+}
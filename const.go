@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"go/constant"
+	gotoken "go/token"
+	"math"
+	"strings"
+)
+
+// Constants are evaluated with arbitrary precision as long as they are
+// untyped, and must be representable by their type once they get one.
+
+var curIota = -1
+
+// constObj is a named constant.
+type constObj struct {
+	ty  *typ
+	val constant.Value
+
+	decl *constDecl
+}
+
+// constDecl is a ConstSpec. A spec without an expression list repeats the
+// type and expressions of the previous spec in the same group with its own
+// iota.
+type constDecl struct {
+	toks  []*token
+	iota  int
+	scope *scope
+	state declState
+	objs  []*constObj
+}
+
+// ConstDecl = "const" ( ConstSpec | "(" { ConstSpec ";" } ")" ) .
+func parseConstDecl() []*constDecl {
+	if !consume("(") {
+		return []*constDecl{declareConsts(skipSpec(), nil, 0)}
+	}
+
+	var ret []*constDecl
+	var prev *constDecl
+	for i := 0; !consume(")"); i++ {
+		prev = declareConsts(skipSpec(), prev, i)
+		ret = append(ret, prev)
+		if !peek(")") {
+			expect(";")
+		}
+	}
+	return ret
+}
+
+// ConstSpec = IdentifierList [ [ Type ] "=" ExpressionList ] .
+func declareConsts(toks []*token, prev *constDecl, iota int) *constDecl {
+	saved := tokens
+	tokens = toks
+	ids := parseIdentifierList()
+	d := &constDecl{toks: tokens, iota: iota, scope: currentScope}
+	if len(tokens) == 0 {
+		if prev == nil {
+			panic("missing init expr for const declaration")
+		}
+		d.toks = prev.toks
+	}
+	tokens = saved
+
+	for _, id := range ids {
+		c := &constObj{decl: d}
+		d.objs = append(d.objs, c)
+		currentScope.declare(id, c)
+	}
+	return d
+}
+
+func (d *constDecl) resolve() {
+	switch d.state {
+	case declParsed:
+		return
+	case declParsing:
+		panic("initialization cycle in constant declarations")
+	}
+
+	d.state = declParsing
+	savedTokens, savedScope, savedIota := tokens, currentScope, curIota
+	tokens, currentScope, curIota = d.toks, d.scope, d.iota
+
+	var ty *typ
+	if !peek("=") {
+		ty = parseType()
+	}
+	expect("=")
+	exprs := parseExpressionList()
+	if len(exprs) < len(d.objs) {
+		panic("missing init expr for const declaration")
+	}
+	if len(exprs) > len(d.objs) {
+		panic("extra init expr")
+	}
+	for i, e := range exprs {
+		c, ok := e.(*constExpr)
+		if !ok {
+			panic("const initializer is not a constant")
+		}
+		if ty != nil {
+			c = convertConst(c, ty)
+		}
+		d.objs[i].ty = c.ty
+		d.objs[i].val = c.val
+	}
+
+	tokens, currentScope, curIota = savedTokens, savedScope, savedIota
+	d.state = declParsed
+}
+
+// value returns a new expression for each use of the constant, so that it
+// can be given a type by its context.
+func (c *constObj) value() *constExpr {
+	if c.decl != nil {
+		c.decl.resolve()
+	}
+	return &constExpr{ty: c.ty, val: c.val}
+}
+
+func newIntConst(n int) *constExpr {
+	return &constExpr{ty: newLiteralType("int"), val: constant.MakeInt64(int64(n))}
+}
+
+func newUntypedInt(n int) *constExpr {
+	return &constExpr{ty: newType(typeKindUntypedInt, 0), val: constant.MakeInt64(int64(n))}
+}
+
+func newUntypedBool(b bool) *constExpr {
+	return &constExpr{ty: newType(typeKindUntypedBool, 0), val: constant.MakeBool(b)}
+}
+
+// BasicLit = int_lit | float_lit .
+// int_lit   = decimal_lit | binary_lit | octal_lit | hex_lit .
+// float_lit = decimal_float_lit | hex_float_lit .
+func parseBasicLit() expression {
+	tok := consumeToken(tokenKindLiteral)
+	if tok == nil {
+		panic(fmt.Sprintf("Expected an operand: %+v", tokens[0]))
+	}
+	if isFloatLit(tok.val) {
+		val := constant.MakeFromLiteral(tok.val, gotoken.FLOAT, 0)
+		if val.Kind() == constant.Unknown {
+			panic("invalid literal: " + tok.val)
+		}
+		return &constExpr{ty: newType(typeKindUntypedFloat, 0), val: val}
+	}
+	val := constant.MakeFromLiteral(tok.val, gotoken.INT, 0)
+	if val.Kind() == constant.Unknown {
+		panic("invalid literal: " + tok.val)
+	}
+	return &constExpr{ty: newType(typeKindUntypedInt, 0), val: val}
+}
+
+// isFloatLit reports whether the number literal lit has a fraction or an
+// exponent: e in decimal and p in hexadecimal literals.
+func isFloatLit(lit string) bool {
+	if strings.HasPrefix(lit, "0x") || strings.HasPrefix(lit, "0X") {
+		return strings.ContainsAny(lit, ".pP")
+	}
+	return strings.ContainsAny(lit, ".eE")
+}
+
+// parseConstInt parses a constant expression that must be a non-negative int.
+func parseConstInt() int {
+	c, ok := parseExpression().(*constExpr)
+	if !ok {
+		panic("expected a constant expression")
+	}
+	c = convertConst(c, newLiteralType("int"))
+	n, _ := constant.Int64Val(c.val)
+	if n < 0 {
+		panic(fmt.Sprintf("invalid negative constant %d", n))
+	}
+	return int(n)
+}
+
+var constOps = map[string]gotoken.Token{
+	"+":  gotoken.ADD,
+	"-":  gotoken.SUB,
+	"*":  gotoken.MUL,
+	"/":  gotoken.QUO,
+	"%":  gotoken.REM,
+	"&":  gotoken.AND,
+	"|":  gotoken.OR,
+	"^":  gotoken.XOR,
+	"&^": gotoken.AND_NOT,
+	"<<": gotoken.SHL,
+	">>": gotoken.SHR,
+	"&&": gotoken.LAND,
+	"||": gotoken.LOR,
+	"==": gotoken.EQL,
+	"!=": gotoken.NEQ,
+	"<":  gotoken.LSS,
+	"<=": gotoken.LEQ,
+}
+
+// foldBinary evaluates a binary operation on two constants.
+func foldBinary(op string, x, y *constExpr) *constExpr {
+	tok := constOps[op]
+
+	if op == "<<" || op == ">>" {
+		y = convertConst(y, newLiteralType("int"))
+		s, _ := constant.Int64Val(y.val)
+		if s < 0 {
+			panic(fmt.Sprintf("invalid negative shift count %d", s))
+		}
+		if isUntyped(x.ty) && constant.ToInt(x.val).Kind() == constant.Int {
+			// an untyped float with an integer value can be shifted
+			x = &constExpr{ty: newType(typeKindUntypedInt, 0), val: constant.ToInt(x.val)}
+		}
+		if x.val.Kind() != constant.Int {
+			panic(fmt.Sprintf("invalid operation: shifted operand %s must be integer", x.val))
+		}
+		return checkOverflow(&constExpr{ty: x.ty, val: constant.Shift(x.val, tok, uint(s))})
+	}
+
+	switch {
+	case isUntyped(x.ty) && !isUntyped(y.ty):
+		x = convertConst(x, y.ty)
+	case !isUntyped(x.ty) && isUntyped(y.ty):
+		y = convertConst(y, x.ty)
+	case x.ty.kind == typeKindUntypedFloat && y.ty.kind == typeKindUntypedInt:
+		y = &constExpr{ty: x.ty, val: constant.ToFloat(y.val)}
+	case x.ty.kind == typeKindUntypedInt && y.ty.kind == typeKindUntypedFloat:
+		x = &constExpr{ty: y.ty, val: constant.ToFloat(x.val)}
+	}
+	if (x.val.Kind() == constant.Bool) != (y.val.Kind() == constant.Bool) || x.ty.kind != y.ty.kind {
+		panic(fmt.Sprintf("invalid operation: %s %s %s (mismatched types %s and %s)", x.val, op, y.val, x.ty, y.ty))
+	}
+
+	switch op {
+	case "==", "!=", "<", "<=":
+		return newUntypedBool(constant.Compare(x.val, tok, y.val))
+	case "%", "&", "|", "^", "&^":
+		if isFloat(x.ty) {
+			panic(fmt.Sprintf("invalid operation: operator %s not defined on %s (%s constant)", op, x.val, x.ty))
+		}
+	}
+	switch op {
+	case "/", "%":
+		if constant.Sign(y.val) == 0 {
+			panic("invalid operation: division by zero")
+		}
+		if isInteger(x.ty) && op == "/" {
+			// integer division truncates
+			tok = gotoken.QUO_ASSIGN
+		}
+	}
+	return checkOverflow(&constExpr{ty: x.ty, val: constant.BinaryOp(x.val, tok, y.val)})
+}
+
+// checkOverflow checks that c is representable by its type and rounds the
+// value of a typed float constant to the precision of its type.
+func checkOverflow(c *constExpr) *constExpr {
+	if !representable(c.val, c.ty) {
+		panic(fmt.Sprintf("constant %s overflows %s", c.val, c.ty))
+	}
+	switch c.ty.kind {
+	case typeKindFloat32:
+		f, _ := constant.Float32Val(c.val)
+		return &constExpr{ty: c.ty, val: constant.MakeFloat64(float64(f))}
+	case typeKindFloat64:
+		f, _ := constant.Float64Val(c.val)
+		return &constExpr{ty: c.ty, val: constant.MakeFloat64(f)}
+	}
+	return c
+}
+
+// convertConst returns c as a constant of type ty. An untyped constant
+// takes the type if its value is representable by it.
+func convertConst(c *constExpr, ty *typ) *constExpr {
+	if isUntyped(ty) || !isUntyped(c.ty) {
+		return c
+	}
+	if (c.val.Kind() == constant.Bool) != (ty.kind == typeKindBool) {
+		panic(fmt.Sprintf("cannot use %s (%s constant) as %s value", c.val, c.ty, ty))
+	}
+	val := c.val
+	switch {
+	case isFloat(ty):
+		val = constant.ToFloat(val)
+	case isInteger(ty):
+		val = constant.ToInt(val)
+		if val.Kind() != constant.Int {
+			panic(fmt.Sprintf("constant %s truncated to integer", c.val))
+		}
+	}
+	return checkOverflow(&constExpr{ty: ty, val: val})
+}
+
+// convertUntyped gives e the type ty it is used as if it is an untyped
+// constant.
+func convertUntyped(e expression, ty *typ) {
+	if n, ok := e.(*nilExpr); ok && ty != nil {
+		convertNil(n, ty)
+		return
+	}
+	c, ok := e.(*constExpr)
+	if !ok || ty == nil {
+		return
+	}
+	if ty.kind == typeKindInterface {
+		ty = defaultType(c.ty)
+	}
+	conv := convertConst(c, ty)
+	c.ty, c.val = conv.ty, conv.val
+}
+
+func representable(val constant.Value, ty *typ) bool {
+	switch ty.kind {
+	case typeKindBool, typeKindUntypedBool:
+		return val.Kind() == constant.Bool
+	case typeKindUntypedInt:
+		return val.Kind() == constant.Int
+	case typeKindUntypedFloat:
+		return val.Kind() == constant.Int || val.Kind() == constant.Float
+	case typeKindFloat32:
+		f, _ := constant.Float32Val(val)
+		return val.Kind() == constant.Float && !math.IsInf(float64(f), 0)
+	case typeKindFloat64:
+		f, _ := constant.Float64Val(val)
+		return val.Kind() == constant.Float && !math.IsInf(f, 0)
+	}
+	if !isInteger(ty) || val.Kind() != constant.Int {
+		return false
+	}
+	// an n-bit integer holds [0, 1<<n) if unsigned and
+	// [-1<<(n-1), 1<<(n-1)) if signed
+	bits := uint(8 * ty.size)
+	min := constant.MakeInt64(0)
+	if !isUnsigned(ty) {
+		bits--
+		min = constant.Shift(constant.MakeInt64(-1), gotoken.SHL, bits)
+	}
+	max := constant.Shift(constant.MakeInt64(1), gotoken.SHL, bits)
+	return constant.Compare(val, gotoken.GEQ, min) && constant.Compare(val, gotoken.LSS, max)
+}
+
+// constBits returns the 64-bit pattern of an integer, float or boolean
+// constant. An untyped constant has its default type.
+func constBits(c *constExpr) int64 {
+	if c.val.Kind() == constant.Bool {
+		if constant.BoolVal(c.val) {
+			return 1
+		}
+		return 0
+	}
+	c = convertConst(c, defaultType(c.ty))
+	switch c.ty.kind {
+	case typeKindFloat32:
+		f, _ := constant.Float32Val(c.val)
+		return int64(math.Float32bits(f))
+	case typeKindFloat64:
+		f, _ := constant.Float64Val(c.val)
+		return int64(math.Float64bits(f))
+	}
+	if n, ok := constant.Uint64Val(c.val); ok {
+		return int64(n)
+	}
+	n, _ := constant.Int64Val(c.val)
+	return n
+}
+
+// foldComplement evaluates ^x, which flips the bits of the type of x: all
+// of them for an unsigned type, and as if in two's complement otherwise.
+func foldComplement(x *constExpr) *constExpr {
+	if x.val.Kind() != constant.Int {
+		panic(fmt.Sprintf("invalid operation: operator ^ not defined on %s", x.val))
+	}
+	prec := uint(0)
+	if isUnsigned(x.ty) {
+		prec = uint(8 * x.ty.size)
+	}
+	return &constExpr{ty: x.ty, val: constant.UnaryOp(gotoken.XOR, x.val, prec)}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"go/constant"
+)
+
+// conversion is the conversion T(x) of x to the type T.
+type conversion struct {
+	expression
+	ty    *typ
+	child expression
+}
+
+func (e *conversion) getType() *typ   { return e.ty }
+func (e *conversion) setType(ty *typ) { e.ty = ty }
+
+// Conversion = Type "(" Expression [ "," ] ")" .
+func parseConversion(ty *typ) expression {
+	x := parseExpression()
+	consume(",")
+	expect(")")
+	addType(x)
+	return convert(x, ty)
+}
+
+// convert returns x converted to the type ty. A constant stays a constant
+// if ty is a numeric or boolean type.
+func convert(x expression, ty *typ) expression {
+	from := x.getType()
+	if c, ok := x.(*constExpr); ok && (isNumeric(ty) || ty.kind == typeKindBool) {
+		return foldConversion(c, ty)
+	}
+	if n, ok := x.(*nilExpr); ok {
+		convertNil(n, ty)
+		return n
+	}
+	if ty.kind == typeKindInterface {
+		if m := missingMethod(from, ty); m != "" {
+			panic(fmt.Sprintf("cannot convert %s value to type %s: %s does not implement %s (missing method %s)", from, ty, from, ty, m))
+		}
+		return checkAssignable(x, ty, "conversion")
+	}
+	switch {
+	case identical(from.under(), ty.under()):
+	case from.kind == typeKindPtr && ty.kind == typeKindPtr && from.name == "" && ty.name == "" &&
+		identical(from.base.under(), ty.base.under()):
+	case isNumeric(from) && isNumeric(ty):
+	default:
+		panic(fmt.Sprintf("cannot convert %s value to type %s", from, ty))
+	}
+	return &conversion{ty: ty, child: x}
+}
+
+// foldConversion converts the constant c to the type ty. The value must be
+// representable by ty; a float is rounded to the precision of a float type
+// but not truncated to an integer.
+func foldConversion(c *constExpr, ty *typ) *constExpr {
+	if (c.val.Kind() == constant.Bool) != (ty.kind == typeKindBool) {
+		panic(fmt.Sprintf("cannot convert %s (%s constant) to type %s", c.val, c.ty, ty))
+	}
+	val := c.val
+	switch {
+	case isFloat(ty):
+		val = constant.ToFloat(val)
+	case isInteger(ty):
+		val = constant.ToInt(val)
+		if val.Kind() != constant.Int {
+			panic(fmt.Sprintf("constant %s truncated to integer", c.val))
+		}
+	}
+	return checkOverflow(&constExpr{ty: ty, val: val})
+}
+
+// genConversion converts the value of the operand of e. Integers are
+// truncated or extended to the new size, and floats converted to integers
+// are truncated toward zero. Other conversions keep the representation.
+func genConversion(e *conversion) {
+	from, to := e.child.getType(), e.ty
+	genExpr(e.child)
+	if !isNumeric(from) || !isNumeric(to) {
+		return
+	}
+	fmt.Printf("\tpop rax\n")
+	popped(8)
+	switch {
+	case isFloat(from) && isFloat(to):
+		if from.size != to.size {
+			genToXmm("xmm0", "rax", from)
+			fmt.Printf("\tcvt%s2%s xmm0, xmm0\n", floatSuffix(from), floatSuffix(to))
+			genFromXmm("rax", "xmm0", to)
+		}
+	case isFloat(from):
+		genFloatToInt(from, to)
+	case isFloat(to):
+		genIntToFloat(from, to)
+	default:
+		genWrap(to)
+	}
+	fmt.Printf("\tpush rax\n")
+	pushedWords(1, false)
+}
+
+// genFloatToInt converts the float of type from in rax to the integer type
+// to. cvttsd2si only produces signed integers, so a value of 2^63 or more
+// converted to a 64-bit unsigned type is reduced by 2^63 first.
+func genFloatToInt(from, to *typ) {
+	sfx := floatSuffix(from)
+	genToXmm("xmm0", "rax", from)
+	if !isUnsigned(to) || to.size != 8 {
+		fmt.Printf("\tcvtt%s2si rax, xmm0\n", sfx)
+		genWrap(to)
+		return
+	}
+	labelCnt++
+	cnt := labelCnt
+	if from.size == 4 {
+		fmt.Printf("\tmov eax, 0x5f000000\n")
+	} else {
+		fmt.Printf("\tmov rax, 0x43e0000000000000\n")
+	}
+	genToXmm("xmm1", "rax", from)
+	fmt.Printf("\tucomi%s xmm0, xmm1\n", sfx)
+	fmt.Printf("\tjae .Lcvt%d\n", cnt)
+	fmt.Printf("\tcvtt%s2si rax, xmm0\n", sfx)
+	fmt.Printf("\tjmp .Lcvt.end%d\n", cnt)
+	fmt.Printf(".Lcvt%d:\n", cnt)
+	fmt.Printf("\tsub%s xmm0, xmm1\n", sfx)
+	fmt.Printf("\tcvtt%s2si rax, xmm0\n", sfx)
+	fmt.Printf("\tbtc rax, 63\n")
+	fmt.Printf(".Lcvt.end%d:\n", cnt)
+}
+
+// genIntToFloat converts the integer of type from in rax to the float type
+// to. cvtsi2sd only takes signed integers, so a 64-bit unsigned value with
+// the top bit set is halved, keeping the low bit for rounding, converted
+// and doubled.
+func genIntToFloat(from, to *typ) {
+	sfx := floatSuffix(to)
+	if !isUnsigned(from) || from.size != 8 {
+		fmt.Printf("\tcvtsi2%s xmm0, rax\n", sfx)
+		genFromXmm("rax", "xmm0", to)
+		return
+	}
+	labelCnt++
+	cnt := labelCnt
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjs .Lcvt%d\n", cnt)
+	fmt.Printf("\tcvtsi2%s xmm0, rax\n", sfx)
+	fmt.Printf("\tjmp .Lcvt.end%d\n", cnt)
+	fmt.Printf(".Lcvt%d:\n", cnt)
+	fmt.Printf("\tmov rcx, rax\n")
+	fmt.Printf("\tshr rcx, 1\n")
+	fmt.Printf("\tand eax, 1\n")
+	fmt.Printf("\tor rcx, rax\n")
+	fmt.Printf("\tcvtsi2%s xmm0, rcx\n", sfx)
+	fmt.Printf("\tadd%s xmm0, xmm0\n", sfx)
+	fmt.Printf(".Lcvt.end%d:\n", cnt)
+	genFromXmm("rax", "xmm0", to)
+}
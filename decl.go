@@ -0,0 +1,437 @@
+package main
+
+import (
+	"fmt"
+)
+
+type declState int
+
+const (
+	declUnparsed declState = iota
+	declParsing
+	declParsed
+)
+
+// varDecl is a package-level VarSpec. Its tokens are kept aside until all
+// the package-level names are known, and it is parsed the first time it is
+// needed.
+type varDecl struct {
+	toks  []*token
+	state declState
+	vars  []*obj
+
+	// init assigns the initial values at run time; it is nil when the
+	// variables are zero or initialized by constants.
+	init statement
+}
+
+var varDecls []*varDecl
+
+// VarDecl = "var" ( VarSpec | "(" { VarSpec ";" } ")" ) .
+func parseGlobalVarDecl() []*obj {
+	if !consume("(") {
+		return declareGlobalVars(skipSpec())
+	}
+
+	var ret []*obj
+	for !consume(")") {
+		ret = append(ret, declareGlobalVars(skipSpec())...)
+		if !peek(")") {
+			expect(";")
+		}
+	}
+	return ret
+}
+
+func declareGlobalVars(toks []*token) []*obj {
+	d := &varDecl{toks: toks}
+	varDecls = append(varDecls, d)
+
+	saved := tokens
+	tokens = toks
+	for _, id := range parseIdentifierList() {
+		gv := &obj{name: id, global: true, decl: d}
+		pkgScope.declare(id, gv)
+		d.vars = append(d.vars, gv)
+	}
+	tokens = saved
+
+	return d.vars
+}
+
+func (d *varDecl) resolve() {
+	switch d.state {
+	case declParsed:
+		return
+	case declParsing:
+		panic(fmt.Sprintf("initialization cycle: %s refers to itself", d.vars[0].name))
+	}
+
+	d.state = declParsing
+	savedTokens, savedScope := tokens, currentScope
+	tokens, currentScope = d.toks, pkgScope
+
+	parseGlobalVarSpec(d)
+
+	tokens, currentScope = savedTokens, savedScope
+	d.state = declParsed
+}
+
+// VarSpec = IdentifierList ( Type [ "=" ExpressionList ] | "=" ExpressionList ) .
+func parseGlobalVarSpec(d *varDecl) {
+	parseIdentifierList()
+	if !peek("=") {
+		ty := parseType()
+		for _, gv := range d.vars {
+			gv.ty = ty
+		}
+	}
+
+	if !consume("=") {
+		// zero value
+		return
+	}
+
+	lhs := make([]expression, len(d.vars))
+	for i, gv := range d.vars {
+		lhs[i] = gv
+	}
+	rhs := parseExpressionList()
+	d.init = newDeclAssignment(lhs, rhs)
+
+	if rhs.convertSingleMultiValuedExpression() != nil {
+		return
+	}
+	data := make([][]byte, len(d.vars))
+	for i, gv := range d.vars {
+		b, ok := constData(rhs[i], gv.ty)
+		if !ok {
+			return
+		}
+		data[i] = b
+	}
+	for i, gv := range d.vars {
+		gv.initData = data[i]
+	}
+	d.init = nil
+}
+
+// constData returns the memory image of expr as a value of type ty if it
+// can be computed at compile time.
+func constData(expr expression, ty *typ) ([]byte, bool) {
+	if c, ok := expr.(*compositeLit); ok {
+		if ty.kind != typeKindArray && ty.kind != typeKindStruct {
+			return nil, false
+		}
+		ret := make([]byte, ty.size)
+		for i, elem := range c.elems {
+			b, ok := constData(elem, elem.getType())
+			if !ok {
+				return nil, false
+			}
+			copy(ret[c.offsets[i]:], b)
+		}
+		return ret, true
+	}
+
+	c, ok := expr.(*constExpr)
+	if !ok {
+		return nil, false
+	}
+	convertUntyped(c, ty)
+	val := constBits(c)
+	ret := make([]byte, ty.size)
+	for i := range ret {
+		ret[i] = byte(val >> (8 * i))
+	}
+	return ret, true
+}
+
+// typeName is a type declared by a TypeSpec. The type of a type definition
+// exists as soon as it is declared so that it can be referred to through
+// pointers in its own underlying type.
+type typeName struct {
+	ty   *typ
+	decl *typeDecl
+}
+
+type typeDecl struct {
+	toks  []*token
+	scope *scope
+	state declState
+	tn    *typeName
+}
+
+// TypeDecl = "type" ( TypeSpec | "(" { TypeSpec ";" } ")" ) .
+func parseTypeDecl() []*typeDecl {
+	if !consume("(") {
+		return []*typeDecl{declareType(skipSpec())}
+	}
+
+	var ret []*typeDecl
+	for !consume(")") {
+		ret = append(ret, declareType(skipSpec()))
+		if !peek(")") {
+			expect(";")
+		}
+	}
+	return ret
+}
+
+// TypeSpec  = AliasDecl | TypeDef .
+// AliasDecl = identifier "=" Type .
+// TypeDef   = identifier Type .
+func declareType(toks []*token) *typeDecl {
+	if len(toks) == 0 || toks[0].kind != tokenKindIdentifier {
+		panic("must be an identifier")
+	}
+	name := toks[0].val
+	d := &typeDecl{toks: toks[1:], scope: currentScope}
+	d.tn = &typeName{decl: d}
+	if len(d.toks) == 0 || d.toks[0].val != "=" {
+		d.tn.ty = &typ{name: name, size: -1}
+	}
+	currentScope.declare(name, d.tn)
+	return d
+}
+
+func (d *typeDecl) resolve() {
+	switch d.state {
+	case declParsed:
+		return
+	case declParsing:
+		if d.tn.ty == nil {
+			panic("invalid recursive type alias")
+		}
+		return
+	}
+
+	d.state = declParsing
+	savedTokens, savedScope := tokens, currentScope
+	tokens, currentScope = d.toks, d.scope
+
+	if consume("=") {
+		d.tn.ty = parseType()
+	} else {
+		d.tn.ty.setUnderlying(parseType())
+	}
+	if len(tokens) > 0 {
+		panic(fmt.Sprintf("Unexpected token: %+v", tokens[0]))
+	}
+
+	tokens, currentScope = savedTokens, savedScope
+	d.state = declParsed
+}
+
+// sortInitializers orders the run-time initializers of package-level
+// variables: the earliest declaration that does not depend on an
+// uninitialized variable is initialized next.
+func sortInitializers(decls []*varDecl) []statement {
+	deps := map[*varDecl][]*varDecl{}
+	n := 0
+	for _, d := range decls {
+		if d.init == nil {
+			continue
+		}
+		n++
+		refs := map[*obj]bool{}
+		for _, e := range d.init.(*assignment).rhs {
+			collectGlobalRefs(e, refs, map[*function]bool{})
+		}
+		for _, dd := range decls {
+			for _, gv := range dd.vars {
+				if refs[gv] && dd.init != nil {
+					deps[d] = append(deps[d], dd)
+					break
+				}
+			}
+		}
+	}
+
+	done := map[*varDecl]bool{}
+	var ret []statement
+	for len(ret) < n {
+		var next *varDecl
+		for _, d := range decls {
+			if d.init == nil || done[d] {
+				continue
+			}
+			ready := true
+			for _, dep := range deps[d] {
+				if !done[dep] {
+					ready = false
+				}
+			}
+			if ready {
+				next = d
+				break
+			}
+		}
+		if next == nil {
+			panic("initialization cycle")
+		}
+		done[next] = true
+		ret = append(ret, next.init)
+	}
+	return ret
+}
+
+// collectGlobalRefs records the global variables n refers to, directly or
+// through the functions it calls.
+func collectGlobalRefs(n interface{}, refs map[*obj]bool, seen map[*function]bool) {
+	inspect(n, func(n interface{}) {
+		switch n := n.(type) {
+		case *obj:
+			if n.global {
+				refs[n] = true
+			}
+		case *funcCall:
+			if !seen[n.target] {
+				seen[n.target] = true
+				collectGlobalRefs(n.target.body, refs, seen)
+			}
+		case *funcVal:
+			if !seen[n.fn] {
+				seen[n.fn] = true
+				collectGlobalRefs(n.fn.body, refs, seen)
+			}
+		case *methodVal:
+			if fn := n.method.fn; !seen[fn] {
+				seen[fn] = true
+				collectGlobalRefs(fn.body, refs, seen)
+			}
+		case *funcLit:
+			if !seen[n.fn] {
+				seen[n.fn] = true
+				collectGlobalRefs(n.fn.body, refs, seen)
+			}
+		}
+	})
+}
+
+// inspect calls fn for n and for every statement and expression below it.
+func inspect(n interface{}, fn func(interface{})) {
+	if n == nil {
+		return
+	}
+	fn(n)
+
+	switch n := n.(type) {
+	case *returnStmt:
+		inspect(n.child, fn)
+	case *blockStmt:
+		for _, s := range n.stmts {
+			inspect(s, fn)
+		}
+	case *ifStmt:
+		inspect(n.init, fn)
+		inspect(n.cond, fn)
+		inspect(n.then, fn)
+		inspect(n.els, fn)
+	case *forStmt:
+		inspect(n.init, fn)
+		inspect(n.cond, fn)
+		inspect(n.post, fn)
+		inspect(n.body, fn)
+	case *mapIterStmt:
+		inspect(n.m, fn)
+		inspect(n.it, fn)
+	case *mapIterOk:
+		inspect(n.it, fn)
+	case *expressionStmt:
+		inspect(n.child, fn)
+	case *deferStmt:
+		inspect(n.setup, fn)
+		inspect(n.call, fn)
+	case *assignment:
+		for _, e := range n.lhs {
+			inspect(e, fn)
+		}
+		for _, e := range n.rhs {
+			inspect(e, fn)
+		}
+	case *compositeLit:
+		for _, e := range n.elems {
+			inspect(e, fn)
+		}
+	case *memberRef:
+		inspect(n.child, fn)
+	case *binary:
+		inspect(n.lhs, fn)
+		inspect(n.rhs, fn)
+	case *unary:
+		inspect(n.child, fn)
+	case *conversion:
+		inspect(n.child, fn)
+	case *deref:
+		inspect(n.child, fn)
+	case *addr:
+		inspect(n.child, fn)
+	case *funcCall:
+		for _, e := range n.args {
+			inspect(e, fn)
+		}
+	case *methodVal:
+		inspect(n.recv, fn)
+	case *indirectCall:
+		inspect(n.fn, fn)
+		for _, e := range n.args {
+			inspect(e, fn)
+		}
+	case *toIface:
+		inspect(n.child, fn)
+	case *typeAssert:
+		inspect(n.child, fn)
+	case *typeCheck:
+		inspect(n.child, fn)
+	case *indexExpr:
+		inspect(n.child, fn)
+		inspect(n.idx, fn)
+	case *sliceExpr:
+		inspect(n.child, fn)
+		inspect(n.lo, fn)
+		inspect(n.hi, fn)
+		inspect(n.max, fn)
+	case *builtinCall:
+		for _, e := range n.args {
+			inspect(e, fn)
+		}
+	case *mapLit:
+		for i := range n.keys {
+			inspect(n.keys[i], fn)
+			inspect(n.vals[i], fn)
+		}
+	case *ifaceMethod:
+		inspect(n.recv, fn)
+	case *ifaceCall:
+		inspect(n.recv, fn)
+		for _, e := range n.args {
+			inspect(e, fn)
+		}
+	}
+}
+
+// skipSpec consumes tokens up to the ";" or ")" ending a spec in a
+// declaration and returns them.
+func skipSpec() []*token {
+	depth := 0
+	for i, tok := range tokens {
+		if tok.kind != tokenKindOperator {
+			continue
+		}
+		switch tok.val {
+		case "(", "{", "[":
+			depth++
+		case ")", "}", "]":
+			depth--
+		}
+		if depth < 0 || (depth == 0 && tok.val == ";") {
+			ret := tokens[:i]
+			tokens = tokens[i:]
+			return ret
+		}
+	}
+	ret := tokens
+	tokens = nil
+	return ret
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The function value and arguments of a deferred call are evaluated at the
+// defer statement and the call is made from them when the function returns
+// or the program panics. If the defer statements of a function are outside
+// loops and at most maxOpenDefers, they are open-coded as in the Go
+// toolchain: the values are kept in temporaries of the frame, a word of the
+// frame has a bit set for each defer statement that has run, and the calls
+// are made inline in reverse order. Otherwise each defer statement pushes a
+// record on a list in the frame. A record is a closure whose function makes
+// the call from the values it holds, and whose second word links the next
+// record.
+//
+// The calls are made by main.f..deferreturn, which runs on the frame of f.
+// The epilogue calls it, and so does runtime.gopanic for every frame on the
+// stack, finding it in the frame map.
+
+const maxOpenDefers = 8
+
+type deferStmt struct {
+	statement
+	ty   *typ
+	call expression
+
+	// setup evaluates the operands of call at the defer statement
+	setup statement
+
+	// an open-coded defer sets bit in bits
+	bits *obj
+	bit  int
+
+	// otherwise rec holds the new record of wrap, pushed on list
+	list *obj
+	rec  *obj
+	wrap *function
+}
+
+func (s *deferStmt) getType() *typ   { return s.ty }
+func (s *deferStmt) setType(ty *typ) { s.ty = ty }
+
+// DeferStmt = "defer" Expression .
+func parseDeferStmt() statement {
+	call := parseExpression()
+	addType(call)
+	switch c := call.(type) {
+	case *funcCall, *indirectCall, *ifaceCall:
+	case *builtinCall:
+		switch c.name {
+		case "copy", "delete", "panic", "recover":
+		default:
+			panic(fmt.Sprintf("defer discards result of %s", c.name))
+		}
+	default:
+		panic("expression in defer must be function call")
+	}
+	return &deferStmt{call: call}
+}
+
+// lowerDefers decides how the deferred calls of f are made and returns the
+// functions of their records.
+func lowerDefers(f *function) []*function {
+	open := true
+	var walk func(n statement, loop bool)
+	walk = func(n statement, loop bool) {
+		switch n := n.(type) {
+		case *blockStmt:
+			for _, s := range n.stmts {
+				walk(s, loop)
+			}
+		case *ifStmt:
+			walk(n.then, loop)
+			walk(n.els, loop)
+		case *forStmt:
+			walk(n.body, true)
+		case *deferStmt:
+			f.defers = append(f.defers, n)
+			open = open && !loop
+		}
+	}
+	walk(f.body, false)
+	if f.defers == nil {
+		return nil
+	}
+
+	if open && len(f.defers) <= maxOpenDefers {
+		f.deferBits = f.newTemp(newLiteralType("int"))
+		for i, d := range f.defers {
+			d.bits, d.bit = f.deferBits, i
+			_, types, _ := splitCall(d.call)
+			temps := make([]expression, len(types))
+			for j, ty := range types {
+				temps[j] = f.newTemp(ty)
+			}
+			d.lower(temps, temps)
+		}
+		return nil
+	}
+
+	f.deferList = f.newTemp(funcType(nil, nil))
+	var wraps []*function
+	for i, d := range f.defers {
+		members := []*member{
+			{name: "F", ty: newLiteralType("int")},
+			{name: "link", ty: funcType(nil, nil)},
+		}
+		_, types, _ := splitCall(d.call)
+		for j, ty := range types {
+			members = append(members, &member{name: fmt.Sprintf("a%d", j), ty: ty})
+		}
+		recTy := newStructType(members)
+
+		d.list = f.deferList
+		d.rec = f.newTemp(pointerTo(recTy))
+		d.wrap = &function{name: fmt.Sprintf("%s.deferwrap%d", f.name, i+1), deferWrapper: true}
+		d.wrap.ctx = &obj{name: ".ctx", ty: pointerTo(recTy)}
+		d.wrap.locals = []*obj{d.wrap.ctx}
+
+		// the operands are the fields of the record, in f and in wrap
+		fields := func(rec *obj) []expression {
+			var ret []expression
+			for _, m := range members[2:] {
+				ret = append(ret, &memberRef{child: &deref{child: rec}, name: m.name, member: m})
+			}
+			return ret
+		}
+		d.lower(fields(d.rec), fields(d.wrap.ctx))
+		d.wrap.body = &expressionStmt{child: d.call}
+		if b, ok := d.call.(*builtinCall); ok && b.name == "recover" {
+			// recover is not called by a deferred function, but by the
+			// function of the record, and recovers nothing
+			d.wrap.body = &blockStmt{}
+		}
+		addType(d.wrap.body)
+		wraps = append(wraps, d.wrap)
+	}
+	return wraps
+}
+
+// splitCall returns the operands of a call evaluated before it is made: the
+// function value or interface, if any, and the arguments, with the types
+// of the places they are kept in. remake makes the call from such places.
+func splitCall(call expression) (ops expressionList, types []*typ, remake func([]expression) expression) {
+	switch c := call.(type) {
+	case *funcCall:
+		for _, p := range c.target.params {
+			types = append(types, p.ty)
+		}
+		return c.args, types, func(ops []expression) expression {
+			return &funcCall{name: c.name, target: c.target, args: ops}
+		}
+	case *indirectCall:
+		ty := c.fn.getType()
+		ops = append(expressionList{c.fn}, c.args...)
+		return ops, append([]*typ{ty}, ty.params...), func(ops []expression) expression {
+			return &indirectCall{fn: ops[0], args: ops[1:]}
+		}
+	case *ifaceCall:
+		ops = append(expressionList{c.recv}, c.args...)
+		return ops, append([]*typ{c.recv.getType()}, c.method.ty.params...), func(ops []expression) expression {
+			return &ifaceCall{recv: ops[0], index: c.index, method: c.method, args: ops[1:]}
+		}
+	case *builtinCall:
+		for _, arg := range c.args {
+			types = append(types, arg.getType())
+		}
+		return c.args, types, func(ops []expression) expression {
+			return &builtinCall{name: c.name, args: ops}
+		}
+	}
+	panic("internal error: not a call")
+}
+
+// lower makes the setup of d assign the operands of its call to the places
+// in setupOps, and its call take them from the places in callOps.
+func (d *deferStmt) lower(setupOps, callOps []expression) {
+	ops, types, remake := splitCall(d.call)
+	var stmts []statement
+	if len(types) > len(ops) {
+		// the last operand is a call giving the rest of the arguments
+		last := len(ops) - 1
+		stmts = append(stmts, &assignment{lhs: setupOps[last:], rhs: ops[last:]})
+		setupOps, ops = setupOps[:last], ops[:last]
+	}
+	if len(ops) > 0 {
+		stmts = append([]statement{&assignment{lhs: setupOps, rhs: ops}}, stmts...)
+	}
+	d.setup = &blockStmt{stmts: stmts}
+	addType(d.setup)
+	d.call = remake(callOps)
+	addType(d.call)
+}
+
+// newTemp adds a temporary of type ty to the locals of f.
+func (f *function) newTemp(ty *typ) *obj {
+	v := &obj{name: newUniqueName(), ty: ty}
+	f.locals = append(f.locals, v)
+	return v
+}
+
+// genDefer runs the defer statement s.
+func genDefer(s *deferStmt) {
+	if s.bits != nil {
+		genStmt(s.setup)
+		fmt.Printf("\tor qword ptr [rbp%+d], %d\n", s.bits.offset, 1<<s.bit)
+		return
+	}
+	ty := s.rec.ty.base
+	genAlloc(ty, ty.size)
+	fmt.Printf("\tpop rax\n")
+	popped(8)
+	fmt.Printf("\tmov [rbp%+d], rax\n", s.rec.offset)
+	fmt.Printf("\tlea rcx, [rip+main.%s]\n", s.wrap.name)
+	fmt.Printf("\tmov [rax], rcx\n")
+	genStmt(s.setup)
+	fmt.Printf("\tmov rax, [rbp%+d]\n", s.rec.offset)
+	fmt.Printf("\tmov rcx, [rbp%+d]\n", s.list.offset)
+	fmt.Printf("\tmov [rax+8], rcx\n")
+	fmt.Printf("\tmov [rbp%+d], rax\n", s.list.offset)
+}
+
+// genDeferReturn emits main.f..deferreturn, which makes the deferred calls
+// of f that are pending, the last deferred first. Each is taken off before
+// it is made, so that a call panicking is not made again.
+func genDeferReturn(f *function) {
+	fmt.Printf("%s..deferreturn:\n", funcName)
+	// its return address is below the frame of f
+	temps = []bool{false}
+	if f.deferBits != nil {
+		off := f.deferBits.offset
+		for i := len(f.defers) - 1; i >= 0; i-- {
+			labelCnt++
+			cnt := labelCnt
+			fmt.Printf("\ttest qword ptr [rbp%+d], %d\n", off, 1<<i)
+			fmt.Printf("\tjz .Ldefer.next.%d\n", cnt)
+			fmt.Printf("\tand qword ptr [rbp%+d], %d\n", off, ^(1 << i))
+			genStmt(&expressionStmt{child: f.defers[i].call})
+			fmt.Printf(".Ldefer.next.%d:\n", cnt)
+		}
+		fmt.Printf("\tret\n")
+		return
+	}
+	off := f.deferList.offset
+	fmt.Printf(".Ldeferreturn.%s:\n", funcName)
+	fmt.Printf("\tmov rdx, [rbp%+d]\n", off)
+	fmt.Printf("\ttest rdx, rdx\n")
+	fmt.Printf("\tjz .Ldeferreturn.done.%s\n", funcName)
+	fmt.Printf("\tmov rax, [rdx+8]\n")
+	fmt.Printf("\tmov [rbp%+d], rax\n", off)
+	genCall("[rdx]")
+	fmt.Printf("\tjmp .Ldeferreturn.%s\n", funcName)
+	fmt.Printf(".Ldeferreturn.done.%s:\n", funcName)
+	fmt.Printf("\tret\n")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+)
+
+// selection is the field or method a selector x.f denotes, and the
+// embedded fields through which it is promoted, outermost first.
+type selection struct {
+	path   []*member
+	field  *member
+	method *method
+
+	// the method of an embedded interface field, at index in its table
+	imethod *imethod
+	index   int
+}
+
+// isEmbeddedField reports whether the field declaration that follows is an
+// embedded field.
+func isEmbeddedField() bool {
+	i := 0
+	if tokens[0].val == "*" {
+		i++
+	}
+	kind := tokens[i].kind
+	next := tokens[i+1].val
+	return (kind == tokenKindIdentifier || kind == tokenKindType) && (next == ";" || next == "}")
+}
+
+// lookupSelection finds the field or method name of a value of type ty,
+// which may be a pointer to a struct. The embedded fields are searched
+// breadth first, and the shallowest depth at which name is found must
+// have exactly one field or method of that name.
+func lookupSelection(ty *typ, name string) *selection {
+	type entry struct {
+		ty   *typ
+		path []*member
+	}
+
+	// the methods of a defined pointer type's base are not promoted
+	methods := true
+	if ty.kind == typeKindPtr {
+		methods = ty.name == ""
+		ty = ty.base
+	}
+
+	seen := map[*typ]bool{}
+	level := []entry{{ty: ty}}
+	for len(level) > 0 {
+		var found []*selection
+		var next []entry
+		for _, e := range level {
+			t := e.ty
+			if seen[t] {
+				continue
+			}
+			if m := findMethod(t, name); m != nil && methods {
+				found = append(found, &selection{path: e.path, method: m})
+			}
+			if t.kind == typeKindInterface && len(e.path) > 0 {
+				if i, m := findIMethod(t, name); m != nil && methods {
+					found = append(found, &selection{path: e.path, imethod: m, index: i})
+				}
+			}
+			if t.kind != typeKindStruct {
+				continue
+			}
+			for _, f := range t.members {
+				path := append(e.path[:len(e.path):len(e.path)], f)
+				if f.name == name {
+					found = append(found, &selection{path: e.path, field: f})
+				}
+				if f.embedded {
+					ft := f.ty
+					if ft.kind == typeKindPtr {
+						ft = ft.base
+					}
+					next = append(next, entry{ty: ft, path: path})
+				}
+			}
+		}
+		switch len(found) {
+		case 0:
+		case 1:
+			return found[0]
+		default:
+			panic(fmt.Sprintf("ambiguous selector %s.%s", ty, name))
+		}
+
+		// a type seen at a shallower depth adds nothing deeper
+		for _, e := range level {
+			seen[e.ty] = true
+		}
+		level = next
+		methods = true
+	}
+	return nil
+}
+
+// selectPath selects the embedded fields path from x in turn,
+// dereferencing pointers on the way.
+func selectPath(x expression, path []*member) expression {
+	for _, f := range path {
+		addType(x)
+		if x.getType().kind == typeKindPtr {
+			x = &deref{child: x}
+		}
+		x = &memberRef{child: x, name: f.name, member: f, implicit: true}
+		addType(x)
+	}
+	return x
+}
+
+// methodOf finds the method name in the method set of the type ty, either
+// declared or promoted from an embedded field. A promoted method gets a
+// function that calls the method of the embedded field; it needs a
+// pointer receiver if that method does and no embedded pointer leads to
+// it.
+func methodOf(ty *typ, name string) *method {
+	if m := findMethod(ty, name); m != nil {
+		return m
+	}
+	if ty.kind != typeKindStruct {
+		return nil
+	}
+	sel := lookupSelection(ty, name)
+	if sel == nil || (sel.method == nil && sel.imethod == nil) {
+		return nil
+	}
+
+	// local types may share their names
+	fnName := ty.name
+	if tn, _ := pkgScope.syms[ty.name].(*typeName); tn == nil || tn.ty != ty {
+		fnName += newUniqueName()
+	}
+	if ty.name == "" {
+		fnName = "struct" + fnName
+	}
+	if sel.imethod != nil {
+		// the interface value is called whatever the receiver
+		m := &method{name: name}
+		p := &obj{name: "p", ty: ty}
+		ty.methods = append(ty.methods, m)
+		m.fn = newIfaceWrapper(fnName+"."+name, selectPath(p, sel.path), sel.index, sel.imethod, []*obj{p})
+		return m
+	}
+
+	m := &method{name: name, ptrRecv: sel.method.ptrRecv}
+	for _, f := range sel.path {
+		if f.ty.kind == typeKindPtr {
+			m.ptrRecv = false
+		}
+	}
+	p := &obj{name: "p", ty: ty}
+	var recv expression = p
+	if m.ptrRecv {
+		p.ty = pointerTo(ty)
+		recv = &deref{child: p}
+	}
+	recv = receiverArg(selectPath(recv, sel.path), sel.method)
+
+	ty.methods = append(ty.methods, m)
+	m.fn = newWrapper(fnName+"."+name, sel.method.fn, []*obj{p}, nil, recv)
+	return m
+}
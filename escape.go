@@ -0,0 +1,622 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Escape analysis decides which local variables may stay in the frame of
+// their function. Every variable, the results and the heap are locations,
+// and a value flowing from one location into another is an edge counting
+// the dereferences on the way: -1 for the address of the source, 0 for its
+// value and 1 or more for what it points to. A variable escapes if its
+// address reaches a location that outlives it: the heap, a result, or a
+// variable declared outside the loop it is declared in. Escaping variables
+// are moved to the heap where they are declared, so a variable declared in
+// a loop gets new memory each iteration.
+//
+// Calls are summarized by how far each parameter leaks into the heap and
+// into each result, computed over all the functions until nothing changes.
+// Calls through function values or interfaces leak their arguments.
+
+// heapVarStmt allocates new memory for the variable v.
+type heapVarStmt struct {
+	statement
+	ty *typ
+	v  *obj
+}
+
+func (s *heapVarStmt) getType() *typ   { return s.ty }
+func (s *heapVarStmt) setType(ty *typ) { s.ty = ty }
+
+// printEscapes is set by -m to explain the decisions on stderr.
+var printEscapes bool
+
+type location struct {
+	v         *obj // nil for the heap or a temporary
+	edges     []edge
+	loopDepth int
+	param     int // index in the parameters, or -1
+	result    int // index in the results, or -1
+	addrTaken bool
+	escapes   bool
+
+	walkgen int
+	derefs  int
+}
+
+type edge struct {
+	src    *location
+	derefs int
+}
+
+// hole is where the value of an expression flows: dereferenced derefs
+// times into loc. A nil loc discards the value.
+type hole struct {
+	loc    *location
+	derefs int
+}
+
+func (k hole) deref() hole {
+	return hole{k.loc, k.derefs + 1}
+}
+
+// leak is how the value of a parameter flows out of its function, as the
+// number of dereferences before it reaches the heap and each result, or -1
+// where it does not.
+type leak struct {
+	heap    int
+	results []int
+}
+
+type escState struct {
+	fn        *function
+	heap      *location
+	locs      map[*obj]*location
+	all       []*location
+	loopDepth int
+	walkgen   int
+
+	// changed is set when a summary of fn leaks further
+	changed bool
+}
+
+func escapeAnalysis(funcs []*function) {
+	for _, f := range funcs {
+		f.leaks = make([]leak, len(f.params))
+		for i := range f.leaks {
+			f.leaks[i] = leak{heap: -1, results: make([]int, len(f.results))}
+			for j := range f.leaks[i].results {
+				f.leaks[i].results[j] = -1
+			}
+		}
+	}
+
+	states := make([]*escState, len(funcs))
+	for changed := true; changed; {
+		changed = false
+		for i, f := range funcs {
+			states[i] = analyzeEscapes(f)
+			changed = changed || states[i].changed
+		}
+	}
+	for _, e := range states {
+		e.apply()
+	}
+}
+
+func analyzeEscapes(f *function) *escState {
+	e := &escState{fn: f, locs: map[*obj]*location{}}
+	e.heap = e.newLoc(nil)
+	for i, p := range f.params {
+		e.loc(p).param = i
+	}
+	for i, r := range f.results {
+		if hasPointers(r.ty) {
+			e.loc(r).result = i
+		}
+	}
+	e.stmt(f.body)
+
+	for {
+		n := e.numEscapes()
+		for _, root := range e.all {
+			e.walk(root)
+		}
+		if e.numEscapes() == n {
+			return e
+		}
+	}
+}
+
+func (e *escState) newLoc(v *obj) *location {
+	l := &location{v: v, loopDepth: e.loopDepth, param: -1, result: -1}
+	e.all = append(e.all, l)
+	if v != nil {
+		e.locs[v] = l
+	}
+	return l
+}
+
+// loc returns the location of the local variable v. A variable is first
+// seen where it is declared.
+func (e *escState) loc(v *obj) *location {
+	if l, ok := e.locs[v]; ok {
+		return l
+	}
+	return e.newLoc(v)
+}
+
+func (e *escState) heapHole() hole {
+	return hole{loc: e.heap}
+}
+
+func (e *escState) discard(n expression) {
+	e.expr(hole{}, n)
+}
+
+// flow adds the edge from src, dereferenced derefs times, into k.
+func (e *escState) flow(k hole, src *location, derefs int) {
+	if k.loc == nil || k.loc == src {
+		return
+	}
+	k.loc.edges = append(k.loc.edges, edge{src, k.derefs + derefs})
+}
+
+func (e *escState) stmt(n statement) {
+	switch n := n.(type) {
+	case *blockStmt:
+		for _, s := range n.stmts {
+			e.stmt(s)
+		}
+	case *returnStmt:
+		e.stmt(n.child)
+	case *ifStmt:
+		e.stmt(n.init)
+		e.discard(n.cond)
+		e.stmt(n.then)
+		e.stmt(n.els)
+	case *forStmt:
+		// the variables of the init statement are per iteration
+		e.loopDepth++
+		e.stmt(n.init)
+		e.discard(n.cond)
+		e.stmt(n.post)
+		e.stmt(n.body)
+		e.loopDepth--
+	case *mapIterStmt:
+		if n.m != nil {
+			e.expr(hole{loc: e.loc(n.it)}, n.m)
+		}
+	case *expressionStmt:
+		e.discard(n.child)
+	case *deferStmt:
+		// the call of a record is made by a function of its own
+		e.stmt(n.setup)
+		if n.bits != nil {
+			e.discard(n.call)
+		}
+	case *assignment:
+		ks := make([]hole, len(n.lhs))
+		for i, lhs := range n.lhs {
+			ks[i] = e.assignHole(lhs)
+		}
+		if len(n.rhs) == len(n.lhs) {
+			for i, rhs := range n.rhs {
+				e.expr(ks[i], rhs)
+			}
+			return
+		}
+		switch rhs := n.rhs[0].(type) {
+		case *funcCall, *indirectCall, *ifaceCall:
+			e.call(ks, rhs)
+		default:
+			e.expr(ks[0], rhs)
+		}
+	}
+}
+
+// assignHole returns the hole of the left-hand side of an assignment.
+// Anything stored through a pointer is taken to be stored in the heap.
+func (e *escState) assignHole(n expression) hole {
+	switch n := n.(type) {
+	case *obj:
+		if !n.global {
+			return hole{loc: e.loc(n)}
+		}
+	case *memberRef:
+		if n.child.getType().kind != typeKindPtr {
+			return e.assignHole(n.child)
+		}
+	case *indexExpr:
+		switch n.child.getType().kind {
+		case typeKindArray:
+			e.discard(n.idx)
+			return e.assignHole(n.child)
+		case typeKindMap:
+			e.expr(e.heapHole(), n.idx)
+		}
+	}
+	e.discard(n)
+	return e.heapHole()
+}
+
+func (e *escState) expr(k hole, n expression) {
+	switch n := n.(type) {
+	case *obj:
+		if !n.global {
+			e.flow(k, e.loc(n), 0)
+		}
+	case *addr:
+		e.addr(k, n.child)
+	case *deref:
+		e.expr(k.deref(), n.child)
+	case *memberRef:
+		if n.child.getType().kind == typeKindPtr {
+			k = k.deref()
+		}
+		e.expr(k, n.child)
+	case *indexExpr:
+		e.discard(n.idx)
+		if n.child.getType().kind != typeKindArray {
+			k = k.deref()
+		}
+		e.expr(k, n.child)
+	case *sliceExpr:
+		e.discard(n.lo)
+		e.discard(n.hi)
+		e.discard(n.max)
+		if n.child.getType().kind == typeKindArray {
+			e.addr(k, n.child)
+		} else {
+			e.expr(k, n.child)
+		}
+	case *conversion:
+		e.expr(k, n.child)
+	case *typeAssert:
+		e.expr(k, n.child)
+	case *typeSwitchGuard:
+		e.expr(k, n.child)
+	case *typeCheck:
+		e.discard(n.child)
+	case *toIface:
+		// other values are copied into a new box
+		ty := n.child.getType()
+		if ty.kind != typeKindInterface && !isPointerShaped(ty) {
+			k = e.heapHole()
+		}
+		e.expr(k, n.child)
+	case *binary:
+		e.discard(n.lhs)
+		e.discard(n.rhs)
+	case *unary:
+		e.discard(n.child)
+	case *compositeLit:
+		if n.ty.kind == typeKindSlice {
+			k = e.heapHole()
+		}
+		for _, elem := range n.elems {
+			e.expr(k, elem)
+		}
+	case *mapLit:
+		for i := range n.keys {
+			e.expr(e.heapHole(), n.keys[i])
+			e.expr(e.heapHole(), n.vals[i])
+		}
+	case *funcCall, *indirectCall, *ifaceCall:
+		e.call([]hole{k}, n)
+	case *builtinCall:
+		e.builtin(k, n)
+	case *funcLit:
+		for _, c := range n.captures {
+			e.addr(e.heapHole(), c)
+		}
+	case *methodVal:
+		e.expr(e.heapHole(), n.recv)
+	case *ifaceMethod:
+		e.expr(e.heapHole(), n.recv)
+	}
+}
+
+// addr flows the address of n into k.
+func (e *escState) addr(k hole, n expression) {
+	switch n := n.(type) {
+	case *obj:
+		if !n.global {
+			l := e.loc(n)
+			l.addrTaken = true
+			e.flow(k, l, -1)
+		}
+	case *memberRef:
+		if n.child.getType().kind == typeKindPtr {
+			e.expr(k, n.child)
+		} else {
+			e.addr(k, n.child)
+		}
+	case *indexExpr:
+		e.discard(n.idx)
+		if n.child.getType().kind == typeKindArray {
+			e.addr(k, n.child)
+		} else {
+			e.expr(k, n.child)
+		}
+	case *deref:
+		e.expr(k, n.child)
+	case *compositeLit:
+		// &T{...} is allocated in the heap
+		for _, elem := range n.elems {
+			e.expr(e.heapHole(), elem)
+		}
+	}
+}
+
+// call flows the arguments of a call into the heap and into the holes of
+// its results as the summary of the callee says.
+func (e *escState) call(ks []hole, n expression) {
+	switch n := n.(type) {
+	case *funcCall:
+		for i, arg := range n.args {
+			if n.target.leaks == nil || i >= len(n.target.leaks) {
+				e.expr(e.heapHole(), arg)
+				continue
+			}
+			lk := n.target.leaks[i]
+			tmp := e.newLoc(nil)
+			e.expr(hole{loc: tmp}, arg)
+			if lk.heap >= 0 {
+				e.flow(e.heapHole(), tmp, lk.heap)
+			}
+			for j, d := range lk.results {
+				if d >= 0 && j < len(ks) {
+					e.flow(ks[j], tmp, d)
+				}
+			}
+		}
+	case *indirectCall:
+		e.discard(n.fn)
+		for _, arg := range n.args {
+			e.expr(e.heapHole(), arg)
+		}
+	case *ifaceCall:
+		e.expr(e.heapHole(), n.recv)
+		for _, arg := range n.args {
+			e.expr(e.heapHole(), arg)
+		}
+	}
+}
+
+func (e *escState) builtin(k hole, n *builtinCall) {
+	switch n.name {
+	case "append":
+		e.expr(k, n.args[0])
+		for _, arg := range n.args[1:] {
+			if n.spread {
+				e.expr(e.heapHole().deref(), arg)
+			} else {
+				e.expr(e.heapHole(), arg)
+			}
+		}
+	case "copy":
+		e.discard(n.args[0])
+		e.expr(e.heapHole().deref(), n.args[1])
+	case "panic":
+		e.expr(e.heapHole(), n.args[0])
+	default:
+		for _, arg := range n.args {
+			e.discard(arg)
+		}
+	}
+}
+
+// walk finds the locations whose values flow into root and how many
+// dereferences they take to get there.
+func (e *escState) walk(root *location) {
+	e.walkgen++
+	root.walkgen = e.walkgen
+	root.derefs = 0
+	todo := []*location{root}
+	for len(todo) > 0 {
+		l := todo[0]
+		todo = todo[1:]
+
+		derefs := l.derefs
+		if derefs < 0 {
+			// for root = &l and l = x, the address of l flows into
+			// root but that of x does not
+			derefs = 0
+			if !l.escapes && e.outlives(root, l) {
+				// l now lives in the heap, and so does what is
+				// stored in it
+				l.escapes = true
+				e.flow(e.heapHole(), l, 0)
+			}
+		}
+		if l.param >= 0 && (root == e.heap || root.result >= 0) {
+			e.leakTo(l.param, root, derefs)
+		}
+
+		for _, ed := range l.edges {
+			d := derefs + ed.derefs
+			if ed.src.walkgen != e.walkgen || d < ed.src.derefs {
+				ed.src.walkgen = e.walkgen
+				ed.src.derefs = d
+				todo = append(todo, ed.src)
+			}
+		}
+	}
+}
+
+// outlives reports whether the location root may be used after l is gone.
+func (e *escState) outlives(root, l *location) bool {
+	if root == e.heap || root.result >= 0 {
+		return true
+	}
+	return root.loopDepth < l.loopDepth
+}
+
+func (e *escState) numEscapes() int {
+	n := 0
+	for _, l := range e.all {
+		if l.escapes {
+			n++
+		}
+	}
+	return n
+}
+
+func (e *escState) leakTo(param int, root *location, derefs int) {
+	lk := &e.fn.leaks[param]
+	p := &lk.heap
+	if root != e.heap {
+		p = &lk.results[root.result]
+	}
+	if *p < 0 || derefs < *p {
+		*p = derefs
+		e.changed = true
+	}
+}
+
+// apply moves the escaping variables of the function to the heap. An
+// escaping parameter is copied into the heap on entry, and an escaping
+// result back from it on return.
+func (e *escState) apply() {
+	if printEscapes {
+		e.explain()
+	}
+
+	f := e.fn
+	vars := map[*obj]bool{}
+	for _, v := range f.locals {
+		if l, ok := e.locs[v]; ok && l.escapes && l.param < 0 && !containsObj(f.results, v) {
+			v.onHeap = true
+			vars[v] = true
+		}
+	}
+	f.body = e.declareOnHeap(f.body, vars)
+
+	var stmts []statement
+	for i, p := range f.params {
+		if !e.locs[p].escapes {
+			continue
+		}
+		in := &obj{name: p.name, ty: p.ty}
+		f.params[i] = in
+		p.onHeap = true
+		stmts = append(stmts, &heapVarStmt{v: p}, newDeclAssignment([]expression{p}, expressionList{in}))
+	}
+	var copies []statement
+	for i, r := range f.results {
+		if l, ok := e.locs[r]; !ok || !l.escapes {
+			continue
+		}
+		out := &obj{name: r.name, ty: r.ty}
+		f.results[i] = out
+		r.onHeap = true
+		stmts = append(stmts, &heapVarStmt{v: r})
+		copies = append(copies, newDeclAssignment([]expression{out}, expressionList{r}))
+	}
+	if copies != nil {
+		f.copyResults = &blockStmt{stmts: copies}
+	}
+	if stmts != nil {
+		f.body = &blockStmt{stmts: append(stmts, f.body)}
+	}
+}
+
+// declareOnHeap makes the variables in vars get new memory where they
+// are declared, before the assignment of their initial values. One
+// declared by the init statement of a for loop also gets new memory with
+// its current value before the post statement, so that every iteration
+// has its own.
+func (e *escState) declareOnHeap(n statement, vars map[*obj]bool) statement {
+	switch n := n.(type) {
+	case *blockStmt:
+		for i, s := range n.stmts {
+			n.stmts[i] = e.declareOnHeap(s, vars)
+		}
+	case *ifStmt:
+		n.init = e.declareOnHeap(n.init, vars)
+		n.then = e.declareOnHeap(n.then, vars)
+		n.els = e.declareOnHeap(n.els, vars)
+	case *forStmt:
+		var stmts []statement
+		if init, ok := n.init.(*assignment); ok {
+			for _, lhs := range init.lhs {
+				if v, ok := lhs.(*obj); ok && vars[v] {
+					tmp := &obj{name: newUniqueName(), ty: v.ty}
+					e.fn.locals = append(e.fn.locals, tmp)
+					stmts = append(stmts,
+						newDeclAssignment([]expression{tmp}, expressionList{v}),
+						&heapVarStmt{v: v},
+						newDeclAssignment([]expression{v}, expressionList{tmp}))
+				}
+			}
+		}
+		n.init = e.declareOnHeap(n.init, vars)
+		n.body = e.declareOnHeap(n.body, vars)
+		if stmts != nil {
+			if n.post != nil {
+				stmts = append(stmts, n.post)
+			}
+			n.post = &blockStmt{stmts: stmts}
+		}
+	case *assignment:
+		var stmts []statement
+		for _, lhs := range n.lhs {
+			if v, ok := lhs.(*obj); ok && vars[v] {
+				delete(vars, v)
+				stmts = append(stmts, &heapVarStmt{v: v})
+			}
+		}
+		if stmts != nil {
+			return &blockStmt{stmts: append(stmts, n)}
+		}
+	}
+	return n
+}
+
+// explain prints the decisions for the parameters holding pointers and
+// the variables whose address is taken, in the form of the Go toolchain.
+func (e *escState) explain() {
+	name := e.fn.name
+	for i, p := range e.fn.params {
+		lk := e.fn.leaks[i]
+		l := e.locs[p]
+		switch {
+		case l.escapes:
+			fmt.Fprintf(os.Stderr, "%s: moved to heap: %s\n", name, p.name)
+		case !hasPointers(p.ty):
+		case lk.heap == 0:
+			fmt.Fprintf(os.Stderr, "%s: leaking param: %s\n", name, p.name)
+		case lk.heap > 0:
+			fmt.Fprintf(os.Stderr, "%s: leaking param content: %s\n", name, p.name)
+		default:
+			leaked := false
+			for j, d := range lk.results {
+				if d >= 0 {
+					res := e.fn.results[j].name
+					if res == "" {
+						res = fmt.Sprintf("~r%d", j)
+					}
+					fmt.Fprintf(os.Stderr, "%s: leaking param: %s to result %s level=%d\n", name, p.name, res, d)
+					leaked = true
+				}
+			}
+			if !leaked {
+				fmt.Fprintf(os.Stderr, "%s: %s does not escape\n", name, p.name)
+			}
+		}
+	}
+	for _, v := range e.fn.locals {
+		l, ok := e.locs[v]
+		if !ok || l.param >= 0 || l.result >= 0 || !l.addrTaken || strings.HasPrefix(v.name, ".") {
+			continue
+		}
+		if l.escapes {
+			fmt.Fprintf(os.Stderr, "%s: moved to heap: %s\n", name, v.name)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s does not escape\n", name, v.name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Floats are kept in general-purpose registers and stack words like the
+// other scalars, a float32 in the low half of its word, and are moved to
+// SSE registers to be operated on.
+
+// genFloatBinary applies op to the floats of type ty in rax and rdi and
+// leaves the result in rax. Comparisons with NaN are false except for !=.
+func genFloatBinary(op string, ty *typ) {
+	sfx := floatSuffix(ty)
+	genToXmm("xmm0", "rax", ty)
+	genToXmm("xmm1", "rdi", ty)
+	switch op {
+	case "+":
+		fmt.Printf("\tadd%s xmm0, xmm1\n", sfx)
+	case "-":
+		fmt.Printf("\tsub%s xmm0, xmm1\n", sfx)
+	case "*":
+		fmt.Printf("\tmul%s xmm0, xmm1\n", sfx)
+	case "/":
+		fmt.Printf("\tdiv%s xmm0, xmm1\n", sfx)
+	case "<":
+		// an unordered result sets CF and ZF
+		fmt.Printf("\tucomi%s xmm1, xmm0\n", sfx)
+		fmt.Printf("\tseta al\n")
+		fmt.Printf("\tmovzb rax, al\n")
+		return
+	case "<=":
+		fmt.Printf("\tucomi%s xmm1, xmm0\n", sfx)
+		fmt.Printf("\tsetae al\n")
+		fmt.Printf("\tmovzb rax, al\n")
+		return
+	case "==":
+		// an unordered result sets PF
+		fmt.Printf("\tucomi%s xmm0, xmm1\n", sfx)
+		fmt.Printf("\tsete al\n")
+		fmt.Printf("\tsetnp cl\n")
+		fmt.Printf("\tand al, cl\n")
+		fmt.Printf("\tmovzb rax, al\n")
+		return
+	case "!=":
+		fmt.Printf("\tucomi%s xmm0, xmm1\n", sfx)
+		fmt.Printf("\tsetne al\n")
+		fmt.Printf("\tsetp cl\n")
+		fmt.Printf("\tor al, cl\n")
+		fmt.Printf("\tmovzb rax, al\n")
+		return
+	}
+	genFromXmm("rax", "xmm0", ty)
+}
+
+// floatSuffix is the suffix of the scalar SSE instructions on ty.
+func floatSuffix(ty *typ) string {
+	if ty.size == 4 {
+		return "ss"
+	}
+	return "sd"
+}
+
+// genToXmm moves the float of type ty in the register reg to xmm.
+func genToXmm(xmm, reg string, ty *typ) {
+	if ty.size == 4 {
+		fmt.Printf("\tmovd %s, %s\n", xmm, "e"+reg[1:])
+		return
+	}
+	fmt.Printf("\tmovq %s, %s\n", xmm, reg)
+}
+
+// genFromXmm moves the float of type ty in xmm to the register reg. A
+// float32 leaves the upper half of reg zero.
+func genFromXmm(reg, xmm string, ty *typ) {
+	if ty.size == 4 {
+		fmt.Printf("\tmovd %s, %s\n", "e"+reg[1:], xmm)
+		return
+	}
+	fmt.Printf("\tmovq %s, %s\n", reg, xmm)
+}
+
+var floatLits []int64
+
+// floatLabel returns the label of the bits of a float constant in
+// .rodata.
+func floatLabel(bits int64) string {
+	for i, b := range floatLits {
+		if b == bits {
+			return fmt.Sprintf("float.%d", i)
+		}
+	}
+	floatLits = append(floatLits, bits)
+	return fmt.Sprintf("float.%d", len(floatLits)-1)
+}
+
+func emitFloats() {
+	fmt.Printf("\t.section .rodata\n")
+	fmt.Printf("\t.align 8\n")
+	for i, bits := range floatLits {
+		fmt.Printf("float.%d:\n", i)
+		fmt.Printf("\t.quad %d\n", bits)
+	}
+}
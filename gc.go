@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The collector finds pointers with bitmaps of the words that hold them.
+// A bitmap in .rodata is the number of words it covers followed by the
+// bits, lowest word first; a type without pointers has none and its
+// label is 0. Heap objects hold values of one type, repeated for arrays.
+//
+// Every function has a frame map with bitmaps of its locals and of its
+// parameters and results, and every call site in compiled code is listed
+// in runtime.stackMaps with the frame map of its function and the words
+// pushed below the frame at the call, so that the collector can tell from
+// a return address how to scan the frame it returns to.
+
+// pointerWords sets the bits of the words of a value of type ty at byte
+// offset off that hold pointers.
+func pointerWords(bits []bool, ty *typ, off int) {
+	switch ty.kind {
+	case typeKindPtr, typeKindSlice, typeKindMap, typeKindFunc:
+		bits[off/8] = true
+	case typeKindInterface:
+		// the first word is an itab or type descriptor, never in the heap
+		bits[off/8+1] = true
+	case typeKindArray:
+		if !hasPointers(ty.base) {
+			return
+		}
+		for i := 0; i < ty.length; i++ {
+			pointerWords(bits, ty.base, off+i*ty.base.size)
+		}
+	case typeKindStruct:
+		for _, m := range ty.members {
+			pointerWords(bits, m.ty, off+m.offset)
+		}
+	}
+}
+
+// hasPointers reports whether a value of type ty holds a pointer.
+func hasPointers(ty *typ) bool {
+	switch ty.kind {
+	case typeKindPtr, typeKindSlice, typeKindMap, typeKindFunc, typeKindInterface:
+		return true
+	case typeKindArray:
+		return ty.length > 0 && hasPointers(ty.base)
+	case typeKindStruct:
+		for _, m := range ty.members {
+			if hasPointers(m.ty) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var gcBitmaps []string
+
+// gcData returns the label of the bitmap bits, or 0 if no bit is set.
+func gcData(bits []bool) string {
+	var b strings.Builder
+	found := false
+	for _, bit := range bits {
+		if bit {
+			b.WriteByte('1')
+			found = true
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	if !found {
+		return "0"
+	}
+	s := b.String()
+	for i, bm := range gcBitmaps {
+		if bm == s {
+			return fmt.Sprintf("gcdata.%d", i)
+		}
+	}
+	gcBitmaps = append(gcBitmaps, s)
+	return fmt.Sprintf("gcdata.%d", len(gcBitmaps)-1)
+}
+
+// typeGCData returns the label of the bitmap of a value of type ty.
+func typeGCData(ty *typ) string {
+	if !hasPointers(ty) {
+		return "0"
+	}
+	bits := make([]bool, alignTo(ty.size, 8)/8)
+	pointerWords(bits, ty, 0)
+	return gcData(bits)
+}
+
+// frameGCData returns the labels of the bitmaps of the locals and of the
+// parameters and results of f. A local moved to the heap holds a pointer.
+func frameGCData(f *function) (string, string) {
+	locals := make([]bool, f.stackSize/8)
+	args := make([]bool, (f.paramsSize+f.resultsSize)/8)
+	for _, lv := range f.locals {
+		if lv.offset > 0 {
+			continue
+		}
+		if lv.onHeap {
+			locals[(f.stackSize+lv.offset)/8] = true
+		} else {
+			pointerWords(locals, lv.ty, f.stackSize+lv.offset)
+		}
+	}
+	for _, lv := range append(append([]*obj{}, f.params...), f.results...) {
+		if lv.onHeap {
+			args[(lv.offset-16)/8] = true
+		} else {
+			pointerWords(args, lv.ty, lv.offset-16)
+		}
+	}
+	return gcData(locals), gcData(args)
+}
+
+// genFrameZero clears the words of the frame of f the collector scans
+// for pointers, so that it never sees what the memory held before, and
+// the named results, which start as zero values.
+func genFrameZero(f *function) {
+	if locals, _ := frameGCData(f); locals != "0" {
+		fmt.Printf("\tmov rdi, rsp\n")
+		fmt.Printf("\tmov rcx, %d\n", f.stackSize/8)
+		fmt.Printf("\tmov rax, 0\n")
+		fmt.Printf("\trep stosq\n")
+	}
+	for _, lv := range f.results {
+		if hasPointers(lv.ty) || lv.name != "" {
+			fmt.Printf("\tlea rdi, [rbp+%d]\n", lv.offset)
+			fmt.Printf("\tmov rcx, %d\n", alignTo(lv.ty.size, 8)/8)
+			fmt.Printf("\tmov rax, 0\n")
+			fmt.Printf("\trep stosq\n")
+		}
+	}
+}
+
+// temps has a bit for each word the code of the current function has
+// pushed below its frame, the first pushed first, which is set if the
+// word may hold a pointer.
+var temps []bool
+
+// pushed records that a value of type ty was pushed.
+func pushed(ty *typ) {
+	bits := make([]bool, valueSize(ty)/8)
+	pointerWords(bits, ty, 0)
+	for i := len(bits) - 1; i >= 0; i-- {
+		temps = append(temps, bits[i])
+	}
+}
+
+// pushedArgs records that values of types tys were pushed as the
+// arguments of a call, the first on top.
+func pushedArgs(tys []*typ) {
+	for i := len(tys) - 1; i >= 0; i-- {
+		pushed(tys[i])
+	}
+}
+
+// pushedWords records that n words were pushed, which may hold pointers
+// if ptr is set.
+func pushedWords(n int, ptr bool) {
+	for i := 0; i < n; i++ {
+		temps = append(temps, ptr)
+	}
+}
+
+// popped records that size bytes were popped.
+func popped(size int) {
+	temps = temps[:len(temps)-size/8]
+}
+
+// callSite is the function of a call site, the number of words pushed
+// below its frame at the call and their bitmap from the top of the stack.
+type callSite struct {
+	fn     string
+	words  int
+	gcdata string
+}
+
+// callSites are the call sites, in the order of their labels.
+var callSites []callSite
+
+// genCall calls target from the current function and records the call
+// site for the collector.
+func genCall(target string) {
+	bits := make([]bool, len(temps))
+	for i, ptr := range temps {
+		bits[len(temps)-1-i] = ptr
+	}
+	fmt.Printf("\tcall %s\n", target)
+	fmt.Printf(".Lcall%d:\n", len(callSites))
+	callSites = append(callSites, callSite{fn: funcName, words: len(temps), gcdata: gcData(bits)})
+}
+
+// genAlloc allocates zeroed memory of size bytes for values of type ty
+// and pushes its address.
+func genAlloc(ty *typ, size int) {
+	fmt.Printf("\tsub rsp, 8\n")
+	if data := typeGCData(ty); data != "0" {
+		fmt.Printf("\tlea rax, [rip+%s]\n", data)
+		fmt.Printf("\tpush rax\n")
+	} else {
+		fmt.Printf("\tpush 0\n")
+	}
+	fmt.Printf("\tpush %d\n", size)
+	pushedWords(1, true)
+	pushedWords(2, false)
+	genCall("runtime.alloc")
+	fmt.Printf("\tadd rsp, 16\n")
+	popped(16)
+}
+
+// emitGCData emits the frame maps of funcs, the call sites, the roots in
+// package-level variables and the bitmaps. A frame map is the size of the
+// locals, the size of the parameters and results, their bitmaps, the
+// routine making the deferred calls of the function and where a recovered
+// panic resumes it, if it has any, its name and whether it is the function
+// of a defer record.
+func emitGCData(prog *program, funcs []*function) {
+	// the tables hold addresses, which are relocated at load time
+	fmt.Printf("\t.section .data.rel.ro\n")
+	fmt.Printf("\t.align 8\n")
+	for _, f := range funcs {
+		locals, args := frameGCData(f)
+		fmt.Printf("main.%s..stackmap:\n", f.name)
+		fmt.Printf("\t.quad %d\n", f.stackSize)
+		fmt.Printf("\t.quad %d\n", f.paramsSize+f.resultsSize)
+		fmt.Printf("\t.quad %s\n", locals)
+		fmt.Printf("\t.quad %s\n", args)
+		if f.defers != nil {
+			fmt.Printf("\t.quad main.%s..deferreturn\n", f.name)
+			fmt.Printf("\t.quad .Lreturn.main.%s\n", f.name)
+		} else {
+			fmt.Printf("\t.quad 0\n")
+			fmt.Printf("\t.quad 0\n")
+		}
+		name := "main." + f.name
+		fmt.Printf("\t.quad %s, %d\n", stringLabel(name), len(name))
+		if f.deferWrapper {
+			fmt.Printf("\t.quad 1\n")
+		} else {
+			fmt.Printf("\t.quad 0\n")
+		}
+	}
+
+	fmt.Printf("runtime.stackMaps:\n")
+	for i, c := range callSites {
+		fmt.Printf("\t.quad .Lcall%d, %s..stackmap, %d, %s\n", i, c.fn, c.words, c.gcdata)
+	}
+	fmt.Printf("runtime.nstackMaps:\n")
+	fmt.Printf("\t.quad %d\n", len(callSites))
+
+	n := 0
+	fmt.Printf("runtime.gcRoots:\n")
+	for _, gv := range prog.globals {
+		if data := typeGCData(gv.ty); data != "0" {
+			fmt.Printf("\t.quad main.%s, %s, %d\n", gv.name, data, alignTo(gv.ty.size, 8))
+			n++
+		}
+	}
+	fmt.Printf("runtime.ngcRoots:\n")
+	fmt.Printf("\t.quad %d\n", n)
+
+	fmt.Printf("\t.section .rodata\n")
+	fmt.Printf("\t.align 8\n")
+	for i, bm := range gcBitmaps {
+		fmt.Printf("gcdata.%d:\n", i)
+		fmt.Printf("\t.quad %d\n", len(bm))
+		for j := 0; j < len(bm); j += 64 {
+			var w uint64
+			for k := j; k < j+64 && k < len(bm); k++ {
+				if bm[k] == '1' {
+					w |= 1 << uint(k-j)
+				}
+			}
+			fmt.Printf("\t.quad 0x%x\n", w)
+		}
+	}
+}
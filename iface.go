@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// An interface value is two words: the itab of its dynamic type, or the
+// type descriptor itself for the empty interface, and the data word. The
+// data word is the value if it is pointer-shaped and otherwise points to a
+// copy of it on the heap.
+
+// imethod is a method of an interface type.
+type imethod struct {
+	name string
+	ty   *typ
+}
+
+// itab is the method table of a concrete type for an interface type. The
+// methods take the data word as the receiver.
+type itab struct {
+	id       int
+	concrete *typ
+	iface    *typ
+	fns      []*function
+}
+
+var itabs []*itab
+
+// boxedTypes are the concrete types converted to interfaces, which are the
+// only dynamic types an interface value can have.
+var boxedTypes []*typ
+
+// assertIfaces are the non-empty interface types whose itabs are looked up
+// at run time by type assertions and conversions.
+var assertIfaces []*typ
+
+// InterfaceType = "interface" "{" { InterfaceElem ";" } "}" .
+// InterfaceElem = MethodElem | TypeName .
+// MethodElem    = MethodName Signature .
+func parseInterfaceType() *typ {
+	expect("{")
+	var methods []*imethod
+	add := func(m *imethod) {
+		for _, mm := range methods {
+			if mm.name == m.name {
+				panic(fmt.Sprintf("duplicate method %s", m.name))
+			}
+		}
+		methods = append(methods, m)
+	}
+	for !consume("}") {
+		tok := consumeToken(tokenKindIdentifier)
+		if tok == nil {
+			panic(fmt.Sprintf("Expected a method name: %+v", tokens[0]))
+		}
+		if peek("(") {
+			add(&imethod{name: tok.val, ty: parseFuncSignature()})
+		} else {
+			tn, ok := lookup(tok.val).(*typeName)
+			if !ok || tn.ty.kind != typeKindInterface {
+				panic(fmt.Sprintf("%s is not an interface", tok.val))
+			}
+			for _, m := range tn.ty.imethods {
+				add(m)
+			}
+		}
+		if !peek("}") {
+			expect(";")
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].name < methods[j].name })
+
+	ty := newType(typeKindInterface, 16)
+	ty.imethods = methods
+	return ty
+}
+
+// parseFuncSignature parses a signature that belongs to no function body,
+// so its parameters are not variables of the function being parsed.
+func parseFuncSignature() *typ {
+	savedLocals, savedResults := locals, results
+	locals, results = nil, nil
+	enterScope()
+
+	expect("(")
+	params, res := parseSignature()
+	ty := funcType(params, res)
+
+	leaveScope()
+	locals, results = savedLocals, savedResults
+	return ty
+}
+
+func findIMethod(ty *typ, name string) (int, *imethod) {
+	for i, m := range ty.imethods {
+		if m.name == name {
+			return i, m
+		}
+	}
+	return -1, nil
+}
+
+// missingMethod returns the name of a method of the interface iface that
+// is not in the method set of ty, or "" if ty implements iface.
+func missingMethod(ty, iface *typ) string {
+	for _, im := range iface.imethods {
+		if ty.kind == typeKindInterface {
+			if _, m := findIMethod(ty, im.name); m == nil || !identical(m.ty, im.ty) {
+				return im.name
+			}
+			continue
+		}
+		m, isPtr := lookupTypeMethod(ty, im.name)
+		if m == nil || (m.ptrRecv && !isPtr) || !identical(methodType(m), im.ty) {
+			return im.name
+		}
+	}
+	return ""
+}
+
+// lookupTypeMethod finds the method name of the type ty, which may be a
+// pointer to the receiver type.
+func lookupTypeMethod(ty *typ, name string) (*method, bool) {
+	if ty.kind == typeKindPtr && ty.name == "" {
+		return methodOf(ty.base, name), true
+	}
+	return methodOf(ty, name), false
+}
+
+// methodType is the type of a method without its receiver.
+func methodType(m *method) *typ {
+	return funcType(m.fn.params[1:], m.fn.results)
+}
+
+// isPointerShaped reports whether a value of type ty is stored in the data
+// word of an interface directly.
+func isPointerShaped(ty *typ) bool {
+	return ty.kind == typeKindPtr || ty.kind == typeKindFunc || ty.kind == typeKindMap
+}
+
+func isEmptyInterface(ty *typ) bool {
+	return ty.kind == typeKindInterface && len(ty.imethods) == 0
+}
+
+// convertToInterface returns e converted to the interface type iface.
+func convertToInterface(e expression, iface *typ) expression {
+	ty := e.getType()
+	if ty.kind != typeKindInterface {
+		addBoxedType(ty)
+		if !isEmptyInterface(iface) {
+			getItab(ty, iface)
+		}
+	} else if !isEmptyInterface(iface) {
+		addAssertIface(iface)
+	}
+	return &toIface{child: e, ty: iface}
+}
+
+func addBoxedType(ty *typ) {
+	for _, t := range boxedTypes {
+		if identical(t, ty) {
+			return
+		}
+	}
+	boxedTypes = append(boxedTypes, ty)
+}
+
+func addAssertIface(ty *typ) {
+	for _, t := range assertIfaces {
+		if identical(t, ty) {
+			return
+		}
+	}
+	assertIfaces = append(assertIfaces, ty)
+}
+
+// getItab returns the itab of the concrete type ty for iface, which ty
+// implements.
+func getItab(ty, iface *typ) *itab {
+	for _, it := range itabs {
+		if identical(it.concrete, ty) && identical(it.iface, iface) {
+			return it
+		}
+	}
+
+	it := &itab{id: len(itabs), concrete: ty, iface: iface}
+	for _, im := range iface.imethods {
+		m, isPtr := lookupTypeMethod(ty, im.name)
+		if m.ptrRecv || (!isPtr && isPointerShaped(ty)) {
+			// the data word is the receiver
+			it.fns = append(it.fns, m.fn)
+		} else {
+			it.fns = append(it.fns, ptrMethodWrapper(m))
+		}
+	}
+	itabs = append(itabs, it)
+	return it
+}
+
+// buildItabs creates the itabs that type assertions and conversions to
+// non-empty interfaces may need at run time.
+func buildItabs() {
+	for _, iface := range assertIfaces {
+		for _, ty := range boxedTypes {
+			if missingMethod(ty, iface) == "" {
+				getItab(ty, iface)
+			}
+		}
+	}
+}
+
+// parseTypeAssertion parses x.(T), or the guard x.(type) of a type switch.
+func parseTypeAssertion(expr expression) expression {
+	addType(expr)
+	ity := expr.getType()
+	if ity.kind != typeKindInterface {
+		panic(fmt.Sprintf("invalid operation: %s is not an interface", ity))
+	}
+
+	if consume("type") {
+		expect(")")
+		return &typeSwitchGuard{child: expr}
+	}
+	ty := parseType()
+	expect(")")
+
+	if ty.kind == typeKindInterface {
+		if !isEmptyInterface(ty) {
+			addAssertIface(ty)
+		}
+	} else {
+		if m := missingMethod(ty, ity); m != "" {
+			panic(fmt.Sprintf("impossible type assertion: %s does not implement %s (missing method %s)", ty, ity, m))
+		}
+		addBoxedType(ty)
+	}
+	return &typeAssert{child: expr, ty: ty}
+}
+
+// typeDescs are the types that have a descriptor in the executable. Each
+// type is represented by the first identical type in the list.
+var typeDescs []*typ
+
+// typeDesc returns the symbol of the descriptor of ty.
+func typeDesc(ty *typ) string {
+	for i, t := range typeDescs {
+		if identical(t, ty) {
+			return fmt.Sprintf("type.%d", i)
+		}
+	}
+	typeDescs = append(typeDescs, ty)
+	return fmt.Sprintf("type.%d", len(typeDescs)-1)
+}
@@ -4,7 +4,13 @@ import "os"
 
 func main() {
 
-	in = os.Args[1]
+	for _, arg := range os.Args[1:] {
+		if arg == "-m" {
+			printEscapes = true
+			continue
+		}
+		in = arg
+	}
 
 	tokenize()
 
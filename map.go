@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A map value is a pointer to the header of a hash table in the runtime, or
+// nil. The runtime gets a descriptor of the map type with the functions
+// that hash and compare its keys, which are generated from the key type.
+
+// MapType = "map" "[" KeyType "]" ElementType .
+// KeyType = Type .
+func parseMapType() *typ {
+	expect("[")
+	key := parseType()
+	expect("]")
+	elem := parseType()
+	if key.size >= 0 && !comparable(key) {
+		panic(fmt.Sprintf("invalid map key type %s", key))
+	}
+	ty := newType(typeKindMap, 8)
+	ty.key = key
+	ty.base = elem
+	return ty
+}
+
+// comparable reports whether ty can be a map key, which it can if it is
+// made of numbers, booleans and pointers.
+func comparable(ty *typ) bool {
+	if isNumeric(ty) {
+		return true
+	}
+	switch ty.kind {
+	case typeKindBool, typeKindPtr:
+		return true
+	case typeKindArray:
+		return comparable(ty.base)
+	case typeKindStruct:
+		for _, m := range ty.members {
+			if !comparable(m.ty) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// parseMapLiteral parses the key-value pairs of a map literal. Constant
+// keys must be distinct.
+func parseMapLiteral(ty *typ) expression {
+	ret := &mapLit{ty: ty, tmp: createLocalVar(newUniqueName())}
+	ret.tmp.ty = ty
+	for i := 0; !consume("}"); i++ {
+		if i > 0 {
+			expect(",")
+			if consume("}") {
+				break
+			}
+		}
+		key := parseElement(ty.key)
+		expect(":")
+		val := parseElement(ty.base)
+
+		key = checkAssignable(key, ty.key, "map literal")
+		if c, ok := key.(*constExpr); ok {
+			for _, k := range ret.keys {
+				if kc, ok := k.(*constExpr); ok && kc.val.String() == c.val.String() {
+					panic(fmt.Sprintf("duplicate key %s in map literal", c.val))
+				}
+			}
+		}
+		ret.keys = append(ret.keys, key)
+		ret.vals = append(ret.vals, checkAssignable(val, ty.base, "map literal"))
+	}
+	return ret
+}
+
+// mapTypes are the map types with a descriptor in the executable. Each is
+// represented by the first identical type in the list.
+var mapTypes []*typ
+
+// mapType returns the symbol of the descriptor of the map type ty.
+func mapType(ty *typ) string {
+	for i, t := range mapTypes {
+		if identical(t, ty) {
+			return fmt.Sprintf("maptype.%d", i)
+		}
+	}
+	mapTypes = append(mapTypes, ty)
+	return fmt.Sprintf("maptype.%d", len(mapTypes)-1)
+}
+
+// bucketSize is the size of a bucket of a map with keys of size ks and
+// elements of size es: eight tophash bytes, the evacuated flag, eight keys,
+// eight elements and the overflow pointer.
+func bucketSize(ks, es int) int {
+	return 16 + 8*alignTo(ks, 8) + 8*alignTo(es, 8) + 8
+}
+
+// bucketGCData returns the label of the pointer bitmap of a bucket of the
+// map type ty.
+func bucketGCData(ty *typ) string {
+	ks, es := alignTo(ty.key.size, 8), alignTo(ty.base.size, 8)
+	bits := make([]bool, bucketSize(ty.key.size, ty.base.size)/8)
+	for i := 0; i < 8; i++ {
+		pointerWords(bits, ty.key, 16+i*ks)
+		pointerWords(bits, ty.base, 16+8*ks+i*es)
+	}
+	bits[len(bits)-1] = true
+	return gcData(bits)
+}
+
+// emitMapTypes emits the map type descriptors: the key and element strides
+// in buckets, the bucket size, the hash and equality functions of the keys,
+// the key size and the pointer bitmap of a bucket. It also
+// reserves the zero value map lookups return for missing keys.
+func emitMapTypes() {
+	zeroSize := 8
+	for i, ty := range mapTypes {
+		ks, es := ty.key.size, ty.base.size
+		if es > zeroSize {
+			zeroSize = es
+		}
+		fmt.Printf("\t.section .data.rel.ro\n")
+		fmt.Printf("\t.align 8\n")
+		fmt.Printf("maptype.%d:\n", i)
+		fmt.Printf("\t.quad %d\n", alignTo(ks, 8))
+		fmt.Printf("\t.quad %d\n", alignTo(es, 8))
+		fmt.Printf("\t.quad %d\n", bucketSize(ks, es))
+		fmt.Printf("\t.quad maptype.%d.hash\n", i)
+		fmt.Printf("\t.quad maptype.%d.eq\n", i)
+		fmt.Printf("\t.quad %d\n", ks)
+		fmt.Printf("\t.quad %s\n", bucketGCData(ty))
+
+		fmt.Printf("\t.text\n")
+		labelCnt++
+		fmt.Printf("maptype.%d.hash:\n", i)
+		fmt.Printf("\tmov rax, rsi\n")
+		fmt.Printf("\tmov r9, %d\n", hashMul)
+		genKeyWalk(ty.key, 0, func(off int, ty *typ) {
+			loadScalar("rcx", "rdi", off, ty.size)
+			if isFloat(ty) {
+				// -0 hashes like +0, which it equals
+				genToXmm("xmm0", "rcx", ty)
+				fmt.Printf("\txorps xmm1, xmm1\n")
+				fmt.Printf("\tadd%s xmm0, xmm1\n", floatSuffix(ty))
+				genFromXmm("rcx", "xmm0", ty)
+			}
+			fmt.Printf("\txor rax, rcx\n")
+			fmt.Printf("\timul rax, r9\n")
+			fmt.Printf("\trol rax, 31\n")
+		}, "rdi")
+		fmt.Printf("\tmov rcx, rax\n")
+		fmt.Printf("\tshr rcx, 32\n")
+		fmt.Printf("\txor rax, rcx\n")
+		fmt.Printf("\timul rax, r9\n")
+		fmt.Printf("\tmov rcx, rax\n")
+		fmt.Printf("\tshr rcx, 29\n")
+		fmt.Printf("\txor rax, rcx\n")
+		fmt.Printf("\tret\n")
+
+		labelCnt++
+		ne := labelCnt
+		fmt.Printf("maptype.%d.eq:\n", i)
+		fmt.Printf("\tmov r10, rsp\n")
+		genKeyWalk(ty.key, 0, func(off int, ty *typ) {
+			loadScalar("rcx", "rdi", off, ty.size)
+			loadScalar("rdx", "rsi", off, ty.size)
+			if isFloat(ty) {
+				// NaN is not equal to itself
+				genToXmm("xmm0", "rcx", ty)
+				genToXmm("xmm1", "rdx", ty)
+				fmt.Printf("\tucomi%s xmm0, xmm1\n", floatSuffix(ty))
+				fmt.Printf("\tjne .Lne%d\n", ne)
+				fmt.Printf("\tjp .Lne%d\n", ne)
+				return
+			}
+			fmt.Printf("\tcmp rcx, rdx\n")
+			fmt.Printf("\tjne .Lne%d\n", ne)
+		}, "rdi", "rsi")
+		fmt.Printf("\tmov rax, 1\n")
+		fmt.Printf("\tret\n")
+		fmt.Printf(".Lne%d:\n", ne)
+		fmt.Printf("\tmov rsp, r10\n")
+		fmt.Printf("\tmov rax, 0\n")
+		fmt.Printf("\tret\n")
+	}
+
+	fmt.Printf("\t.bss\n")
+	fmt.Printf("\t.align 8\n")
+	fmt.Printf("runtime.zeroVal:\n")
+	fmt.Printf("\t.zero %d\n", alignTo(zeroSize, 8))
+}
+
+// hashMul is the odd multiplier that mixes the words of a key.
+const hashMul = -7046029254386353131
+
+// genKeyWalk calls fn with the offset and type of each scalar in a key of
+// type ty at offset off from the pointers in ptrs. The elements of arrays
+// are visited in a loop that advances the pointers.
+func genKeyWalk(ty *typ, off int, fn func(off int, ty *typ), ptrs ...string) {
+	switch ty.kind {
+	case typeKindStruct:
+		for _, m := range ty.members {
+			genKeyWalk(m.ty, off+m.offset, fn, ptrs...)
+		}
+	case typeKindArray:
+		if ty.length == 0 {
+			return
+		}
+		labelCnt++
+		cnt := labelCnt
+		for _, p := range ptrs {
+			fmt.Printf("\tpush %s\n", p)
+			fmt.Printf("\tlea %s, [%s+%d]\n", p, p, off)
+		}
+		fmt.Printf("\tpush %d\n", ty.length)
+		fmt.Printf(".Lkey%d:\n", cnt)
+		genKeyWalk(ty.base, 0, fn, ptrs...)
+		for _, p := range ptrs {
+			fmt.Printf("\tadd %s, %d\n", p, ty.base.size)
+		}
+		fmt.Printf("\tdec qword ptr [rsp]\n")
+		fmt.Printf("\tjnz .Lkey%d\n", cnt)
+		fmt.Printf("\tadd rsp, 8\n")
+		for i := len(ptrs) - 1; i >= 0; i-- {
+			fmt.Printf("\tpop %s\n", ptrs[i])
+		}
+	default:
+		fn(off, ty)
+	}
+}
+
+// loadScalar zero-extends the scalar of the given size at [ptr+off] into
+// the 64-bit register reg.
+func loadScalar(reg, ptr string, off, size int) {
+	switch size {
+	case 1:
+		fmt.Printf("\tmovzx %s, byte ptr [%s+%d]\n", reg, ptr, off)
+	case 2:
+		fmt.Printf("\tmovzx %s, word ptr [%s+%d]\n", reg, ptr, off)
+	case 4:
+		fmt.Printf("\tmov %s, dword ptr [%s+%d]\n", "e"+reg[1:], ptr, off)
+	default:
+		fmt.Printf("\tmov %s, [%s+%d]\n", reg, ptr, off)
+	}
+}
+
+// genMapCall calls the map helper fn with the map m and a pointer to key,
+// whose value is evaluated on the stack.
+func genMapCall(fn string, m, key expression) {
+	genExpr(m)
+	genExpr(key)
+	size := valueSize(key.getType())
+	fmt.Printf("\tmov rdx, rsp\n")
+	fmt.Printf("\tmov rsi, [rsp+%d]\n", size)
+	fmt.Printf("\tlea rdi, [rip+%s]\n", mapType(m.getType()))
+	genCall("runtime." + fn)
+	fmt.Printf("\tadd rsp, %d\n", 8+size)
+	popped(8 + size)
+}
+
+// genMapIndex pushes the element of the key, or its zero value, and in the
+// comma-ok form whether the key is present.
+func genMapIndex(e *indexExpr) {
+	genMapCall("mapaccess", e.child, e.idx)
+	fmt.Printf("\tmov r8, rcx\n")
+	fmt.Printf("\tpush rax\n")
+	pushedWords(1, true)
+	load(e.ty)
+	if e.commaOk {
+		fmt.Printf("\tpush r8\n")
+		pushedWords(1, false)
+	}
+}
+
+// genStoreAddr pushes the address a value assigned to e is stored at. An
+// element of a map is created by the assignment.
+func genStoreAddr(e expression) {
+	if e, ok := e.(*indexExpr); ok && e.child.getType().kind == typeKindMap {
+		genMapCall("mapassign", e.child, e.idx)
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, true)
+		return
+	}
+	genAddr(e)
+}
+
+// genMapLit makes a map with room for the entries of the literal and
+// assigns them in order.
+func genMapLit(e *mapLit) {
+	fmt.Printf("\tlea rdi, [rip+%s]\n", mapType(e.ty))
+	fmt.Printf("\tmov rsi, %d\n", len(e.keys))
+	genCall("runtime.makemap")
+	fmt.Printf("\tmov [rbp%+d], rax\n", e.tmp.offset)
+	for i := range e.keys {
+		genExpr(e.vals[i])
+		genMapCall("mapassign", e.tmp, e.keys[i])
+		fmt.Printf("\tpush rax\n")
+		pushedWords(1, true)
+		store(e.ty.base)
+	}
+	genExpr(e.tmp)
+}
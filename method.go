@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+)
+
+// method is a method declared on a defined type. Its function takes the
+// receiver as the first parameter and is named "T.M".
+type method struct {
+	name    string
+	ptrRecv bool
+	fn      *function
+
+	// ptrWrapper is the function for M in the method set of *T when M has a
+	// value receiver.
+	ptrWrapper *function
+}
+
+// wrappers are the functions generated for method values and method
+// expressions.
+var wrappers []*function
+
+// declareMethod adds the method f to the method set of its receiver's base
+// type.
+func declareMethod(f *function) {
+	tokens = f.recvToks
+	expect("(")
+	if tokens[0].kind == tokenKindIdentifier && tokens[1].val != ")" {
+		advance()
+	}
+	ptr := consume("*")
+	tok := consumeToken(tokenKindIdentifier)
+	if tok == nil {
+		panic(fmt.Sprintf("Expected a receiver type: %+v", tokens[0]))
+	}
+	tn, ok := lookup(tok.val).(*typeName)
+	if !ok {
+		panic(fmt.Sprintf("%s is not a type", tok.val))
+	}
+	ty := tn.ty
+	if ty.name == "" || ty.kind == typeKindPtr {
+		panic(fmt.Sprintf("invalid receiver type %s", ty))
+	}
+	expect(")")
+
+	if findMethod(ty, f.name) != nil {
+		panic(fmt.Sprintf("method %s.%s already declared", ty, f.name))
+	}
+	for _, m := range ty.members {
+		if m.name == f.name {
+			panic(fmt.Sprintf("field and method with the same name %s", f.name))
+		}
+	}
+	ty.methods = append(ty.methods, &method{name: f.name, ptrRecv: ptr, fn: f})
+	f.name = ty.name + "." + f.name
+}
+
+// Receiver = "(" [ identifier ] Type ")" .
+func parseReceiver() *obj {
+	expect("(")
+	name := "_"
+	if tokens[0].kind == tokenKindIdentifier && tokens[1].val != ")" {
+		name = tokens[0].val
+		advance()
+	}
+	ty := parseType()
+	expect(")")
+
+	recv := createLocalVar(name)
+	recv.ty = ty
+	return recv
+}
+
+func findMethod(ty *typ, name string) *method {
+	for _, m := range ty.methods {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// receiverArg adjusts recv to the receiver type of m by taking its address
+// or dereferencing it.
+func receiverArg(recv expression, m *method) expression {
+	isPtr := recv.getType().kind == typeKindPtr
+	switch {
+	case m.ptrRecv && !isPtr:
+		if !addressable(recv) {
+			panic(fmt.Sprintf("cannot call pointer method %s on %s", m.name, recv.getType()))
+		}
+		return &addr{child: recv}
+	case !m.ptrRecv && isPtr:
+		return &deref{child: recv}
+	}
+	return recv
+}
+
+func addressable(e expression) bool {
+	switch e := e.(type) {
+	case *obj, *deref:
+		return true
+	case *memberRef:
+		return addressable(e.child)
+	case *indexExpr:
+		addType(e.child)
+		switch e.child.getType().kind {
+		case typeKindArray:
+			return addressable(e.child)
+		case typeKindMap:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// parseMethodExpr parses the method expression ty.M, which is a function
+// with the receiver as its first parameter.
+func parseMethodExpr(ty *typ) expression {
+	tok := consumeToken(tokenKindIdentifier)
+	if tok == nil {
+		panic(fmt.Sprintf("Expected an identifier: %+v", tokens[0]))
+	}
+
+	base := ty
+	if ty.kind == typeKindPtr && ty.name == "" {
+		base = ty.base
+	}
+	m := methodOf(base, tok.val)
+	if m == nil {
+		panic(fmt.Sprintf("%s.%s undefined (type %s has no method %s)", ty, tok.val, ty, tok.val))
+	}
+
+	if base == ty {
+		if m.ptrRecv {
+			panic(fmt.Sprintf("invalid method expression %s.%s (needs pointer receiver (*%s).%s)", ty, m.name, ty, m.name))
+		}
+		return &funcVal{fn: m.fn}
+	}
+	if m.ptrRecv {
+		return &funcVal{fn: m.fn}
+	}
+	return &funcVal{fn: ptrMethodWrapper(m)}
+}
+
+// ptrMethodWrapper returns the function that calls the value method m
+// through a pointer receiver.
+func ptrMethodWrapper(m *method) *function {
+	if m.ptrWrapper == nil {
+		p := &obj{name: "p", ty: pointerTo(m.fn.params[0].ty)}
+		m.ptrWrapper = newWrapper(m.fn.name+"..ptr", m.fn, []*obj{p}, nil, &deref{child: p})
+	}
+	return m.ptrWrapper
+}
+
+// methodValueWrapper returns the function a method value x.M calls. Its
+// context is the closure holding the receiver.
+func methodValueWrapper(fn *function) *function {
+	if fn.valueWrapper == nil {
+		closureTy := newStructType([]*member{
+			{name: "F", ty: newLiteralType("int")},
+			{name: "R", ty: fn.params[0].ty},
+		})
+		ctx := &obj{name: ".ctx", ty: pointerTo(closureTy)}
+		recv := &memberRef{child: &deref{child: ctx}, member: closureTy.members[1]}
+		fn.valueWrapper = newWrapper(fn.name+"..fm", fn, nil, ctx, recv)
+	}
+	return fn.valueWrapper
+}
+
+// newWrapper returns a function that takes the parameters extra followed by
+// the parameters of fn except the receiver, and returns the results of
+// calling fn with recv. ctx, if not nil, receives the closure the wrapper
+// is called through.
+func newWrapper(name string, fn *function, extra []*obj, ctx *obj, recv expression) *function {
+	return wrap(name, fn.params[1:], fn.results, extra, ctx, func(args []expression) expression {
+		return &funcCall{name: fn.name, target: fn, args: append([]expression{recv}, args...)}
+	})
+}
+
+// newIfaceWrapper is like newWrapper for the method im of the interface
+// value recv.
+func newIfaceWrapper(name string, recv expression, index int, im *imethod, extra []*obj) *function {
+	var params, results []*obj
+	for _, ty := range im.ty.params {
+		params = append(params, &obj{name: newUniqueName(), ty: ty})
+	}
+	for _, ty := range im.ty.results {
+		results = append(results, &obj{ty: ty})
+	}
+	return wrap(name, params, results, extra, nil, func(args []expression) expression {
+		return &ifaceCall{recv: recv, index: index, method: im, args: args}
+	})
+}
+
+// wrap returns a function with the parameters extra and params and the
+// results that returns the results of the call made with params.
+func wrap(name string, params, results, extra []*obj, ctx *obj, call func([]expression) expression) *function {
+	w := &function{name: name, params: extra, ctx: ctx}
+	var args []expression
+	for _, p := range params {
+		lv := &obj{name: p.name, ty: p.ty}
+		w.params = append(w.params, lv)
+		args = append(args, lv)
+	}
+	lhs := make([]expression, len(results))
+	for i, r := range results {
+		lv := &obj{ty: r.ty}
+		w.results = append(w.results, lv)
+		lhs[i] = lv
+	}
+	w.locals = append(append([]*obj{}, w.params...), w.results...)
+	if ctx != nil {
+		w.locals = append(w.locals, ctx)
+	}
+
+	if len(lhs) > 0 {
+		w.body = &returnStmt{child: &assignment{lhs: lhs, rhs: expressionList{call(args)}}}
+	} else {
+		w.body = &expressionStmt{child: call(args)}
+	}
+	addType(w.body)
+	w.assignLVarOffsets()
+
+	wrappers = append(wrappers, w)
+	return w
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+)
+
+// nilExpr is the predeclared nil. It is untyped until it is used as the
+// zero value of a pointer, slice, map, function or interface type.
+type nilExpr struct {
+	expression
+	ty *typ
+}
+
+func (e *nilExpr) getType() *typ   { return e.ty }
+func (e *nilExpr) setType(ty *typ) { e.ty = ty }
+
+// nilObj is the universe entry for nil.
+type nilObj struct{}
+
+func newNil() *nilExpr {
+	return &nilExpr{ty: newType(typeKindUntypedNil, 8)}
+}
+
+// canBeNil reports whether nil is a value of type ty.
+func canBeNil(ty *typ) bool {
+	switch ty.kind {
+	case typeKindPtr, typeKindSlice, typeKindMap, typeKindFunc, typeKindInterface, typeKindUntypedNil:
+		return true
+	}
+	return false
+}
+
+func isNil(e expression) bool {
+	_, ok := e.(*nilExpr)
+	return ok
+}
+
+// convertNil gives e the type ty if it is an untyped nil.
+func convertNil(e *nilExpr, ty *typ) {
+	if e.ty.kind != typeKindUntypedNil {
+		return
+	}
+	if !canBeNil(ty) {
+		panic(fmt.Sprintf("cannot use nil as %s value", ty))
+	}
+	e.ty = ty
+}
+
+// genNilCompare pushes whether the value x is nil, or for op != whether it
+// is not. The first word of a nil value is 0: the pointer, the data of a
+// slice or the type of an interface.
+func genNilCompare(op string, x expression) {
+	genExpr(x)
+	fmt.Printf("\tmov rax, [rsp]\n")
+	fmt.Printf("\tadd rsp, %d\n", valueSize(x.getType()))
+	popped(valueSize(x.getType()))
+	fmt.Printf("\ttest rax, rax\n")
+	if op == "==" {
+		fmt.Printf("\tsete al\n")
+	} else {
+		fmt.Printf("\tsetne al\n")
+	}
+	fmt.Printf("\tmovzb rax, al\n")
+	fmt.Printf("\tpush rax\n")
+	pushedWords(1, false)
+}
+
+// genNilCheck panics if the pointer on top of the stack is nil.
+func genNilCheck() {
+	labelCnt++
+	fmt.Printf("\tcmp qword ptr [rsp], 0\n")
+	fmt.Printf("\tjne .Lnonnil%d\n", labelCnt)
+	genCall("runtime.panicNil")
+	fmt.Printf(".Lnonnil%d:\n", labelCnt)
+}
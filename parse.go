@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"go/constant"
 )
 
 func advance() {
@@ -37,7 +38,9 @@ func consumeToken(tk tokenKind) *token {
 }
 
 type program struct {
-	funcs []*function
+	funcs   []*function
+	globals []*obj
+	init    *function
 }
 
 type function struct {
@@ -49,22 +52,56 @@ type function struct {
 	stackSize   int
 	paramsSize  int
 	resultsSize int
-}
 
+	scope    *scope
+	recvToks []*token
+	toks     []*token
+	bodyToks []*token
+
+	// ctx receives the closure a function value is called through
+	ctx *obj
+	// isValue is set if the function is used as a function value
+	isValue      bool
+	valueWrapper *function
+
+	// nlits counts the function literals in the function, for their names
+	nlits int
+
+	// leaks summarizes for escape analysis how each parameter flows out
+	// of the function, or is nil if it may leak anywhere
+	leaks []leak
+
+	// defers are the defer statements of the function. Open-coded ones
+	// set their bit in deferBits and the others push records on deferList.
+	defers    []*deferStmt
+	deferBits *obj
+	deferList *obj
+	// deferWrapper is set for the function of a defer record, which
+	// recover looks through
+	deferWrapper bool
+
+	// copyResults copies the results moved to the heap back to the frame
+	// of the caller
+	copyResults statement
+}
+
+// assignLVarOffsets lays out the frame. Parameters and results are pushed
+// by the caller as whole stack words above the return address, the first
+// parameter lowest; locals are below the frame pointer.
 func (f *function) assignLVarOffsets() {
-	offset := 8
+	offset := 16
 	for i := range f.params {
 		lv := f.params[i]
-		offset += lv.ty.size
-		lv.offset = alignTo(offset, 8)
+		lv.offset = offset
+		offset += alignTo(lv.ty.size, 8)
 	}
-	f.paramsSize = alignTo(offset-8, 8)
+	f.paramsSize = offset - 16
 	for i := len(f.results) - 1; i >= 0; i-- {
 		lv := f.results[i]
-		offset += lv.ty.size
-		lv.offset = alignTo(offset, 8)
+		lv.offset = offset
+		offset += alignTo(lv.ty.size, 8)
 	}
-	f.resultsSize = alignTo(offset-f.paramsSize-8, 8)
+	f.resultsSize = offset - 16 - f.paramsSize
 
 	offset = 0
 	for i := len(f.locals) - 1; i >= 0; i-- {
@@ -72,8 +109,12 @@ func (f *function) assignLVarOffsets() {
 			continue
 		}
 		lv := f.locals[i]
-		offset += lv.ty.size
-		f.locals[i].offset = -alignTo(offset, lv.ty.size)
+		size := lv.ty.size
+		if lv.onHeap {
+			size = 8
+		}
+		offset = alignTo(offset+size, 8)
+		lv.offset = -offset
 	}
 	f.stackSize = alignTo(offset, 16)
 }
@@ -141,6 +182,11 @@ type assignment struct {
 	ty  *typ
 	lhs []expression
 	rhs expressionList
+
+	// temps receive the values of a multi-valued right-hand side when
+	// some of them are converted, and convs are the values assigned
+	temps []*obj
+	convs expressionList
 }
 
 func (s *assignment) getType() *typ   { return s.ty }
@@ -162,36 +208,51 @@ type expressionList []expression
 
 func (es expressionList) convertSingleMultiValuedExpression() singleMultiValuedExpression {
 	if len(es) == 1 {
-		if e, ok := es[0].(singleMultiValuedExpression); ok {
+		if e, ok := es[0].(singleMultiValuedExpression); ok && e.multiValues() != nil {
 			return e
 		}
 	}
 	return nil
 }
 
-type intLit struct {
+type constExpr struct {
 	expression
 	ty  *typ
-	val int
+	val constant.Value
 }
 
-func (e *intLit) getType() *typ   { return e.ty }
-func (e *intLit) setType(ty *typ) { e.ty = ty }
+func (e *constExpr) getType() *typ   { return e.ty }
+func (e *constExpr) setType(ty *typ) { e.ty = ty }
 
+// compositeLit is a literal of an array, slice or struct type. Each
+// element is stored at its offset in the value, or in the array a slice
+// refers to, and the rest is zero. An array or struct literal is built in
+// tmp.
 type compositeLit struct {
 	expression
-	ty    *typ
-	elems []expression
+	ty      *typ
+	elems   []expression
+	offsets []int
+	length  int
+	tmp     *obj
 }
 
 func (e *compositeLit) getType() *typ   { return e.ty }
 func (e *compositeLit) setType(ty *typ) { e.ty = ty }
 
+// memberRef selects a field of a struct or, through a pointer, of the
+// struct it points to. The field named name is looked up when the type of
+// child is known.
 type memberRef struct {
 	expression
 	ty     *typ
+	name   string
 	member *member
 	child  expression
+
+	// implicit is set on the embedded fields a promoted field is selected
+	// through
+	implicit bool
 }
 
 func (e *memberRef) getType() *typ   { return e.ty }
@@ -208,11 +269,32 @@ type binary struct {
 func (e *binary) getType() *typ   { return e.ty }
 func (e *binary) setType(ty *typ) { e.ty = ty }
 
+// unary is the negation -x or the bitwise complement ^x of a variable
+// operand.
+type unary struct {
+	expression
+	ty    *typ
+	op    string
+	child expression
+}
+
+func (e *unary) getType() *typ   { return e.ty }
+func (e *unary) setType(ty *typ) { e.ty = ty }
+
 type obj struct {
 	expression
 	ty     *typ
 	name   string
 	offset int
+
+	// global variable
+	global   bool
+	decl     *varDecl
+	initData []byte
+
+	// onHeap is set for a local variable that lives in memory allocated
+	// by a heapVarStmt; its frame slot holds the address.
+	onHeap bool
 }
 
 func (e *obj) getType() *typ { return e.ty }
@@ -260,10 +342,201 @@ func (e *funcCall) multiValues() []expression {
 func (e *funcCall) getType() *typ   { return e.ty }
 func (e *funcCall) setType(ty *typ) { e.ty = ty }
 
+// funcVal is a function used as a value.
+type funcVal struct {
+	expression
+	ty *typ
+	fn *function
+}
+
+func (e *funcVal) getType() *typ   { return e.ty }
+func (e *funcVal) setType(ty *typ) { e.ty = ty }
+
+// methodVal is a method bound to its receiver, x.M. It is a function value
+// unless it is called right away.
+type methodVal struct {
+	expression
+	ty     *typ
+	recv   expression
+	method *method
+}
+
+func (e *methodVal) getType() *typ   { return e.ty }
+func (e *methodVal) setType(ty *typ) { e.ty = ty }
+
+// indirectCall is a call through a function value.
+type indirectCall struct {
+	expression
+	ty   *typ
+	fn   expression
+	args []expression
+}
+
+func (e *indirectCall) multiValues() []expression {
+	results := e.fn.getType().results
+	ret := make([]expression, len(results))
+	for i, ty := range results {
+		ret[i] = &obj{ty: ty}
+	}
+	return ret
+}
+
+func (e *indirectCall) getType() *typ   { return e.ty }
+func (e *indirectCall) setType(ty *typ) { e.ty = ty }
+
+// toIface converts a value to the interface type ty.
+type toIface struct {
+	expression
+	ty    *typ
+	child expression
+}
+
+func (e *toIface) getType() *typ   { return e.ty }
+func (e *toIface) setType(ty *typ) { e.ty = ty }
+
+// typeAssert is x.(T). In the comma-ok form it also yields whether the
+// assertion holds instead of panicking.
+type typeAssert struct {
+	expression
+	ty      *typ
+	child   expression
+	commaOk bool
+}
+
+func (e *typeAssert) multiValues() []expression {
+	if !e.commaOk {
+		return nil
+	}
+	return []expression{&obj{ty: e.ty}, &obj{ty: newLiteralType("bool")}}
+}
+
+func (e *typeAssert) getType() *typ   { return e.ty }
+func (e *typeAssert) setType(ty *typ) { e.ty = ty }
+
+// indexExpr is a[i] on an array, a pointer to an array, a slice or a map.
+// A map index in the comma-ok form also yields whether the key is present.
+type indexExpr struct {
+	expression
+	ty      *typ
+	child   expression
+	idx     expression
+	commaOk bool
+}
+
+func (e *indexExpr) multiValues() []expression {
+	if !e.commaOk {
+		return nil
+	}
+	return []expression{&obj{ty: e.ty}, &obj{ty: newLiteralType("bool")}}
+}
+
+func (e *indexExpr) getType() *typ   { return e.ty }
+func (e *indexExpr) setType(ty *typ) { e.ty = ty }
+
+// sliceExpr is a[lo:hi:max]. Missing bounds are nil.
+type sliceExpr struct {
+	expression
+	ty    *typ
+	child expression
+	lo    expression
+	hi    expression
+	max   expression
+}
+
+func (e *sliceExpr) getType() *typ   { return e.ty }
+func (e *sliceExpr) setType(ty *typ) { e.ty = ty }
+
+// builtinCall is a call of a predeclared function.
+type builtinCall struct {
+	expression
+	ty   *typ
+	name string
+	args []expression
+
+	// typeArg is the type argument of make
+	typeArg *typ
+	// spread is set for append(s, t...)
+	spread bool
+	// tmp holds the result of append while the elements are stored
+	tmp *obj
+}
+
+func (e *builtinCall) getType() *typ   { return e.ty }
+func (e *builtinCall) setType(ty *typ) { e.ty = ty }
+
+// mapLit is a map composite literal. The map is built in tmp.
+type mapLit struct {
+	expression
+	ty   *typ
+	keys []expression
+	vals []expression
+	tmp  *obj
+}
+
+func (e *mapLit) getType() *typ   { return e.ty }
+func (e *mapLit) setType(ty *typ) { e.ty = ty }
+
+// typeSwitchGuard is x.(type), which may only appear in a type switch.
+type typeSwitchGuard struct {
+	expression
+	ty    *typ
+	child expression
+}
+
+func (e *typeSwitchGuard) getType() *typ   { return e.ty }
+func (e *typeSwitchGuard) setType(ty *typ) { e.ty = ty }
+
+// typeCheck reports whether the dynamic type of an interface value is one
+// of types. A nil type stands for the nil interface.
+type typeCheck struct {
+	expression
+	ty    *typ
+	child expression
+	types []*typ
+}
+
+func (e *typeCheck) getType() *typ   { return e.ty }
+func (e *typeCheck) setType(ty *typ) { e.ty = ty }
+
+// ifaceMethod is a method selected from an interface value.
+type ifaceMethod struct {
+	expression
+	ty     *typ
+	recv   expression
+	index  int
+	method *imethod
+}
+
+func (e *ifaceMethod) getType() *typ   { return e.ty }
+func (e *ifaceMethod) setType(ty *typ) { e.ty = ty }
+
+// ifaceCall is a call of a method through the itab of an interface value.
+type ifaceCall struct {
+	expression
+	ty     *typ
+	recv   expression
+	index  int
+	method *imethod
+	args   []expression
+}
+
+func (e *ifaceCall) multiValues() []expression {
+	ret := make([]expression, len(e.method.ty.results))
+	for i, ty := range e.method.ty.results {
+		ret[i] = &obj{ty: ty}
+	}
+	return ret
+}
+
+func (e *ifaceCall) getType() *typ   { return e.ty }
+func (e *ifaceCall) setType(ty *typ) { e.ty = ty }
+
 // temporary sets
 var locals []*obj
 var results []*obj
-var callees []*funcCall
+var funcs = map[string]*function{}
+var constDecls []*constDecl
+var typeDecls []*typeDecl
 var uniqueID = 0
 
 func newUniqueName() string {
@@ -272,48 +545,179 @@ func newUniqueName() string {
 	return s
 }
 
+// scope is a block in which identifiers are declared. An identifier
+// denotes a variable (*obj), a constant (*constObj), a type (*typeName), a
+// builtin function (*builtin) or an imported package (*pkgName).
+type scope struct {
+	outer *scope
+	syms  map[string]interface{}
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, syms: map[string]interface{}{}}
+}
+
+func (sc *scope) declare(name string, sym interface{}) {
+	if name == "_" {
+		return
+	}
+	if _, ok := sc.syms[name]; ok {
+		panic(fmt.Sprintf("%s redeclared in this block", name))
+	}
+	sc.syms[name] = sym
+}
+
+var universe = &scope{
+	syms: map[string]interface{}{
+		"true":  &constObj{ty: newType(typeKindUntypedBool, 0), val: constant.MakeBool(true)},
+		"false": &constObj{ty: newType(typeKindUntypedBool, 0), val: constant.MakeBool(false)},
+		"nil":   &nilObj{},
+		"any":   &typeName{ty: newType(typeKindInterface, 16)},
+
+		"append":  &builtin{name: "append"},
+		"cap":     &builtin{name: "cap"},
+		"copy":    &builtin{name: "copy"},
+		"delete":  &builtin{name: "delete"},
+		"len":     &builtin{name: "len"},
+		"make":    &builtin{name: "make"},
+		"new":     &builtin{name: "new"},
+		"panic":   &builtin{name: "panic"},
+		"recover": &builtin{name: "recover"},
+	},
+}
+var pkgScope = newScope(universe)
+var currentScope = pkgScope
+
+func enterScope() {
+	currentScope = newScope(currentScope)
+}
+
+func leaveScope() {
+	currentScope = currentScope.outer
+}
+
 func createLocalVar(name string) *obj {
 	lv := &obj{
 		name: name,
 	}
 	locals = append(locals, lv)
+	currentScope.declare(name, lv)
 	return lv
 }
 
-func findLocalVar(name string) *obj {
-
-	for i := range locals {
-		lv := locals[i]
-		if lv.name == name {
-			return lv
+// lookup finds the innermost declaration of name. Package-level variables
+// and types are parsed the first time they are looked up.
+func lookup(name string) interface{} {
+	for sc := currentScope; sc != nil; sc = sc.outer {
+		sym, ok := sc.syms[name]
+		if !ok {
+			continue
+		}
+		switch sym := sym.(type) {
+		case *obj:
+			if sym.decl != nil {
+				sym.decl.resolve()
+			}
+		case *typeName:
+			if sym.decl != nil {
+				sym.decl.resolve()
+			}
 		}
+		return sym
 	}
 	return nil
 }
 
-// TopLevelDecl = FunctionDecl .
+// SourceFile    = PackageClause ";" { ImportDecl ";" } { TopLevelDecl ";" } .
+// PackageClause = "package" PackageName .
+// TopLevelDecl  = Declaration | FunctionDecl .
+//
+// Package-level declarations may refer to each other regardless of their
+// order in the source, so all the names are collected first. Types are
+// parsed before function signatures, which are parsed before constants,
+// variable initializers and function bodies.
 func parse() *program {
-	mFuncs := make(map[string]*function)
+	expect("package")
+	if consumeToken(tokenKindIdentifier) == nil {
+		panic(fmt.Sprintf("Expected a package name: %+v", tokens[0]))
+	}
+	expect(";")
+	for consume("import") {
+		parseImportDecl()
+		expect(";")
+	}
+
 	ret := &program{
 		funcs: make([]*function, 0),
 	}
 	for len(tokens) > 0 {
-		expect("func")
-		f := parseFunction()
-		ret.funcs = append(ret.funcs, f)
-		mFuncs[f.name] = f
+		switch {
+		case consume("func"):
+			ret.funcs = append(ret.funcs, declareFunction(skipSpec()))
+		case consume("var"):
+			ret.globals = append(ret.globals, parseGlobalVarDecl()...)
+		case consume("const"):
+			constDecls = append(constDecls, parseConstDecl()...)
+		case consume("type"):
+			typeDecls = append(typeDecls, parseTypeDecl()...)
+		default:
+			panic(fmt.Sprintf("Unexpected token: %+v. want: func, var, const or type", tokens[0]))
+		}
 		expect(";")
 	}
 
-	for _, c := range callees {
-		f := mFuncs[c.name]
-		c.target = f
+	for _, d := range typeDecls {
+		d.resolve()
 	}
+	for _, f := range ret.funcs {
+		if f.recvToks != nil {
+			declareMethod(f)
+		}
+	}
+	for _, f := range ret.funcs {
+		parseFunction(f)
+	}
+	for _, d := range constDecls {
+		d.resolve()
+	}
+
+	if _, ok := funcs["main"]; !ok {
+		panic("function main is undeclared in the main package")
+	}
+
+	locals = []*obj{}
+	for _, d := range varDecls {
+		d.resolve()
+	}
+	initLocals := locals
 
 	for _, f := range ret.funcs {
+		parseFunctionBody(f)
 		addType(f.body)
+	}
+	ret.funcs = append(ret.funcs, funcLits...)
+	for _, f := range ret.funcs {
+		ret.funcs = append(ret.funcs, lowerDefers(f)...)
+	}
+	for _, f := range ret.funcs {
+		f.locals = append(f.locals, assignTemps(f.body)...)
+	}
+	escapeAnalysis(ret.funcs)
+	for _, f := range ret.funcs {
 		f.assignLVarOffsets()
 	}
+	buildItabs()
+	ret.funcs = append(ret.funcs, wrappers...)
+
+	if stmts := sortInitializers(varDecls); len(stmts) > 0 {
+		body := &blockStmt{stmts: stmts}
+		ret.init = &function{
+			name:   "init",
+			body:   body,
+			locals: append(initLocals, assignTemps(body)...),
+		}
+		ret.init.assignLVarOffsets()
+	}
 
 	return ret
 }
@@ -338,16 +742,22 @@ func parseVarDecl() statement {
 // VarSpec = IdentifierList ( Type [ "=" ExpressionList ] | "=" ExpressionList ) .
 func parseVarSpec() statement {
 	ids := parseIdentifierList()
+	var ty *typ
+	if !peek("=") {
+		ty = parseType()
+	}
+
 	if consume("=") {
+		rhs := parseExpressionList()
 		lhs := make([]expression, len(ids))
 		for i, id := range ids {
-			lhs[i] = createLocalVar(id)
+			lv := createLocalVar(id)
+			lv.ty = ty
+			lhs[i] = lv
 		}
-		rhs := parseExpressionList()
-		return &assignment{lhs: lhs, rhs: rhs}
+		return newDeclAssignment(lhs, rhs)
 	}
 
-	ty := parseType()
 	stmts := make([]statement, len(ids))
 	for i, id := range ids {
 		lv := createLocalVar(id)
@@ -357,88 +767,127 @@ func parseVarSpec() statement {
 	return &blockStmt{stmts: stmts}
 }
 
+// assignTemps returns the temporaries of the assignments in n.
+func assignTemps(n statement) []*obj {
+	var ret []*obj
+	inspect(n, func(n interface{}) {
+		if a, ok := n.(*assignment); ok {
+			ret = append(ret, a.temps...)
+		}
+	})
+	return ret
+}
+
 func initializer(expr expression) statement {
-	switch ty := expr.getType(); ty.kind {
-	case typeKindStruct:
-		stmts := make([]statement, len(ty.members))
-		for i, mem := range ty.members {
-			lhs := &memberRef{child: expr, member: mem, ty: mem.ty}
-			stmts[i] = initializer(lhs)
-		}
-		return &blockStmt{
-			stmts: stmts,
-		}
-	case typeKindArray:
-		lhs := make([]expression, ty.length)
-		rhs := make([]expression, ty.length)
-		for i := 0; i < ty.length; i++ {
-			lhs[i] = &deref{child: addBinary(expr, &intLit{val: i})}
-			rhs[i] = zeroValueMap[ty.base.kind]
-		}
-		return &assignment{lhs: lhs, rhs: rhs}
-	default:
-		return &assignment{lhs: expressionList{expr}, rhs: expressionList{zeroValueMap[ty.kind]}}
-	}
+	return &assignment{lhs: expressionList{expr}, rhs: expressionList{zeroValue(expr.getType())}}
 }
 
 // FunctionDecl = "func" FunctionName Signature [ FunctionBody ] .
+// MethodDecl   = "func" Receiver MethodName Signature [ FunctionBody ] .
 // FunctionName = identifier .
 // FunctionBody = Block .
-func parseFunction() *function {
+func declareFunction(toks []*token) *function {
+	var recvToks []*token
+	if len(toks) > 0 && toks[0].val == "(" {
+		for i, tok := range toks {
+			if tok.val == ")" {
+				recvToks, toks = toks[:i+1], toks[i+1:]
+				break
+			}
+		}
+	}
+	if len(toks) == 0 || toks[0].kind != tokenKindIdentifier {
+		panic("must be an identifier")
+	}
+	ret := &function{name: toks[0].val, recvToks: recvToks}
+	if recvToks == nil {
+		if _, ok := funcs[ret.name]; ok {
+			panic(fmt.Sprintf("%s redeclared in this block", ret.name))
+		}
+		funcs[ret.name] = ret
+	}
+
+	// the body is the block that ends the declaration
+	depth := 0
+	for i := len(toks) - 1; i > 0; i-- {
+		switch toks[i].val {
+		case "}":
+			depth++
+		case "{":
+			depth--
+		}
+		if depth == 0 {
+			ret.toks, ret.bodyToks = toks[1:i], toks[i:]
+			return ret
+		}
+	}
+	panic(fmt.Sprintf("missing function body: %s", ret.name))
+}
 
+func parseFunction(f *function) {
 	locals = []*obj{}
 	results = []*obj{}
 
-	tok := consumeToken(tokenKindIdentifier)
-	if tok == nil {
-		panic("must be an identifier")
+	enterScope()
+	var recv *obj
+	if f.recvToks != nil {
+		tokens = f.recvToks
+		recv = parseReceiver()
 	}
+	tokens = f.toks
+	expect("(")
+	// Signature = Parameters [ Result ] .
+	f.params, f.results = parseSignature()
+	if recv != nil {
+		f.params = append([]*obj{recv}, f.params...)
+	}
+	f.scope = currentScope
+	leaveScope()
+	f.locals = locals
+}
 
-	ret := &function{name: tok.val}
+func parseFunctionBody(f *function) {
+	locals = f.locals
+	results = f.results
+	currentScope = f.scope
+	tokens = f.bodyToks
 
-	expect("(")
-	// Signature = Parameters [ Type ] .
-	ret.params, ret.results = parseSignature()
-	expect("{")
-	ret.body = parseBlockStmt()
-	ret.locals = locals
+	curFunc = f
 
-	return ret
+	expect("{")
+	f.body = parseBlockStmt()
+	f.locals = locals
+	currentScope = pkgScope
+	curFunc = nil
 }
 
+// Result = Parameters | Type .
 func parseSignature() ([]*obj, []*obj) {
 	params := parseParameters()
 
 	if consume("(") {
-		// multiple results
-		for i := 0; !consume(")"); i++ {
-			if i > 0 {
-				expect(",")
-			}
-			// TODO: identifier
-			tok := consumeToken(tokenKindType)
-			if tok == nil {
-				panic(fmt.Sprintf("Expected a type: %+v", tokens[0]))
-			}
-			lv := createLocalVar(tok.val)
-			lv.ty = newLiteralType(tok.val)
-			results = append(results, lv)
+		// results in parentheses, which may have names
+		if !consume(")") {
+			results = append(results, parseParameterList()...)
+			expect(")")
 		}
-
 		return params, results
 	}
 
-	if tok := consumeToken(tokenKindType); tok != nil {
-		// TODO: identifier
-		lv := createLocalVar(tok.val)
-		lv.ty = newLiteralType(tok.val)
-		results = append(results, lv)
-		return params, results
+	if startsType() {
+		results = append(results, newUnnamedVar(parseType()))
 	}
 
 	return params, results
 }
 
+// newUnnamedVar creates the variable of an unnamed parameter or result.
+func newUnnamedVar(ty *typ) *obj {
+	lv := &obj{ty: ty}
+	locals = append(locals, lv)
+	return lv
+}
+
 // Parameters = "(" [ ParameterList ] ")" .
 func parseParameters() []*obj {
 
@@ -455,14 +904,55 @@ func parseParameters() []*obj {
 }
 
 // ParameterList  = ParameterDecl { "," ParameterDecl } .
+//
+// Either all the parameters in a list have names or none of them has.
 func parseParameterList() []*obj {
+	named := namedParameters()
 	var ret []*obj
 	for i := 0; i == 0 || consume(","); i++ {
+		if peek(")") {
+			break
+		}
+		if !named {
+			ret = append(ret, newUnnamedVar(parseType()))
+			continue
+		}
 		ret = append(ret, parseParameterDecl()...)
 	}
 	return ret
 }
 
+// namedParameters reports whether the parameter list that follows has
+// names, which is the case if an identifier starting one of its elements
+// is followed by a type.
+func namedParameters() bool {
+	depth := 0
+	start := true
+	for i, tok := range tokens {
+		switch tok.val {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			if depth == 0 {
+				return false
+			}
+			depth--
+		case ",":
+			if depth == 0 {
+				start = true
+				continue
+			}
+		}
+		if start && depth == 0 && tok.kind == tokenKindIdentifier {
+			if next := tokens[i+1].val; next != "," && next != ")" {
+				return true
+			}
+		}
+		start = false
+	}
+	return false
+}
+
 // ParameterDecl  = [ IdentifierList ] Type .
 func parseParameterDecl() []*obj {
 	ids := parseIdentifierList()
@@ -475,28 +965,75 @@ func parseParameterDecl() []*obj {
 	return ret
 }
 
+// startsType reports whether the next token can start a type.
+func startsType() bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	switch tokens[0].kind {
+	case tokenKindType, tokenKindIdentifier:
+		return true
+	}
+	return peek("*") || peek("[") || peek("(") || peek("map") || peek("func")
+}
+
+// Type     = TypeName | TypeLit | "(" Type ")" .
+// TypeName = identifier .
+// TypeLit  = ArrayType | StructType | PointerType | FunctionType | InterfaceType | SliceType | MapType .
 func parseType() *typ {
-	tok := consumeToken(tokenKindType)
-	if tok == nil {
-		tok = consumeToken(tokenKindOperator)
+	if consume("(") {
+		ty := parseType()
+		expect(")")
+		return ty
 	}
-	if tok == nil {
-		panic(fmt.Sprintf("Expected a type: %+v", tokens[0]))
+
+	if consume("*") {
+		// PointerType = "*" BaseType .
+		return pointerTo(parseType())
 	}
 
-	if tok.val == "struct" {
+	if consume("struct") {
 		return parseStructDecl()
 	}
 
-	if tok.val == "[" {
+	if consume("interface") {
+		return parseInterfaceType()
+	}
+
+	if consume("map") {
+		return parseMapType()
+	}
+
+	if consume("func") {
+		// FunctionType = "func" Signature .
+		return parseFuncSignature()
+	}
+
+	if consume("[") {
+		if consume("]") {
+			// SliceType = "[" "]" ElementType .
+			return sliceOf(parseType())
+		}
 		return parseArrayType()
 	}
 
-	return newLiteralType(tok.val)
+	if tok := consumeToken(tokenKindType); tok != nil {
+		return newLiteralType(tok.val)
+	}
+
+	if tok := consumeToken(tokenKindIdentifier); tok != nil {
+		tn, ok := lookup(tok.val).(*typeName)
+		if !ok {
+			panic(fmt.Sprintf("%s is not a type", tok.val))
+		}
+		return tn.ty
+	}
+
+	panic(fmt.Sprintf("Expected a type: %+v", tokens[0]))
 }
 
-// Statement = Declaration | ReturnStmt | SimpleStmt .
-// Declaration = VarDecl .
+// Statement = Declaration | ReturnStmt | DeferStmt | SimpleStmt | SwitchStmt .
+// Declaration = ConstDecl | TypeDecl | VarDecl .
 func parseStatement() statement {
 
 	// varDeclaration
@@ -504,10 +1041,29 @@ func parseStatement() statement {
 		return parseVarDecl()
 	}
 
+	// constDeclaration
+	if consume("const") {
+		for _, d := range parseConstDecl() {
+			d.resolve()
+		}
+		return &blockStmt{}
+	}
+
+	// typeDeclaration
+	if consume("type") {
+		for _, d := range parseTypeDecl() {
+			d.resolve()
+		}
+		return &blockStmt{}
+	}
+
 	// return
 	if consume("return") {
 		// ReturnStmt = "return" [ ExpressionList ] .
-		if peek("}") {
+		if peek("}") || peek(";") {
+			if len(results) > 0 && results[0].name == "" {
+				panic("not enough return values")
+			}
 			return &returnStmt{}
 		}
 		lhs := make([]expression, len(results))
@@ -518,6 +1074,16 @@ func parseStatement() statement {
 		return &returnStmt{child: &assignment{lhs: lhs, rhs: rhs}}
 	}
 
+	// defer
+	if consume("defer") {
+		return parseDeferStmt()
+	}
+
+	// switch
+	if consume("switch") {
+		return parseSwitchStmt()
+	}
+
 	// block
 	if consume("{") {
 		return parseBlockStmt()
@@ -538,8 +1104,14 @@ func parseStatement() statement {
 
 // Block = "{" StatementList "}" .
 func parseBlockStmt() statement {
+	enterScope()
+	defer leaveScope()
+
 	var stmts []statement
 	for !consume("}") {
+		if consume(";") {
+			continue
+		}
 		stmts = append(stmts, parseStatement())
 	}
 	return &blockStmt{stmts: stmts}
@@ -547,6 +1119,9 @@ func parseBlockStmt() statement {
 
 // IfStmt = "if" [ SimpleStmt ";" ] Expression Block [ "else" ( IfStmt | Block ) ] .
 func parseIfStmt() statement {
+	enterScope()
+	defer leaveScope()
+
 	var cond expression
 	var init statement
 	tmp := parseSimpleStmt()
@@ -580,15 +1155,167 @@ func parseIfStmt() statement {
 	return ret
 }
 
-// ForStmt    = "for" [ Condition | ForClause ] Block .
+// SwitchStmt      = TypeSwitchStmt .
+// TypeSwitchStmt  = "switch" [ SimpleStmt ";" ] TypeSwitchGuard "{" { TypeCaseClause } "}" .
+// TypeSwitchGuard = [ identifier ":=" ] PrimaryExpr "." "(" "type" ")" .
+// TypeCaseClause  = TypeSwitchCase ":" StatementList .
+// TypeSwitchCase  = "case" TypeList | "default" .
+// TypeList        = Type { "," Type } .
+//
+// A type switch is lowered to a chain of if statements testing the dynamic
+// type of the operand, which is evaluated once.
+func parseSwitchStmt() statement {
+	enterScope()
+	defer leaveScope()
+
+	ret := &blockStmt{}
+	if hasSwitchInit() {
+		ret.stmts = append(ret.stmts, parseSimpleStmt())
+		expect(";")
+	}
+
+	name := ""
+	if tokens[0].kind == tokenKindIdentifier && tokens[1].val == ":=" {
+		name = tokens[0].val
+		advance()
+		advance()
+	}
+	guard, ok := parseExpression().(*typeSwitchGuard)
+	if !ok {
+		panic("expected a type switch guard")
+	}
+	ity := guard.child.getType()
+	x := createLocalVar(newUniqueName())
+	ret.stmts = append(ret.stmts, newDeclAssignment([]expression{x}, []expression{guard.child}))
+
+	type clause struct {
+		types []*typ
+		body  statement
+	}
+	var clauses []*clause
+	var dflt statement
+	var seen []*typ
+	seenNil := false
+
+	expect("{")
+	for !consume("}") {
+		if consume(";") {
+			continue
+		}
+		enterScope()
+		isDefault := consume("default")
+		var types []*typ
+		if !isDefault {
+			expect("case")
+			for i := 0; i == 0 || consume(","); i++ {
+				if consume("nil") {
+					if seenNil {
+						panic("multiple nil cases in type switch")
+					}
+					seenNil = true
+					types = append(types, nil)
+					continue
+				}
+				ty := parseType()
+				for _, t := range seen {
+					if identical(t, ty) {
+						panic(fmt.Sprintf("duplicate case %s in type switch", ty))
+					}
+				}
+				seen = append(seen, ty)
+				if ty.kind == typeKindInterface {
+					if !isEmptyInterface(ty) {
+						addAssertIface(ty)
+					}
+				} else if m := missingMethod(ty, ity); m != "" {
+					panic(fmt.Sprintf("impossible type switch case: %s cannot have dynamic type %s (missing method %s)", ity, ty, m))
+				}
+				types = append(types, ty)
+			}
+		}
+		expect(":")
+
+		body := &blockStmt{}
+		if name != "" {
+			// the variable has the type of the case if there is only one
+			v := createLocalVar(name)
+			var val expression = x
+			if len(types) == 1 && types[0] != nil {
+				val = &typeAssert{child: x, ty: types[0]}
+			}
+			body.stmts = append(body.stmts, newDeclAssignment([]expression{v}, []expression{val}))
+		}
+		for !peek("case") && !peek("default") && !peek("}") {
+			if consume(";") {
+				continue
+			}
+			body.stmts = append(body.stmts, parseStatement())
+		}
+		leaveScope()
+
+		if isDefault {
+			if dflt != nil {
+				panic("multiple defaults in switch")
+			}
+			dflt = body
+			continue
+		}
+		clauses = append(clauses, &clause{types: types, body: body})
+	}
+
+	chain := dflt
+	for i := len(clauses) - 1; i >= 0; i-- {
+		c := clauses[i]
+		chain = &ifStmt{
+			cond: &typeCheck{child: x, types: c.types, ty: newLiteralType("bool")},
+			then: c.body,
+			els:  chain,
+		}
+	}
+	if chain != nil {
+		ret.stmts = append(ret.stmts, chain)
+	}
+	return ret
+}
+
+// hasSwitchInit reports whether the switch header that follows starts
+// with a statement.
+func hasSwitchInit() bool {
+	depth := 0
+	for _, tok := range tokens {
+		switch tok.val {
+		case "(", "[":
+			depth++
+		case ")", "]":
+			depth--
+		case ";":
+			if depth == 0 {
+				return true
+			}
+		case "{":
+			if depth == 0 {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// ForStmt    = "for" [ Condition | ForClause | RangeClause ] Block .
 // Condition  = Expression .
 // ForClause  = [ InitStmt ] ";" [ Condition ] ";" [ PostStmt ] .
 // InitStmt   = SimpleStmt .
 // PostStmt   = SimpleStmt .
 func parseForStmt() statement {
+	enterScope()
+	defer leaveScope()
+
 	if consume("{") {
 		return &forStmt{body: parseBlockStmt()}
 	}
+	if isRangeClause() {
+		return parseRangeClause()
+	}
 
 	var cond expression
 	var init statement
@@ -628,60 +1355,67 @@ func parseForStmt() statement {
 }
 
 func parseSimpleStmt() statement {
+	if isShortVarDecl() {
+		return parseShortVarDecl()
+	}
+
 	expr := parseExpressionList()
 
 	if consume("=") {
 		// Assignment
-		for i, l := range expr {
-			switch l := l.(type) {
-			case *obj:
-				lv := findLocalVar(l.name)
-				if lv == nil {
-					panic(fmt.Sprintf("a local variable not declared: %s", l.name))
-				}
-				expr[i] = lv
-			}
-		}
 		return &assignment{lhs: expr, rhs: parseExpressionList()}
 	}
 
-	if consume(":=") {
-		// ShortVarDecl
-		rhs := parseExpressionList()
-		ret := &assignment{lhs: expr, rhs: rhs}
-		if se := rhs.convertSingleMultiValuedExpression(); se == nil {
-			addType(ret)
-			ret = &assignment{lhs: expandExpressionList(expr), rhs: expandExpressionList(rhs)}
-		}
-		return ret
-	}
-
 	return &expressionStmt{child: expr[0]}
 }
 
-func expandExpressionList(exprs []expression) []expression {
-	if len(exprs) == 0 {
-		return nil
+func isShortVarDecl() bool {
+	for i := 0; i+1 < len(tokens); i += 2 {
+		if tokens[i].kind != tokenKindIdentifier {
+			return false
+		}
+		if tokens[i+1].val == ":=" {
+			return true
+		}
+		if tokens[i+1].val != "," {
+			return false
+		}
 	}
+	return false
+}
 
-	expr, remain := exprs[0], exprs[1:]
+// ShortVarDecl = IdentifierList ":=" ExpressionList .
+func parseShortVarDecl() statement {
+	ids := parseIdentifierList()
+	expect(":=")
+	rhs := parseExpressionList()
 
-	expanded := []expression{expr}
-	ty := expr.getType()
-	if c, ok := expr.(*compositeLit); ok {
-		expanded = c.elems
-	} else if ty.kind == typeKindArray {
-		expanded = make([]expression, ty.length)
-		for i := 0; i < ty.length; i++ {
-			expanded[i] = &deref{child: addBinary(expr, &intLit{val: i})}
+	lhs := make([]expression, len(ids))
+	declared := false
+	for i, id := range ids {
+		if sym, ok := currentScope.syms[id]; ok {
+			lv, ok := sym.(*obj)
+			if !ok {
+				panic(fmt.Sprintf("cannot assign to %s", id))
+			}
+			lhs[i] = lv
+			continue
 		}
+		lhs[i] = createLocalVar(id)
+		declared = true
 	}
-
-	expandedRemain := expandExpressionList(remain)
-	if expandedRemain != nil {
-		return append(expanded, expandedRemain...)
+	if !declared {
+		panic("no new variables on left side of :=")
 	}
-	return expanded
+
+	return newDeclAssignment(lhs, rhs)
+}
+
+// newDeclAssignment types the declared variables from their initial values.
+func newDeclAssignment(lhs []expression, rhs expressionList) statement {
+	ret := &assignment{lhs: lhs, rhs: rhs}
+	addType(ret)
+	return ret
 }
 
 // ExpressionList = Expression { "," Expression } .
@@ -716,76 +1450,129 @@ func parseIdentifierList() []string {
 }
 
 func parseExpression() expression {
-	return parseRel()
+	return parseLogOr()
+}
+
+// newBinary creates a binary expression, which is evaluated at compile time
+// if both operands are constants.
+func newBinary(op string, lhs, rhs expression) expression {
+	if x, ok := lhs.(*constExpr); ok {
+		if y, ok := rhs.(*constExpr); ok {
+			return foldBinary(op, x, y)
+		}
+	}
+	return &binary{op: op, lhs: lhs, rhs: rhs}
+}
+
+// logor = logand ("||" logand)*
+func parseLogOr() expression {
+	ret := parseLogAnd()
+	for consume("||") {
+		ret = newBinary("||", ret, parseLogAnd())
+	}
+	return ret
 }
 
-// rel = add ((">=" | "<=" | "==" | "!=") add)*
+// logand = rel ("&&" rel)*
+func parseLogAnd() expression {
+	ret := parseRel()
+	for consume("&&") {
+		ret = newBinary("&&", ret, parseRel())
+	}
+	return ret
+}
+
+// rel = add (("<" | ">" | ">=" | "<=" | "==" | "!=") add)*
 func parseRel() expression {
 	ret := parseAdd()
 	for {
 		switch {
 
 		case consume("<"):
-			ret = &binary{op: "<", lhs: ret, rhs: parseAdd()}
+			ret = newBinary("<", ret, parseAdd())
 		case consume(">"):
-			ret = &binary{op: "<", lhs: parseAdd(), rhs: ret}
+			ret = newBinary("<", parseAdd(), ret)
 		case consume("<="):
-			ret = &binary{op: "<=", lhs: ret, rhs: parseAdd()}
+			ret = newBinary("<=", ret, parseAdd())
 		case consume(">="):
-			ret = &binary{op: "<=", lhs: parseAdd(), rhs: ret}
+			ret = newBinary("<=", parseAdd(), ret)
 		case consume("=="):
-			ret = &binary{op: "==", lhs: ret, rhs: parseAdd()}
+			ret = newBinary("==", ret, parseAdd())
 		case consume("!="):
-			ret = &binary{op: "!=", lhs: ret, rhs: parseAdd()}
+			ret = newBinary("!=", ret, parseAdd())
 		default:
 			return ret
 		}
 	}
 }
 
-// add = mul (("*" | "/") mul)*
+// add = mul (("+" | "-" | "|" | "^") mul)*
 func parseAdd() expression {
 	ret := parseMul()
 	for {
 		switch {
 		case consume("+"):
-			ret = &binary{op: "+", lhs: ret, rhs: parseMul()}
+			ret = newBinary("+", ret, parseMul())
 		case consume("-"):
-			ret = &binary{op: "-", lhs: ret, rhs: parseMul()}
+			ret = newBinary("-", ret, parseMul())
+		case consume("|"):
+			ret = newBinary("|", ret, parseMul())
+		case consume("^"):
+			ret = newBinary("^", ret, parseMul())
 		default:
 			return ret
 		}
 	}
 }
 
-// mul = unary (("*" | "/") unary)*
+// mul = unary (("*" | "/" | "%" | "<<" | ">>" | "&" | "&^") unary)*
 func parseMul() expression {
 	ret := parseUnary()
 	for {
 		switch {
 		case consume("*"):
-			ret = &binary{op: "*", lhs: ret, rhs: parseUnary()}
+			ret = newBinary("*", ret, parseUnary())
 		case consume("/"):
-			ret = &binary{op: "/", lhs: ret, rhs: parseUnary()}
+			ret = newBinary("/", ret, parseUnary())
+		case consume("%"):
+			ret = newBinary("%", ret, parseUnary())
+		case consume("<<"):
+			ret = newBinary("<<", ret, parseUnary())
+		case consume(">>"):
+			ret = newBinary(">>", ret, parseUnary())
+		case consume("&"):
+			ret = newBinary("&", ret, parseUnary())
+		case consume("&^"):
+			ret = newBinary("&^", ret, parseUnary())
 		default:
 			return ret
 		}
 	}
 }
 
-// unary = ("+" | "-" | "*" | "&")? unary | primary
+// unary = ("+" | "-" | "!" | "^" | "*" | "&")? unary | primary
 func parseUnary() expression {
 	switch {
 	case consume("+"):
 		return parseUnary()
 	case consume("-"):
-		return &binary{op: "-", lhs: &intLit{val: 0}, rhs: parseUnary()}
+		x := parseUnary()
+		if _, ok := x.(*constExpr); ok {
+			return newBinary("-", newUntypedInt(0), x)
+		}
+		return &unary{op: "-", child: x}
 	case consume("*"):
 		return &deref{child: parseUnary()}
 	case consume("&"):
 		return &addr{child: parseUnary()}
 	case consume("!"):
-		return &binary{op: "==", rhs: parseUnary(), lhs: &intLit{val: 0}}
+		return newBinary("==", parseUnary(), newUntypedBool(false))
+	case consume("^"):
+		x := parseUnary()
+		if c, ok := x.(*constExpr); ok {
+			return foldComplement(c)
+		}
+		return &unary{op: "^", child: x}
 	default:
 		return parsePrimary()
 	}
@@ -794,12 +1581,18 @@ func parseUnary() expression {
 // PrimaryExpr = Operand
 //             | PrimaryExpr Selector .
 //             | PrimaryExpr Index .
+//             | PrimaryExpr TypeAssertion .
+//             | PrimaryExpr Arguments .
 func parsePrimary() expression {
 
 	expr := parseOperand()
 
 	for {
 		if consume(".") {
+			if consume("(") {
+				expr = parseTypeAssertion(expr)
+				continue
+			}
 			expr = parseSelector(expr)
 			continue
 		}
@@ -807,6 +1600,10 @@ func parsePrimary() expression {
 			expr = parseIndex(expr)
 			continue
 		}
+		if consume("(") {
+			expr = parseCall(expr)
+			continue
+		}
 
 		return expr
 	}
@@ -814,37 +1611,71 @@ func parsePrimary() expression {
 
 // Selector = "." identifier .
 func parseSelector(expr expression) expression {
+	addType(expr)
 	ty := expr.getType()
-	if ty.kind != typeKindStruct {
-		panic("expected struct type")
-	}
 
 	tok := consumeToken(tokenKindIdentifier)
 	if tok == nil {
 		panic(fmt.Sprintf("Expected an identifier: %+v", tokens[0]))
 	}
 
-	var mem *member
-	for i := range ty.members {
-		m := ty.members[i]
-		if m.name == tok.val {
-			mem = m
+	if ty.kind == typeKindInterface {
+		i, m := findIMethod(ty, tok.val)
+		if m == nil {
+			panic(fmt.Sprintf("%s.%s undefined (type %s has no method %s)", ty, tok.val, ty, tok.val))
 		}
+		return &ifaceMethod{recv: expr, index: i, method: m}
 	}
-
-	return &memberRef{child: expr, member: mem}
+	if sel := lookupSelection(ty, tok.val); sel != nil {
+		switch {
+		case sel.imethod != nil:
+			return &ifaceMethod{recv: selectPath(expr, sel.path), index: sel.index, method: sel.imethod}
+		case sel.method != nil:
+			recv := selectPath(expr, sel.path)
+			return &methodVal{recv: receiverArg(recv, sel.method), method: sel.method}
+		}
+	}
+	return &memberRef{child: expr, name: tok.val}
 }
 
 // Index = "[" Expression "]" .
+// Slice = "[" [ Expression ] ":" [ Expression ] "]" |
+//         "[" [ Expression ] ":" Expression ":" Expression "]" .
 func parseIndex(expr expression) expression {
-	index := parseExpression()
+	var lo expression
+	if !peek(":") {
+		lo = parseExpression()
+	}
+	if consume("]") {
+		return &indexExpr{child: expr, idx: lo}
+	}
+
+	expect(":")
+	ret := &sliceExpr{child: expr, lo: lo}
+	if !peek(":") && !peek("]") {
+		ret.hi = parseExpression()
+	}
+	if consume(":") {
+		if ret.hi == nil {
+			panic("middle index required in 3-index slice")
+		}
+		if peek("]") {
+			panic("final index required in 3-index slice")
+		}
+		ret.max = parseExpression()
+	}
 	expect("]")
-	return &deref{child: addBinary(expr, index)}
+	return ret
 }
 
 // Operand = Literal | identifier [ Arguments ] | "(" Expression ")" .
 func parseOperand() expression {
 	if consume("(") {
+		if ty := parseParenType(); ty != nil {
+			// method expression (*T).M or conversion (T)(x)
+			expect(")")
+			return parseTypedOperand(ty)
+		}
 		ret := parseExpression()
 		expect(")")
 		return ret
@@ -853,16 +1684,45 @@ func parseOperand() expression {
 	// identifier
 	if tok := consumeToken(tokenKindIdentifier); tok != nil {
 
+		sym := lookup(tok.val)
+		if pkg, ok := sym.(*pkgName); ok {
+			b := parseQualifiedIdent(pkg).(*builtin)
+			expect("(")
+			return parseBuiltinCall(b)
+		}
+		if b, ok := sym.(*builtin); ok && funcs[tok.val] == nil {
+			expect("(")
+			return parseBuiltinCall(b)
+		}
+		switch sym := sym.(type) {
+		case *obj:
+			return refVar(sym)
+		case *constObj:
+			return sym.value()
+		case *typeName:
+			return parseTypedOperand(sym.ty)
+		case *nilObj:
+			return newNil()
+		}
+		if tok.val == "iota" && curIota >= 0 {
+			return newUntypedInt(curIota)
+		}
 		if consume("(") {
 			return parseArguments(tok.val)
 		}
-
-		lv := findLocalVar(tok.val)
-		if lv == nil {
-			lv = createLocalVar(tok.val)
+		if f, ok := funcs[tok.val]; ok {
+			return &funcVal{fn: f}
 		}
 
-		return lv
+		panic("undefined: " + tok.val)
+	}
+
+	if tokens[0].kind == tokenKindType && tokens[1].val == "(" {
+		return parseTypedOperand(parseType())
+	}
+
+	if consume("func") {
+		return parseFuncLit()
 	}
 
 	// Literal
@@ -872,9 +1732,11 @@ func parseOperand() expression {
 // Arguments = "(" [ ExpressionList [ "..." ] [ "," ] ] ")" .
 func parseArguments(name string) expression {
 
-	ret := &funcCall{name: name}
-
-	callees = append(callees, ret)
+	f, ok := funcs[name]
+	if !ok {
+		panic("undefined: " + name)
+	}
+	ret := &funcCall{name: name, target: f}
 
 	if consume(")") {
 		return ret
@@ -886,40 +1748,184 @@ func parseArguments(name string) expression {
 	return ret
 }
 
+// parseCall parses the arguments of a call of fn, which is a method or a
+// function value.
+func parseCall(fn expression) expression {
+	var args []expression
+	if !consume(")") {
+		args = parseExpressionList()
+		expect(")")
+	}
+
+	switch fn := fn.(type) {
+	case *methodVal:
+		target := fn.method.fn
+		return &funcCall{name: target.name, target: target, args: append([]expression{fn.recv}, args...)}
+	case *funcVal:
+		return &funcCall{name: fn.fn.name, target: fn.fn, args: args}
+	case *ifaceMethod:
+		return &ifaceCall{recv: fn.recv, index: fn.index, method: fn.method, args: args}
+	}
+	addType(fn)
+	if fn.getType().kind != typeKindFunc {
+		panic(fmt.Sprintf("cannot call non-function value of type %s", fn.getType()))
+	}
+	return &indirectCall{fn: fn, args: args}
+}
+
+func isTypeName(tok *token) bool {
+	if tok.kind != tokenKindIdentifier {
+		return false
+	}
+	_, ok := lookup(tok.val).(*typeName)
+	return ok
+}
+
+// parseParenType parses the type in a parenthesized operand like (*T) or
+// ([]int). It returns nil and consumes nothing if the operand is an
+// expression instead.
+func parseParenType() *typ {
+	i := 0
+	for tokens[i].val == "(" || tokens[i].val == "*" {
+		i++
+	}
+	switch tok := tokens[i]; {
+	case tok.kind == tokenKindType, isTypeName(tok):
+	case tok.val == "[", tok.val == "map", tok.val == "func", tok.val == "struct", tok.val == "interface":
+	default:
+		return nil
+	}
+	saved := tokens
+	ty := parseType()
+	if peek(")") {
+		return ty
+	}
+	// a composite or function literal, a conversion or a method expression
+	tokens = saved
+	return nil
+}
+
+// parseTypedOperand parses an operand that starts with a type name.
+func parseTypedOperand(ty *typ) expression {
+	if consume(".") {
+		return parseMethodExpr(ty)
+	}
+	if consume("{") {
+		return parseLiteralValue(ty)
+	}
+	if consume("(") {
+		return parseConversion(ty)
+	}
+	panic(fmt.Sprintf("%s is not an expression", ty))
+}
+
+// parseLiteralValue parses the elements of a composite literal of type ty
+// after the opening brace.
+func parseLiteralValue(ty *typ) expression {
+	switch ty.kind {
+	case typeKindStruct:
+		return parseStructLiteral(ty)
+	case typeKindArray, typeKindSlice:
+		return parseArrayLiteral(ty)
+	case typeKindMap:
+		return parseMapLiteral(ty)
+	}
+	panic(fmt.Sprintf("invalid composite literal type %s", ty))
+}
+
+// parseElement parses an element of a composite literal whose elements
+// have type ty. An element that is itself a literal may omit its type, and
+// &T if ty is *T.
+func parseElement(ty *typ) expression {
+	if !consume("{") {
+		e := parseExpression()
+		addType(e)
+		return e
+	}
+	if ty.kind == typeKindPtr {
+		e := &addr{child: parseLiteralValue(ty.base)}
+		addType(e)
+		return e
+	}
+	return parseLiteralValue(ty)
+}
+
 func parseLiteral() expression {
 
 	if consume("struct") {
 		ty := parseStructDecl()
+		if consume("(") {
+			return parseConversion(ty)
+		}
 		expect("{")
 		tmp := parseStructLiteral(ty)
 		return tmp
 	}
 
-	if consume("[") {
-		ty := parseArrayType()
+	if peek("[") {
+		var ty *typ
+		if tokens[1].val == "..." {
+			// the length is the number of elements
+			expect("[")
+			expect("...")
+			expect("]")
+			ty = arrayOf(parseType(), -1)
+		} else {
+			ty = parseType()
+			if consume("(") {
+				return parseConversion(ty)
+			}
+		}
 		expect("{")
 		return parseArrayLiteral(ty)
 	}
 
-	return parseIntLit()
+	if peek("interface") {
+		ty := parseType()
+		expect("(")
+		return parseConversion(ty)
+	}
+
+	if peek("map") {
+		ty := parseType()
+		if consume("(") {
+			return parseConversion(ty)
+		}
+		expect("{")
+		return parseMapLiteral(ty)
+	}
+
+	return parseBasicLit()
 }
 
 // ArrayType   = "[" ArrayLength "]" ElementType .
 // ArrayLength = Expression .
 // ElementType = Type .
 func parseArrayType() *typ {
-	length := parseNum()
+	length := parseConstInt()
 	expect("]")
 	base := parseType()
 	return arrayOf(base, length)
 }
 
-// StructType = "struct" "{" { FieldDecl ";" } "}" .
-// FieldDecl  = (IdentifierList Type) .
+// StructType    = "struct" "{" { FieldDecl ";" } "}" .
+// FieldDecl     = (IdentifierList Type | EmbeddedField) .
+// EmbeddedField = [ "*" ] TypeName .
 func parseStructDecl() *typ {
 	expect("{")
 	var members []*member
 	for !consume("}") {
+		if isEmbeddedField() {
+			name := tokens[0].val
+			if name == "*" {
+				name = tokens[1].val
+			}
+			members = append(members, &member{name: name, ty: parseType(), embedded: true})
+			if !peek("}") {
+				expect(";")
+			}
+			continue
+		}
 		ids := parseIdentifierList()
 		ty := parseType()
 		for _, id := range ids {
@@ -928,7 +1934,9 @@ func parseStructDecl() *typ {
 				ty:   ty,
 			})
 		}
-		expect(";")
+		if !peek("}") {
+			expect(";")
+		}
 	}
 	return newStructType(members)
 }
@@ -939,62 +1947,116 @@ func parseStructDecl() *typ {
 // Key           = FieldName | Expression | LiteralValue .
 // FieldName     = identifier .
 // Element       = Expression | LiteralValue .
-
-// LiteralValue  = "{" [ ElementList [ "," ] ] "}" .
-// ElementList   = { "," Element } .
-// Element       = Expression | LiteralValue .
+//
+// The key of an array or slice element is a constant index. Elements
+// without a key follow the previous one. An array type with length -1
+// takes its length from the elements. The elements of a slice literal are
+// stored in a new array on the heap.
 func parseArrayLiteral(ty *typ) expression {
-	ret := &compositeLit{
-		ty:    ty,
-		elems: []expression{},
-	}
-
+	ret := &compositeLit{}
+	seen := map[int]bool{}
+	index := 0
 	for i := 0; !consume("}"); i++ {
 		if i > 0 {
 			expect(",")
+			if consume("}") {
+				break
+			}
+		}
+		e := parseElement(ty.base)
+		if consume(":") {
+			c, ok := e.(*constExpr)
+			if !ok || !isInteger(c.ty) {
+				panic("index must be non-negative integer constant")
+			}
+			n, ok := constant.Int64Val(convertConst(c, newLiteralType("int")).val)
+			if !ok || n < 0 {
+				panic("index must be non-negative integer constant")
+			}
+			index = int(n)
+			e = parseElement(ty.base)
+		}
+		if ty.kind == typeKindArray && ty.length >= 0 && index >= ty.length {
+			panic(fmt.Sprintf("index %d out of bounds [0:%d]", index, ty.length))
+		}
+		if seen[index] {
+			panic(fmt.Sprintf("duplicate index %d in array or slice literal", index))
+		}
+		seen[index] = true
+
+		ret.elems = append(ret.elems, checkAssignable(e, ty.base, "array or slice literal"))
+		ret.offsets = append(ret.offsets, index*ty.base.size)
+		index++
+		if index > ret.length {
+			ret.length = index
 		}
-		ret.elems = append(ret.elems, parseExpression())
 	}
 
+	if ty.kind == typeKindArray && ty.length < 0 {
+		ty = arrayOf(ty.base, ret.length)
+	}
+	ret.ty = ty
+	if ty.kind == typeKindArray {
+		ret.tmp = createLocalVar(newUniqueName())
+		ret.tmp.ty = ty
+	}
 	return ret
 }
 
 // LiteralValue  = "{" [ ElementList [ "," ] ] "}" .
 // ElementList   = KeyedElement { "," KeyedElement } .
 // KeyedElement  = [ Key ":" ] Element .
-// Key           = identifier .
+// Key           = FieldName .
 // Element       = Expression | LiteralValue .
-func parseStructLiteral(ty *typ) *obj {
-	v := createLocalVar(newUniqueName())
+//
+// Either every element names its field or the elements are the values of
+// all the fields in order.
+func parseStructLiteral(ty *typ) expression {
+	ret := &compositeLit{ty: ty}
+	keyed := tokens[0].kind == tokenKindIdentifier && tokens[1].val == ":"
+	seen := map[string]bool{}
 	for i := 0; !consume("}"); i++ {
-		// TODO:
-		panic("unimplemented")
-	}
-	v.ty = ty
-	return v
-}
+		if i > 0 {
+			expect(",")
+			if consume("}") {
+				break
+			}
+		}
+		if keyed != (tokens[0].kind == tokenKindIdentifier && tokens[1].val == ":") {
+			panic("mixture of field:value and value elements in struct literal")
+		}
 
-func parseIntLit() expression {
-	return &intLit{
-		val: parseNum(),
+		var m *member
+		if keyed {
+			name := consumeToken(tokenKindIdentifier).val
+			expect(":")
+			for _, mem := range ty.members {
+				if mem.name == name {
+					m = mem
+				}
+			}
+			if m == nil {
+				panic(fmt.Sprintf("unknown field %s in struct literal of type %s", name, ty))
+			}
+			if seen[name] {
+				panic(fmt.Sprintf("duplicate field name %s in struct literal", name))
+			}
+			seen[name] = true
+		} else {
+			if i >= len(ty.members) {
+				panic(fmt.Sprintf("too many values in struct literal of type %s", ty))
+			}
+			m = ty.members[i]
+		}
+		e := parseElement(m.ty)
+		ret.elems = append(ret.elems, checkAssignable(e, m.ty, "struct literal"))
+		ret.offsets = append(ret.offsets, m.offset)
 	}
-}
-
-func parseNum() int {
-	tok := tokens[0]
-	advance()
-	return tok.num
-}
-
-func addBinary(lhs, rhs expression) expression {
-	addType(lhs)
-	addType(rhs)
-
-	// ptr + num
-	if lhs.getType().base != nil && rhs.getType().kind == typeKindInt {
-		rhs = &binary{op: "*", lhs: &intLit{val: lhs.getType().base.size}, rhs: rhs}
-		return &binary{op: "+", lhs: lhs, rhs: rhs}
+	if !keyed && len(ret.elems) > 0 && len(ret.elems) < len(ty.members) {
+		panic(fmt.Sprintf("too few values in struct literal of type %s", ty))
 	}
 
-	return &binary{op: "+", lhs: lhs, rhs: rhs}
+	ret.tmp = createLocalVar(newUniqueName())
+	ret.tmp.ty = ty
+	return ret
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A range loop is rewritten into a three-clause loop over hidden
+// variables. The iteration variables are declared at the top of the body
+// from them, so every iteration has its own copies and assigning to them
+// does not change the iteration.
+
+// mapIterStmt starts the iterator it over the map m, or advances it if m
+// is nil.
+type mapIterStmt struct {
+	statement
+	ty *typ
+	m  expression
+	it *obj
+}
+
+func (s *mapIterStmt) getType() *typ   { return s.ty }
+func (s *mapIterStmt) setType(ty *typ) { s.ty = ty }
+
+// mapIterOk reports whether the iterator is at an entry.
+type mapIterOk struct {
+	expression
+	ty *typ
+	it *obj
+}
+
+func (e *mapIterOk) getType() *typ   { return e.ty }
+func (e *mapIterOk) setType(ty *typ) { e.ty = ty }
+
+// isRangeClause reports whether the for header that follows is a range
+// clause.
+func isRangeClause() bool {
+	depth := 0
+	for _, tok := range tokens {
+		switch tok.val {
+		case "(", "[":
+			depth++
+		case ")", "]":
+			depth--
+		case "range":
+			return depth == 0
+		case "{", ";":
+			if depth == 0 {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// RangeClause = [ ExpressionList "=" | IdentifierList ":=" ] "range" Expression .
+func parseRangeClause() statement {
+	var names []string
+	var lhs []expression
+	define := false
+	if !consume("range") {
+		if isShortVarDecl() {
+			names = parseIdentifierList()
+			expect(":=")
+			define = true
+		} else {
+			lhs = parseExpressionList()
+			expect("=")
+		}
+		expect("range")
+	}
+	x := parseExpression()
+	addType(x)
+
+	n := len(lhs)
+	if define {
+		n = len(names)
+	}
+	if n > 2 {
+		panic("range clause permits at most two iteration variables")
+	}
+
+	ret := &blockStmt{}
+	decl := func(e expression) *obj {
+		v := createLocalVar(newUniqueName())
+		ret.stmts = append(ret.stmts, newDeclAssignment([]expression{v}, []expression{e}))
+		return v
+	}
+
+	// the values of the iteration variables
+	var vals []expression
+	loop := &forStmt{}
+	ty := x.getType()
+	if ty.kind == typeKindPtr && ty.base.kind == typeKindArray {
+		ty = ty.base
+	}
+	switch {
+	case isInteger(ty):
+		if n > 1 {
+			panic(fmt.Sprintf("range over %s permits only one iteration variable", ty))
+		}
+		ity := defaultType(ty)
+		hn := createLocalVar(newUniqueName())
+		hn.ty = ity
+		ret.stmts = append(ret.stmts, newDeclAssignment([]expression{hn}, []expression{x}))
+		hi := countLoop(loop, ity, hn)
+		vals = []expression{hi}
+
+	case ty.kind == typeKindArray || ty.kind == typeKindSlice:
+		var hx expression = x
+		if n > 1 || ty.kind == typeKindSlice || hasCall(x) {
+			// an array is copied, a slice or a pointer evaluated once
+			hx = decl(x)
+		}
+		var hn expression = newIntConst(ty.length)
+		if ty.kind == typeKindSlice {
+			hn = decl(&builtinCall{name: "len", args: []expression{hx}})
+		}
+		hi := countLoop(loop, newLiteralType("int"), hn)
+		vals = []expression{hi, &indexExpr{child: hx, idx: hi}}
+
+	case ty.kind == typeKindMap:
+		it := createLocalVar(newUniqueName())
+		// the header and buckets are typed as pointers for the collector
+		word, ptr := newLiteralType("int"), pointerTo(newLiteralType("int"))
+		it.ty = newStructType([]*member{
+			{name: "key", ty: pointerTo(ty.key)},
+			{name: "elem", ty: pointerTo(ty.base)},
+			{name: "t", ty: word},
+			{name: "h", ty: ptr},
+			{name: "buckets", ty: ptr},
+			{name: "state", ty: arrayOf(word, 4)},
+			{name: "bptr", ty: ptr},
+			{name: "j", ty: word},
+		})
+		loop.init = &mapIterStmt{m: x, it: it}
+		loop.cond = &mapIterOk{it: it, ty: newLiteralType("bool")}
+		loop.post = &mapIterStmt{it: it}
+		vals = []expression{
+			&deref{child: &memberRef{child: it, member: it.ty.members[0]}},
+			&deref{child: &memberRef{child: it, member: it.ty.members[1]}},
+		}
+
+	default:
+		panic(fmt.Sprintf("cannot range over %s", ty))
+	}
+
+	// the iteration variables are declared in a scope around the body
+	enterScope()
+	body := &blockStmt{}
+	if define {
+		vars := make([]expression, n)
+		for i, name := range names {
+			vars[i] = createLocalVar(name)
+		}
+		body.stmts = append(body.stmts, newDeclAssignment(vars, vals[:n]))
+	} else if n > 0 {
+		body.stmts = append(body.stmts, &assignment{lhs: lhs, rhs: vals[:n]})
+	}
+	expect("{")
+	block := parseBlockStmt()
+	body.stmts = append(body.stmts, block)
+	leaveScope()
+
+	loop.body = body
+	ret.stmts = append(ret.stmts, loop)
+	return ret
+}
+
+// countLoop makes loop count a new variable of type ty from 0 up to n and
+// returns the variable.
+func countLoop(loop *forStmt, ty *typ, n expression) *obj {
+	hi := createLocalVar(newUniqueName())
+	hi.ty = ty
+	loop.init = newDeclAssignment([]expression{hi}, []expression{newUntypedInt(0)})
+	loop.cond = &binary{op: "<", lhs: hi, rhs: n}
+	loop.post = &assignment{lhs: []expression{hi}, rhs: []expression{&binary{op: "+", lhs: hi, rhs: newUntypedInt(1)}}}
+	return hi
+}
+
+// genMapIter emits the call of mapiterinit or mapiternext for s.
+func genMapIter(s *mapIterStmt) {
+	if s.m == nil {
+		genAddr(s.it)
+		fmt.Printf("\tpop rdi\n")
+		popped(8)
+		genCall("runtime.mapiternext")
+		return
+	}
+	genExpr(s.m)
+	genAddr(s.it)
+	fmt.Printf("\tpop rdx\n")
+	fmt.Printf("\tpop rsi\n")
+	popped(16)
+	fmt.Printf("\tlea rdi, [rip+%s]\n", mapType(s.m.getType()))
+	genCall("runtime.mapiterinit")
+}
+
+// genMapIterOk pushes whether the iterator has a current key.
+func genMapIterOk(e *mapIterOk) {
+	genAddr(e.it)
+	fmt.Printf("\tpop rax\n")
+	fmt.Printf("\tcmp qword ptr [rax], 0\n")
+	fmt.Printf("\tsetne al\n")
+	fmt.Printf("\tmovzb rax, al\n")
+	fmt.Printf("\tpush rax\n")
+	popped(8)
+	pushedWords(1, false)
+}
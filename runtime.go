@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The runtime is emitted into every program. Its functions use the same
+// calling convention as compiled functions and talk to the kernel with
+// system calls directly.
+
+func emitRuntime() {
+	emitMalloc()
+	emitGC()
+	emitMakeslice()
+	emitGrowslice()
+	emitMemmove()
+	emitBoundsPanics()
+	emitPanicNil()
+	emitGetitab()
+	emitPanicAssert()
+	emitPanicAssertI()
+	emitWrite()
+	emitMapRuntime()
+	emitPanicRuntime()
+}
+
+// The helpers below are called from generated code with their arguments in
+// registers.
+
+// emitWrite emits runtime.write, which writes rdx bytes at rsi to standard
+// error, runtime.writeType, which writes the name of the type descriptor in
+// rax, and runtime.writeInt and runtime.writeUint, which write rax in
+// decimal.
+func emitWrite() {
+	fmt.Printf("runtime.writeType:\n")
+	fmt.Printf("\tmov rsi, [rax+16]\n")
+	fmt.Printf("\tmov rdx, [rax+24]\n")
+	fmt.Printf("runtime.write:\n")
+	fmt.Printf("\tmov rax, 1\n")
+	fmt.Printf("\tmov rdi, 2\n")
+	fmt.Printf("\tsyscall\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf("runtime.writeUint:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tsub rsp, 32\n")
+	fmt.Printf("\tmov r8, 0\n")
+	fmt.Printf("\tjmp .LwriteInt.digits\n")
+	fmt.Printf("runtime.writeInt:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tsub rsp, 32\n")
+	fmt.Printf("\tmov r8, rax\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjns .LwriteInt.digits\n")
+	fmt.Printf("\tneg rax\n")
+	fmt.Printf(".LwriteInt.digits:\n")
+	fmt.Printf("\tmov rsi, rbp\n")
+	fmt.Printf(".LwriteInt.loop:\n")
+	fmt.Printf("\tmov rdi, 10\n")
+	fmt.Printf("\tmov rdx, 0\n")
+	fmt.Printf("\tdiv rdi\n")
+	fmt.Printf("\tadd dl, '0'\n")
+	fmt.Printf("\tdec rsi\n")
+	fmt.Printf("\tmov [rsi], dl\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjnz .LwriteInt.loop\n")
+	fmt.Printf("\ttest r8, r8\n")
+	fmt.Printf("\tjns .LwriteInt.write\n")
+	fmt.Printf("\tdec rsi\n")
+	fmt.Printf("\tmov byte ptr [rsi], '-'\n")
+	fmt.Printf(".LwriteInt.write:\n")
+	fmt.Printf("\tmov rdx, rbp\n")
+	fmt.Printf("\tsub rdx, rsi\n")
+	fmt.Printf("\tcall runtime.write\n")
+	fmt.Printf("\tmov rsp, rbp\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf("runtime.exit2:\n")
+	fmt.Printf("\tcall runtime.gcExit\n")
+	fmt.Printf("\tmov rax, 231\n")
+	fmt.Printf("\tmov rdi, 2\n")
+	fmt.Printf("\tsyscall\n")
+}
+
+// genWriteString emits a call of runtime.write for s.
+func genWriteString(s string) {
+	fmt.Printf("\tlea rsi, [rip+%s]\n", stringLabel(s))
+	fmt.Printf("\tmov rdx, %d\n", len(s))
+	fmt.Printf("\tcall runtime.write\n")
+}
+
+// emitGetitab emits runtime.getitab, which finds the itab for the type
+// descriptor in rax in the table at rsi. It returns the itab in rax, or 0
+// if the type is nil or does not implement the interface, and the table
+// entry in rcx.
+func emitGetitab() {
+	fmt.Printf("runtime.getitab:\n")
+	fmt.Printf("\tmov rcx, rsi\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lgetitab.done\n")
+	fmt.Printf(".Lgetitab.loop:\n")
+	fmt.Printf("\tmov rdx, [rcx]\n")
+	fmt.Printf("\ttest rdx, rdx\n")
+	fmt.Printf("\tjz .Lgetitab.missing\n")
+	fmt.Printf("\tcmp rdx, rax\n")
+	fmt.Printf("\tje .Lgetitab.found\n")
+	fmt.Printf("\tadd rcx, 32\n")
+	fmt.Printf("\tjmp .Lgetitab.loop\n")
+	fmt.Printf(".Lgetitab.found:\n")
+	fmt.Printf("\tmov rax, [rcx+8]\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf(".Lgetitab.missing:\n")
+	fmt.Printf("\tmov rax, 0\n")
+	fmt.Printf(".Lgetitab.done:\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitPanicAssert emits runtime.panicAssert, which reports a failed
+// assertion to a concrete type: rax is the dynamic type, or 0 if the
+// interface is nil, rsi the asserted type and rdx the interface type.
+func emitPanicAssert() {
+	genRuntimeError("runtime.panicAssert", true, func() {
+		genWriteString("interface conversion: ")
+		fmt.Printf("\tmov rax, [rsp]\n")
+		fmt.Printf("\tmov rax, [rax+24]\n")
+		fmt.Printf("\tcall runtime.writeType\n")
+		genWriteString(" is ")
+		fmt.Printf("\tmov rax, [rsp]\n")
+		fmt.Printf("\tmov rax, [rax+8]\n")
+		fmt.Printf("\ttest rax, rax\n")
+		fmt.Printf("\tjnz .LpanicAssert.type\n")
+		genWriteString("nil")
+		fmt.Printf("\tjmp .LpanicAssert.want\n")
+		fmt.Printf(".LpanicAssert.type:\n")
+		fmt.Printf("\tcall runtime.writeType\n")
+		fmt.Printf(".LpanicAssert.want:\n")
+		genWriteString(", not ")
+		fmt.Printf("\tmov rax, [rsp]\n")
+		fmt.Printf("\tmov rax, [rax+32]\n")
+		fmt.Printf("\tcall runtime.writeType\n")
+	})
+}
+
+// emitPanicAssertI emits runtime.panicAssertI, which reports a failed
+// assertion to an interface type: rax is the dynamic type, or 0 if the
+// interface is nil, rsi the asserted type and rcx the entry of the dynamic
+// type in the itab table.
+func emitPanicAssertI() {
+	genRuntimeError("runtime.panicAssertI", true, func() {
+		genWriteString("interface conversion: ")
+		fmt.Printf("\tmov rax, [rsp]\n")
+		fmt.Printf("\tmov rax, [rax+8]\n")
+		fmt.Printf("\ttest rax, rax\n")
+		fmt.Printf("\tjnz .LpanicAssertI.type\n")
+		genWriteString("interface is nil, not ")
+		fmt.Printf("\tmov rax, [rsp]\n")
+		fmt.Printf("\tmov rax, [rax+32]\n")
+		fmt.Printf("\tcall runtime.writeType\n")
+		fmt.Printf("\tjmp .LpanicAssertI.end\n")
+		fmt.Printf(".LpanicAssertI.type:\n")
+		fmt.Printf("\tcall runtime.writeType\n")
+		genWriteString(" is not ")
+		fmt.Printf("\tmov rax, [rsp]\n")
+		fmt.Printf("\tmov rax, [rax+32]\n")
+		fmt.Printf("\tcall runtime.writeType\n")
+		genWriteString(": missing method ")
+		fmt.Printf("\tmov rcx, [rsp]\n")
+		fmt.Printf("\tmov rcx, [rcx+16]\n")
+		fmt.Printf("\tmov rsi, [rcx+16]\n")
+		fmt.Printf("\tmov rdx, [rcx+24]\n")
+		fmt.Printf("\tcall runtime.write\n")
+		fmt.Printf(".LpanicAssertI.end:\n")
+	})
+}
+
+// emitBoundsPanics emits the functions that report an index or slice
+// bound out of range. rax is the bad bound and rcx what it is checked
+// against.
+func emitBoundsPanics() {
+	for _, p := range []struct{ name, before, between, after string }{
+		{"panicIndex", "index out of range [", "] with length ", ""},
+		{"panicSliceAcap", "slice bounds out of range [:", "] with capacity ", ""},
+		{"panicSliceB", "slice bounds out of range [", ":", "]"},
+		{"panicSlice3Acap", "slice bounds out of range [::", "] with capacity ", ""},
+		{"panicSlice3B", "slice bounds out of range [:", ":", "]"},
+		{"panicSlice3C", "slice bounds out of range [", ":", ":]"},
+	} {
+		genRuntimeError("runtime."+p.name, true, func() {
+			genWriteString("runtime error: " + p.before)
+			genWriteErrorInt(8)
+			genWriteString(p.between)
+			genWriteErrorInt(16)
+			if p.after != "" {
+				genWriteString(p.after)
+			}
+		})
+	}
+}
+
+// emitPanicNil emits runtime.panicNil, which reports the dereference of a
+// nil pointer.
+func emitPanicNil() {
+	genRuntimeError("runtime.panicNil", true, func() {
+		genWriteString("runtime error: invalid memory address or nil pointer dereference")
+	})
+}
+
+// emitMemmove emits runtime.memmove, which copies rcx bytes from rsi to rdi.
+// The areas may overlap.
+func emitMemmove() {
+	fmt.Printf("runtime.memmove:\n")
+	fmt.Printf("\tcmp rdi, rsi\n")
+	fmt.Printf("\tjbe .Lmemmove.forward\n")
+	fmt.Printf("\tlea rax, [rsi+rcx]\n")
+	fmt.Printf("\tcmp rdi, rax\n")
+	fmt.Printf("\tjae .Lmemmove.forward\n")
+	fmt.Printf("\tlea rsi, [rsi+rcx-1]\n")
+	fmt.Printf("\tlea rdi, [rdi+rcx-1]\n")
+	fmt.Printf("\tstd\n")
+	fmt.Printf("\trep movsb\n")
+	fmt.Printf("\tcld\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf(".Lmemmove.forward:\n")
+	fmt.Printf("\trep movsb\n")
+	fmt.Printf("\tret\n")
+}
+
+// maxAlloc bounds the size of an object so that sizes never overflow.
+const maxAlloc = 1 << 47
+
+// emitMakeslice emits runtime.makeslice(elemType *type, len, cap int) []T.
+func emitMakeslice() {
+	fmt.Printf("runtime.makeslice:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tmov r8, %d\n", maxAlloc)
+	fmt.Printf("\tmov r9, [rbp+16]\n")
+	fmt.Printf("\tmov r9, [r9]\n")
+	fmt.Printf("\tmov rax, [rbp+24]\n")
+	fmt.Printf("\tmov rcx, [rbp+32]\n")
+
+	// len is checked first
+	fmt.Printf("\tmov rdx, rax\n")
+	fmt.Printf("\timul rdx, r9\n")
+	fmt.Printf("\tjo .Lmakeslice.len\n")
+	fmt.Printf("\tcmp rdx, r8\n")
+	fmt.Printf("\tja .Lmakeslice.len\n")
+	fmt.Printf("\tmov rdx, rcx\n")
+	fmt.Printf("\timul rdx, r9\n")
+	fmt.Printf("\tjo .Lmakeslice.cap\n")
+	fmt.Printf("\tcmp rdx, r8\n")
+	fmt.Printf("\tja .Lmakeslice.cap\n")
+	fmt.Printf("\tcmp rax, rcx\n")
+	fmt.Printf("\tjg .Lmakeslice.cap\n")
+
+	fmt.Printf("\tmov [rbp+48], rax\n")
+	fmt.Printf("\tmov [rbp+56], rcx\n")
+	fmt.Printf("\tmov rax, [rbp+16]\n")
+	genRuntimeAlloc("rdx", "qword ptr [rax+32]")
+	fmt.Printf("\tmov [rbp+40], rax\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+
+	genRuntimeError(".Lmakeslice.len", false, func() {
+		genWriteString("runtime error: makeslice: len out of range")
+	})
+	genRuntimeError(".Lmakeslice.cap", false, func() {
+		genWriteString("runtime error: makeslice: cap out of range")
+	})
+}
+
+// emitGrowslice emits runtime.growslice(n int, elemType *type, s []T) []T,
+// which returns s extended by n elements. If they do not fit, the elements
+// are copied to a new array: its capacity doubles while the slice is small
+// and then grows by a quarter plus 192 elements at a time, until it is
+// large enough.
+func emitGrowslice() {
+	fmt.Printf("runtime.growslice:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tmov rax, [rbp+40]\n")
+	fmt.Printf("\tadd rax, [rbp+16]\n")
+	fmt.Printf("\tmov [rbp+64], rax\n")
+	fmt.Printf("\tmov rcx, [rbp+48]\n")
+	fmt.Printf("\tcmp rax, rcx\n")
+	fmt.Printf("\tja .Lgrowslice.grow\n")
+	fmt.Printf("\tmov rdx, [rbp+32]\n")
+	fmt.Printf("\tmov [rbp+56], rdx\n")
+	fmt.Printf("\tmov [rbp+72], rcx\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+
+	fmt.Printf(".Lgrowslice.grow:\n")
+	fmt.Printf("\tlea rdx, [rcx+rcx]\n")
+	fmt.Printf("\tcmp rax, rdx\n")
+	fmt.Printf("\tja .Lgrowslice.newlen\n")
+	fmt.Printf("\tcmp rcx, 256\n")
+	fmt.Printf("\tjb .Lgrowslice.alloc\n")
+	fmt.Printf("\tmov rdx, rcx\n")
+	fmt.Printf(".Lgrowslice.loop:\n")
+	fmt.Printf("\tcmp rdx, rax\n")
+	fmt.Printf("\tjae .Lgrowslice.alloc\n")
+	fmt.Printf("\tlea rsi, [rdx+768]\n")
+	fmt.Printf("\tshr rsi, 2\n")
+	fmt.Printf("\tadd rdx, rsi\n")
+	fmt.Printf("\tjmp .Lgrowslice.loop\n")
+	fmt.Printf(".Lgrowslice.newlen:\n")
+	fmt.Printf("\tmov rdx, rax\n")
+
+	fmt.Printf(".Lgrowslice.alloc:\n")
+	fmt.Printf("\tmov [rbp+72], rdx\n")
+	fmt.Printf("\tmov rax, [rbp+24]\n")
+	fmt.Printf("\timul rdx, [rax]\n")
+	genRuntimeAlloc("rdx", "qword ptr [rax+32]")
+	fmt.Printf("\tmov rdi, rax\n")
+	fmt.Printf("\tmov [rbp+56], rdi\n")
+	fmt.Printf("\tmov rsi, [rbp+32]\n")
+	fmt.Printf("\tmov rcx, [rbp+40]\n")
+	fmt.Printf("\tmov rax, [rbp+24]\n")
+	fmt.Printf("\timul rcx, [rax]\n")
+	fmt.Printf("\trep movsb\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+}
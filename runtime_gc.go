@@ -0,0 +1,582 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The collector stops the program in runtime.alloc when the heap has grown
+// past its goal, marks every object reachable from the roots and sweeps
+// the spans, putting the unmarked objects back on the free lists. The goal
+// is the heap left after a collection plus GOGC percent of it, 100 unless
+// the environment says otherwise; GOGC=off turns the collector off.
+//
+// The roots are the package-level variables and the stack. The stack is
+// walked along the frame pointers: the return address into each frame
+// finds its call site in runtime.stackMaps, which tells the words of the
+// locals, of the parameters and results and of the values pushed for the
+// call that hold pointers. The frames of the runtime are scanned
+// conservatively: a word keeps an object alive if it points into one that
+// is allocated. So are the frames between a function and the deferred
+// call it makes for a panic, which runs below the panicking ones.
+//
+// Run with -gcstats, a program writes the pause times and heap sizes to
+// standard error when it exits.
+
+func emitGC() {
+	fmt.Printf("\t.bss\n")
+	fmt.Printf("\t.align 8\n")
+	for _, name := range []string{
+		"stackTop", "markStack", "markTop", "gcstats", "numGC", "pauseTotal", "pauseMax",
+	} {
+		fmt.Printf("runtime.%s:\n", name)
+		fmt.Printf("\t.zero 8\n")
+	}
+	fmt.Printf("\t.data\n")
+	fmt.Printf("\t.align 8\n")
+	fmt.Printf("runtime.gcPercent:\n")
+	fmt.Printf("\t.quad 100\n")
+	fmt.Printf("\t.text\n")
+
+	emitArgs()
+	emitSetNextGC()
+	emitNanotime()
+	emitFindStackMap()
+	emitMarkPtr()
+	emitScan()
+	emitCollect()
+	emitGCExit()
+}
+
+// emitArgs emits runtime.args, which reads the runtime options from the
+// arguments at rsi and the environment at rdx.
+func emitArgs() {
+	fmt.Printf("runtime.args:\n")
+	fmt.Printf("\tmov r8, rsi\n")
+	fmt.Printf(".Largs.arg:\n")
+	fmt.Printf("\tadd r8, 8\n")
+	fmt.Printf("\tmov rdi, [r8]\n")
+	fmt.Printf("\ttest rdi, rdi\n")
+	fmt.Printf("\tjz .Largs.env\n")
+	genStringPrefix("-gcstats", ".Largs.arg")
+	fmt.Printf("\tcmp byte ptr [rdi], 0\n")
+	fmt.Printf("\tjne .Largs.arg\n")
+	fmt.Printf("\tmov qword ptr [rip+runtime.gcstats], 1\n")
+	fmt.Printf("\tjmp .Largs.arg\n")
+
+	fmt.Printf(".Largs.env:\n")
+	fmt.Printf("\tmov rdi, [rdx]\n")
+	fmt.Printf("\ttest rdi, rdi\n")
+	fmt.Printf("\tjz .Largs.done\n")
+	fmt.Printf("\tadd rdx, 8\n")
+	genStringPrefix("GOGC=", ".Largs.env")
+	fmt.Printf("\tmov r9, rdi\n")
+	genStringPrefix("off", ".Largs.percent")
+	fmt.Printf("\tcmp byte ptr [rdi], 0\n")
+	fmt.Printf("\tjne .Largs.percent\n")
+	fmt.Printf("\tmov qword ptr [rip+runtime.gcPercent], -1\n")
+	fmt.Printf("\tjmp .Largs.env\n")
+
+	// a number up to the end of the value
+	fmt.Printf(".Largs.percent:\n")
+	fmt.Printf("\tmov rdi, r9\n")
+	fmt.Printf("\tmov rax, 0\n")
+	fmt.Printf(".Largs.digit:\n")
+	fmt.Printf("\tmovzx rcx, byte ptr [rdi]\n")
+	fmt.Printf("\ttest rcx, rcx\n")
+	fmt.Printf("\tjz .Largs.number\n")
+	fmt.Printf("\tsub rcx, '0'\n")
+	fmt.Printf("\tcmp rcx, 9\n")
+	fmt.Printf("\tja .Largs.env\n")
+	fmt.Printf("\timul rax, rax, 10\n")
+	fmt.Printf("\tadd rax, rcx\n")
+	fmt.Printf("\tinc rdi\n")
+	fmt.Printf("\tjmp .Largs.digit\n")
+	fmt.Printf(".Largs.number:\n")
+	fmt.Printf("\tcmp rdi, r9\n")
+	fmt.Printf("\tje .Largs.env\n")
+	fmt.Printf("\tmov [rip+runtime.gcPercent], rax\n")
+	fmt.Printf("\tjmp .Largs.env\n")
+	fmt.Printf(".Largs.done:\n")
+	fmt.Printf("\tret\n")
+}
+
+// genStringPrefix jumps to mismatch unless the string at rdi starts with
+// s, and otherwise leaves rdi after the prefix. It uses rsi and rcx.
+func genStringPrefix(s, mismatch string) {
+	fmt.Printf("\tlea rsi, [rip+%s]\n", stringLabel(s))
+	fmt.Printf("\tmov rcx, %d\n", len(s))
+	fmt.Printf("\trepe cmpsb\n")
+	fmt.Printf("\tjne %s\n", mismatch)
+}
+
+// emitSetNextGC emits runtime.setNextGC, which sets the heap size that
+// starts the next collection from the size of the heap now.
+func emitSetNextGC() {
+	fmt.Printf("runtime.setNextGC:\n")
+	fmt.Printf("\tmov rcx, [rip+runtime.gcPercent]\n")
+	fmt.Printf("\ttest rcx, rcx\n")
+	fmt.Printf("\tjs .LsetNextGC.off\n")
+	fmt.Printf("\tmov rax, [rip+runtime.heapLive]\n")
+	fmt.Printf("\tmov rdx, %d\n", minHeap)
+	fmt.Printf("\tcmp rax, rdx\n")
+	fmt.Printf("\tcmovb rax, rdx\n")
+	fmt.Printf("\timul rax, rcx\n")
+	fmt.Printf("\tmov rcx, 100\n")
+	fmt.Printf("\tmov rdx, 0\n")
+	fmt.Printf("\tdiv rcx\n")
+	fmt.Printf("\tadd rax, [rip+runtime.heapLive]\n")
+	fmt.Printf("\tmov [rip+runtime.nextGC], rax\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf(".LsetNextGC.off:\n")
+	fmt.Printf("\tmov rax, 0x7fffffffffffffff\n")
+	fmt.Printf("\tmov [rip+runtime.nextGC], rax\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitNanotime emits runtime.nanotime, which returns the monotonic clock
+// in nanoseconds in rax.
+func emitNanotime() {
+	fmt.Printf("runtime.nanotime:\n")
+	fmt.Printf("\tsub rsp, 16\n")
+
+	// clock_gettime(CLOCK_MONOTONIC, rsp)
+	fmt.Printf("\tmov rax, 228\n")
+	fmt.Printf("\tmov rdi, 1\n")
+	fmt.Printf("\tmov rsi, rsp\n")
+	fmt.Printf("\tsyscall\n")
+	fmt.Printf("\timul rax, [rsp], 1000000000\n")
+	fmt.Printf("\tadd rax, [rsp+8]\n")
+	fmt.Printf("\tadd rsp, 16\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitFindStackMap emits runtime.findStackMap, which returns in rax the
+// frame map of the call site that returns to rax, or 0 if it is not in
+// compiled code, and the call site in rdx. The call sites are sorted by
+// address, and each is the address, the frame map, the number of words
+// pushed below the frame and their bitmap.
+func emitFindStackMap() {
+	fmt.Printf("runtime.findStackMap:\n")
+	fmt.Printf("\tlea r10, [rip+runtime.stackMaps]\n")
+	fmt.Printf("\tmov rcx, 0\n")
+	fmt.Printf("\tmov rdx, [rip+runtime.nstackMaps]\n")
+	fmt.Printf(".LfindStackMap.loop:\n")
+	fmt.Printf("\tcmp rcx, rdx\n")
+	fmt.Printf("\tjae .LfindStackMap.none\n")
+	fmt.Printf("\tlea r8, [rcx+rdx]\n")
+	fmt.Printf("\tshr r8, 1\n")
+	fmt.Printf("\tmov r11, r8\n")
+	fmt.Printf("\tshl r11, 5\n")
+	fmt.Printf("\tcmp rax, [r10+r11]\n")
+	fmt.Printf("\tje .LfindStackMap.found\n")
+	fmt.Printf("\tjb .LfindStackMap.lower\n")
+	fmt.Printf("\tlea rcx, [r8+1]\n")
+	fmt.Printf("\tjmp .LfindStackMap.loop\n")
+	fmt.Printf(".LfindStackMap.lower:\n")
+	fmt.Printf("\tmov rdx, r8\n")
+	fmt.Printf("\tjmp .LfindStackMap.loop\n")
+	fmt.Printf(".LfindStackMap.found:\n")
+	fmt.Printf("\tlea rdx, [r10+r11]\n")
+	fmt.Printf("\tmov rax, [rdx+8]\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf(".LfindStackMap.none:\n")
+	fmt.Printf("\tmov rax, 0\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitMarkPtr emits runtime.markPtr, which marks the object rax points
+// into, if it is an allocated object of the heap, and pushes it on the
+// mark stack if it is newly marked and holds pointers. It uses rcx, rdx,
+// r10 and r11.
+func emitMarkPtr() {
+	fmt.Printf("runtime.markPtr:\n")
+	fmt.Printf("\tmov r10, [rip+runtime.arenaStart]\n")
+	fmt.Printf("\tcmp rax, r10\n")
+	fmt.Printf("\tjb .LmarkPtr.done\n")
+	fmt.Printf("\tcmp rax, [rip+runtime.arenaUsed]\n")
+	fmt.Printf("\tjae .LmarkPtr.done\n")
+	fmt.Printf("\tmov rcx, rax\n")
+	fmt.Printf("\tsub rcx, r10\n")
+	fmt.Printf("\tshr rcx, %d\n", pageShift)
+	fmt.Printf("\tmov r11, [rip+runtime.pageTable]\n")
+	fmt.Printf("\tmov r11, [r11+rcx*8]\n")
+	fmt.Printf("\ttest r11, r11\n")
+	fmt.Printf("\tjz .LmarkPtr.done\n")
+
+	// the page of a free span may still name a descriptor that has been
+	// reused, so the address is checked against the span
+	fmt.Printf("\tmov rcx, [r11+56]\n")
+	fmt.Printf("\tcmp rcx, %d\n", spanLarge)
+	fmt.Printf("\tje .LmarkPtr.large\n")
+	fmt.Printf("\ttest rcx, rcx\n")
+	fmt.Printf("\tjs .LmarkPtr.done\n")
+	fmt.Printf("\tsub rax, [r11]\n")
+	fmt.Printf("\tjb .LmarkPtr.done\n")
+	fmt.Printf("\tmov rdx, 0\n")
+	fmt.Printf("\tdiv qword ptr [r11+16]\n")
+	fmt.Printf("\tcmp rax, [r11+24]\n")
+	fmt.Printf("\tjae .LmarkPtr.done\n")
+	fmt.Printf("\timul rax, [r11+16]\n")
+	fmt.Printf("\tadd rax, [r11]\n")
+	fmt.Printf("\tjmp .LmarkPtr.object\n")
+	fmt.Printf(".LmarkPtr.large:\n")
+	fmt.Printf("\tmov rcx, rax\n")
+	fmt.Printf("\tmov rax, [r11]\n")
+	fmt.Printf("\tcmp rcx, rax\n")
+	fmt.Printf("\tjb .LmarkPtr.done\n")
+	fmt.Printf("\tsub rcx, rax\n")
+	fmt.Printf("\tcmp rcx, [r11+16]\n")
+	fmt.Printf("\tjae .LmarkPtr.done\n")
+
+	fmt.Printf(".LmarkPtr.object:\n")
+	fmt.Printf("\tmov rcx, [rax]\n")
+	fmt.Printf("\ttest rcx, %d\n", objAllocated)
+	fmt.Printf("\tjz .LmarkPtr.done\n")
+	fmt.Printf("\ttest rcx, %d\n", objMarked)
+	fmt.Printf("\tjnz .LmarkPtr.done\n")
+	fmt.Printf("\tor rcx, %d\n", objMarked)
+	fmt.Printf("\tmov [rax], rcx\n")
+	fmt.Printf("\tcmp rcx, %d\n", objAllocated|objMarked)
+	fmt.Printf("\tje .LmarkPtr.done\n")
+	fmt.Printf("\tmov rcx, [rip+runtime.markTop]\n")
+	fmt.Printf("\tmov [rcx], rax\n")
+	fmt.Printf("\tadd qword ptr [rip+runtime.markTop], 8\n")
+	fmt.Printf(".LmarkPtr.done:\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitScan emits runtime.scanBlock, which marks what the words of the rsi
+// bitmap point to in the r8 bytes at rdi, the bitmap repeating for each
+// value, and runtime.scanRange, which marks what any word from rdi up to
+// rsi may point to. They use r9, r12 and r13 besides the registers of
+// runtime.markPtr, and change rdi.
+func emitScan() {
+	fmt.Printf("runtime.scanBlock:\n")
+	fmt.Printf("\tlea r13, [rdi+r8]\n")
+	fmt.Printf("\tmov r9, [rsi]\n")
+	fmt.Printf("\tshl r9, 3\n")
+	fmt.Printf(".LscanBlock.value:\n")
+	fmt.Printf("\tlea rax, [rdi+r9]\n")
+	fmt.Printf("\tcmp rax, r13\n")
+	fmt.Printf("\tja .LscanBlock.done\n")
+	fmt.Printf("\tmov r12, 0\n")
+	fmt.Printf(".LscanBlock.word:\n")
+	fmt.Printf("\tbt qword ptr [rsi+8], r12\n")
+	fmt.Printf("\tjnc .LscanBlock.next\n")
+	fmt.Printf("\tmov rax, [rdi+r12*8]\n")
+	fmt.Printf("\tcall runtime.markPtr\n")
+	fmt.Printf(".LscanBlock.next:\n")
+	fmt.Printf("\tinc r12\n")
+	fmt.Printf("\tlea rax, [r12*8]\n")
+	fmt.Printf("\tcmp rax, r9\n")
+	fmt.Printf("\tjb .LscanBlock.word\n")
+	fmt.Printf("\tadd rdi, r9\n")
+	fmt.Printf("\tjmp .LscanBlock.value\n")
+	fmt.Printf(".LscanBlock.done:\n")
+	fmt.Printf("\tret\n")
+
+	fmt.Printf("runtime.scanRange:\n")
+	fmt.Printf("\tcmp rdi, rsi\n")
+	fmt.Printf("\tjae .LscanRange.done\n")
+	fmt.Printf("\tmov rax, [rdi]\n")
+	fmt.Printf("\tcall runtime.markPtr\n")
+	fmt.Printf("\tadd rdi, 8\n")
+	fmt.Printf("\tjmp runtime.scanRange\n")
+	fmt.Printf(".LscanRange.done:\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitCollect emits runtime.gc, which collects the heap. It is called
+// from runtime.alloc.
+func emitCollect() {
+	fmt.Printf("runtime.gc:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	for _, r := range []string{"rbx", "r12", "r13", "r14", "r15"} {
+		fmt.Printf("\tpush %s\n", r)
+	}
+	fmt.Printf("\tcall runtime.nanotime\n")
+	fmt.Printf("\tpush rax\n")
+	fmt.Printf("\tmov rax, [rip+runtime.markStack]\n")
+	fmt.Printf("\tmov [rip+runtime.markTop], rax\n")
+
+	// the package-level variables, each an address, a bitmap and a size
+	fmt.Printf("\tlea rbx, [rip+runtime.gcRoots]\n")
+	fmt.Printf("\tmov r14, [rip+runtime.ngcRoots]\n")
+	fmt.Printf(".Lgc.global:\n")
+	fmt.Printf("\ttest r14, r14\n")
+	fmt.Printf("\tjz .Lgc.stack\n")
+	fmt.Printf("\tmov rdi, [rbx]\n")
+	fmt.Printf("\tmov rsi, [rbx+8]\n")
+	fmt.Printf("\tmov r8, [rbx+16]\n")
+	fmt.Printf("\tcall runtime.scanBlock\n")
+	fmt.Printf("\tadd rbx, 24\n")
+	fmt.Printf("\tdec r14\n")
+	fmt.Printf("\tjmp .Lgc.global\n")
+
+	// Each step scans the frame above rbx, which is the frame pointer of
+	// its callee, and r15 is the frame map of the callee. The frame runs
+	// from the parameters of the callee up to its own frame pointer: the
+	// words pushed for the call, which include the parameters, then the
+	// locals.
+	fmt.Printf(".Lgc.stack:\n")
+	fmt.Printf("\tmov rbx, rbp\n")
+	fmt.Printf("\tmov r15, 0\n")
+	fmt.Printf(".Lgc.frame:\n")
+	fmt.Printf("\tmov rax, [rbx+8]\n")
+	fmt.Printf("\tcall runtime.findStackMap\n")
+	fmt.Printf("\tmov r14, rax\n")
+	fmt.Printf("\tpush rdx\n")
+	fmt.Printf("\ttest r15, r15\n")
+	fmt.Printf("\tjz .Lgc.temps\n")
+	fmt.Printf("\tlea rdi, [rbx+16]\n")
+	fmt.Printf("\tmov rsi, [r15+24]\n")
+	fmt.Printf("\tmov r8, [r15+8]\n")
+	fmt.Printf("\ttest rsi, rsi\n")
+	fmt.Printf("\tjz .Lgc.temps\n")
+	fmt.Printf("\tcall runtime.scanBlock\n")
+	fmt.Printf(".Lgc.temps:\n")
+	fmt.Printf("\tpop rdx\n")
+	fmt.Printf("\tlea rdi, [rbx+16]\n")
+	fmt.Printf("\tmov rsi, [rbx]\n")
+	fmt.Printf("\ttest r14, r14\n")
+	fmt.Printf("\tjz .Lgc.range\n")
+	fmt.Printf("\tsub rsi, [r14]\n")
+	fmt.Printf("\tpush rsi\n")
+
+	// the pushed words are those of the call site unless the frame makes
+	// a deferred call for a panic
+	fmt.Printf("\tmov r8, rsi\n")
+	fmt.Printf("\tsub r8, rdi\n")
+	fmt.Printf("\tmov rax, [rdx+16]\n")
+	fmt.Printf("\tshl rax, 3\n")
+	fmt.Printf("\tcmp rax, r8\n")
+	fmt.Printf("\tje .Lgc.pushed\n")
+	fmt.Printf("\tcall runtime.scanRange\n")
+	fmt.Printf("\tjmp .Lgc.locals\n")
+	fmt.Printf(".Lgc.pushed:\n")
+	fmt.Printf("\tmov rsi, [rdx+24]\n")
+	fmt.Printf("\ttest rsi, rsi\n")
+	fmt.Printf("\tjz .Lgc.locals\n")
+	fmt.Printf("\tcall runtime.scanBlock\n")
+	fmt.Printf(".Lgc.locals:\n")
+	fmt.Printf("\tpop rdi\n")
+	fmt.Printf("\tmov rsi, [r14+16]\n")
+	fmt.Printf("\tmov r8, [r14]\n")
+	fmt.Printf("\ttest rsi, rsi\n")
+	fmt.Printf("\tjz .Lgc.next\n")
+	fmt.Printf("\tcall runtime.scanBlock\n")
+	fmt.Printf("\tjmp .Lgc.next\n")
+	fmt.Printf(".Lgc.range:\n")
+	fmt.Printf("\tcall runtime.scanRange\n")
+	fmt.Printf(".Lgc.next:\n")
+	fmt.Printf("\tmov r15, r14\n")
+	fmt.Printf("\tmov rax, [rbx]\n")
+	fmt.Printf("\tcmp rax, [rip+runtime.stackTop]\n")
+	fmt.Printf("\tje .Lgc.mark\n")
+	fmt.Printf("\tcmp rax, rbx\n")
+	fmt.Printf("\tjbe .Lgc.mark\n")
+	fmt.Printf("\tmov rbx, rax\n")
+	fmt.Printf("\tjmp .Lgc.frame\n")
+
+	// the objects on the mark stack are scanned up to their span's size
+	fmt.Printf(".Lgc.mark:\n")
+	fmt.Printf("\tmov rax, [rip+runtime.markTop]\n")
+	fmt.Printf("\tcmp rax, [rip+runtime.markStack]\n")
+	fmt.Printf("\tje .Lgc.sweep\n")
+	fmt.Printf("\tsub rax, 8\n")
+	fmt.Printf("\tmov [rip+runtime.markTop], rax\n")
+	fmt.Printf("\tmov rdi, [rax]\n")
+	fmt.Printf("\tmov rax, rdi\n")
+	fmt.Printf("\tsub rax, [rip+runtime.arenaStart]\n")
+	fmt.Printf("\tshr rax, %d\n", pageShift)
+	fmt.Printf("\tmov rcx, [rip+runtime.pageTable]\n")
+	fmt.Printf("\tmov rcx, [rcx+rax*8]\n")
+	fmt.Printf("\tmov r8, [rcx+16]\n")
+	fmt.Printf("\tsub r8, 8\n")
+	fmt.Printf("\tmov rsi, [rdi]\n")
+	fmt.Printf("\tand rsi, %d\n", ^(objAllocated | objMarked))
+	fmt.Printf("\tadd rdi, 8\n")
+	fmt.Printf("\tcall runtime.scanBlock\n")
+	fmt.Printf("\tjmp .Lgc.mark\n")
+
+	emitSweep()
+	emitCoalesce()
+
+	fmt.Printf("\tcall runtime.setNextGC\n")
+	fmt.Printf("\tcall runtime.nanotime\n")
+	fmt.Printf("\tpop rcx\n")
+	fmt.Printf("\tsub rax, rcx\n")
+	fmt.Printf("\tadd [rip+runtime.pauseTotal], rax\n")
+	fmt.Printf("\tinc qword ptr [rip+runtime.numGC]\n")
+	fmt.Printf("\tcmp rax, [rip+runtime.pauseMax]\n")
+	fmt.Printf("\tjbe .Lgc.done\n")
+	fmt.Printf("\tmov [rip+runtime.pauseMax], rax\n")
+	fmt.Printf(".Lgc.done:\n")
+	for _, r := range []string{"r15", "r14", "r13", "r12", "rbx"} {
+		fmt.Printf("\tpop %s\n", r)
+	}
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitSweep emits the sweep of runtime.gc. The marked objects stay and
+// lose their mark; the free lists of the small spans are rebuilt from the
+// others, lowest address first. A span without a marked object is freed.
+func emitSweep() {
+	fmt.Printf(".Lgc.sweep:\n")
+	fmt.Printf("\tlea rdi, [rip+runtime.classSpans]\n")
+	fmt.Printf("\tmov rcx, %d\n", len(sizeClasses))
+	fmt.Printf("\tmov rax, 0\n")
+	fmt.Printf("\trep stosq\n")
+	fmt.Printf("\tmov qword ptr [rip+runtime.heapLive], 0\n")
+	fmt.Printf("\tmov rbx, [rip+runtime.spanDescs]\n")
+	fmt.Printf(".Lsweep.span:\n")
+	fmt.Printf("\tcmp rbx, [rip+runtime.spanNext]\n")
+	fmt.Printf("\tjae .Lsweep.done\n")
+	fmt.Printf("\tmov rax, [rbx+56]\n")
+	fmt.Printf("\tcmp rax, %d\n", spanLarge)
+	fmt.Printf("\tje .Lsweep.large\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjs .Lsweep.next\n")
+
+	fmt.Printf("\tmov rcx, [rbx+24]\n")
+	fmt.Printf("\tmov r8, [rbx+16]\n")
+	fmt.Printf("\tlea rdi, [rcx-1]\n")
+	fmt.Printf("\timul rdi, r8\n")
+	fmt.Printf("\tadd rdi, [rbx]\n")
+	fmt.Printf("\tmov rsi, 0\n")
+	fmt.Printf("\tmov r9, 0\n")
+	fmt.Printf(".Lsweep.object:\n")
+	fmt.Printf("\tmov rax, [rdi]\n")
+	fmt.Printf("\ttest rax, %d\n", objMarked)
+	fmt.Printf("\tjz .Lsweep.free\n")
+	fmt.Printf("\txor rax, %d\n", objMarked)
+	fmt.Printf("\tmov [rdi], rax\n")
+	fmt.Printf("\tadd [rip+runtime.heapLive], r8\n")
+	fmt.Printf("\tjmp .Lsweep.prev\n")
+	fmt.Printf(".Lsweep.free:\n")
+	fmt.Printf("\tmov [rdi], rsi\n")
+	fmt.Printf("\tmov rsi, rdi\n")
+	fmt.Printf("\tinc r9\n")
+	fmt.Printf(".Lsweep.prev:\n")
+	fmt.Printf("\tsub rdi, r8\n")
+	fmt.Printf("\tdec rcx\n")
+	fmt.Printf("\tjnz .Lsweep.object\n")
+	fmt.Printf("\tmov [rbx+32], rsi\n")
+	fmt.Printf("\tmov [rbx+48], r9\n")
+	fmt.Printf("\tcmp r9, [rbx+24]\n")
+	fmt.Printf("\tje .Lsweep.release\n")
+	fmt.Printf("\ttest r9, r9\n")
+	fmt.Printf("\tjz .Lsweep.next\n")
+	fmt.Printf("\tmov rax, [rbx+56]\n")
+	fmt.Printf("\tlea rcx, [rip+runtime.classSpans]\n")
+	fmt.Printf("\tmov rdx, [rcx+rax*8]\n")
+	fmt.Printf("\tmov [rbx+40], rdx\n")
+	fmt.Printf("\tmov [rcx+rax*8], rbx\n")
+	fmt.Printf("\tjmp .Lsweep.next\n")
+
+	fmt.Printf(".Lsweep.large:\n")
+	fmt.Printf("\tmov rdi, [rbx]\n")
+	fmt.Printf("\tmov rax, [rdi]\n")
+	fmt.Printf("\ttest rax, %d\n", objMarked)
+	fmt.Printf("\tjz .Lsweep.release\n")
+	fmt.Printf("\txor rax, %d\n", objMarked)
+	fmt.Printf("\tmov [rdi], rax\n")
+	fmt.Printf("\tmov rax, [rbx+16]\n")
+	fmt.Printf("\tadd [rip+runtime.heapLive], rax\n")
+	fmt.Printf("\tjmp .Lsweep.next\n")
+
+	// madvise(start, size, MADV_DONTNEED)
+	fmt.Printf(".Lsweep.release:\n")
+	fmt.Printf("\tmov qword ptr [rbx+56], %d\n", spanFree)
+	fmt.Printf("\tmov rax, 28\n")
+	fmt.Printf("\tmov rdi, [rbx]\n")
+	fmt.Printf("\tmov rsi, [rbx+8]\n")
+	fmt.Printf("\tshl rsi, %d\n", pageShift)
+	fmt.Printf("\tmov rdx, 4\n")
+	fmt.Printf("\tsyscall\n")
+	fmt.Printf(".Lsweep.next:\n")
+	fmt.Printf("\tadd rbx, %d\n", spanDescSize)
+	fmt.Printf("\tjmp .Lsweep.span\n")
+	fmt.Printf(".Lsweep.done:\n")
+}
+
+// emitCoalesce emits the end of the sweep of runtime.gc, which walks the
+// spans in address order, merges free neighbours and rebuilds the list of
+// free spans. A free span at the end of the arena in use is given back to
+// it. r14 is the free span being grown.
+func emitCoalesce() {
+	fmt.Printf("\tmov qword ptr [rip+runtime.freeSpans], 0\n")
+	fmt.Printf("\tmov rbx, [rip+runtime.arenaStart]\n")
+	fmt.Printf("\tmov r14, 0\n")
+	fmt.Printf(".Lcoalesce.span:\n")
+	fmt.Printf("\tcmp rbx, [rip+runtime.arenaUsed]\n")
+	fmt.Printf("\tjae .Lcoalesce.end\n")
+	fmt.Printf("\tmov rax, rbx\n")
+	fmt.Printf("\tsub rax, [rip+runtime.arenaStart]\n")
+	fmt.Printf("\tshr rax, %d\n", pageShift)
+	fmt.Printf("\tmov rcx, [rip+runtime.pageTable]\n")
+	fmt.Printf("\tmov rdi, [rcx+rax*8]\n")
+	fmt.Printf("\tmov rax, [rdi+8]\n")
+	fmt.Printf("\tshl rax, %d\n", pageShift)
+	fmt.Printf("\tadd rbx, rax\n")
+	fmt.Printf("\tcmp qword ptr [rdi+56], %d\n", spanFree)
+	fmt.Printf("\tjne .Lcoalesce.used\n")
+	fmt.Printf("\ttest r14, r14\n")
+	fmt.Printf("\tjnz .Lcoalesce.merge\n")
+	fmt.Printf("\tmov r14, rdi\n")
+	fmt.Printf("\tjmp .Lcoalesce.span\n")
+	fmt.Printf(".Lcoalesce.merge:\n")
+	fmt.Printf("\tmov rax, [rdi+8]\n")
+	fmt.Printf("\tadd [r14+8], rax\n")
+	genReleaseDesc("rdi")
+	fmt.Printf("\tjmp .Lcoalesce.span\n")
+	fmt.Printf(".Lcoalesce.used:\n")
+	fmt.Printf("\ttest r14, r14\n")
+	fmt.Printf("\tjz .Lcoalesce.span\n")
+	fmt.Printf("\tmov rax, [rip+runtime.freeSpans]\n")
+	fmt.Printf("\tmov [r14+40], rax\n")
+	fmt.Printf("\tmov [rip+runtime.freeSpans], r14\n")
+	fmt.Printf("\tmov r14, 0\n")
+	fmt.Printf("\tjmp .Lcoalesce.span\n")
+	fmt.Printf(".Lcoalesce.end:\n")
+	fmt.Printf("\ttest r14, r14\n")
+	fmt.Printf("\tjz .Lcoalesce.done\n")
+	fmt.Printf("\tmov rax, [r14]\n")
+	fmt.Printf("\tmov [rip+runtime.arenaUsed], rax\n")
+	genReleaseDesc("r14")
+	fmt.Printf(".Lcoalesce.done:\n")
+}
+
+// genReleaseDesc puts the span descriptor in reg on the list of unused
+// ones. It uses rax.
+func genReleaseDesc(reg string) {
+	fmt.Printf("\tmov qword ptr [%s+56], %d\n", reg, spanUnused)
+	fmt.Printf("\tmov rax, [rip+runtime.descFree]\n")
+	fmt.Printf("\tmov [%s+40], rax\n", reg)
+	fmt.Printf("\tmov [rip+runtime.descFree], %s\n", reg)
+}
+
+// emitGCExit emits runtime.gcExit, which writes the statistics of the
+// collector if the program was run with -gcstats.
+func emitGCExit() {
+	fmt.Printf("runtime.gcExit:\n")
+	fmt.Printf("\tcmp qword ptr [rip+runtime.gcstats], 0\n")
+	fmt.Printf("\tje .LgcExit.done\n")
+	for _, s := range []struct{ text, value string }{
+		{"gc: ", "numGC"},
+		{" collections, pause total ", "pauseTotal"},
+		{" ns, max ", "pauseMax"},
+		{" ns\ngc: heap in use ", "heapLive"},
+		{" bytes, peak ", "heapPeak"},
+		{" bytes, allocated in total ", "totalAlloc"},
+	} {
+		genWriteString(s.text)
+		fmt.Printf("\tmov rax, [rip+runtime.%s]\n", s.value)
+		fmt.Printf("\tcall runtime.writeInt\n")
+	}
+	genWriteString(" bytes\n")
+	fmt.Printf(".LgcExit.done:\n")
+	fmt.Printf("\tret\n")
+}
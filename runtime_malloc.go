@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The heap is one range of address space reserved from the kernel at the
+// first allocation and handed out in pages of 8KB. A span is a run of
+// pages that holds either objects of one size class or a single large
+// object. The page table maps every page of the arena to the descriptor
+// of its span, so that the span of any address in the heap can be found.
+//
+// A span descriptor is:
+//
+//	[0]  start      address of the first page
+//	[8]  npages     number of pages
+//	[16] elemsize   size of its objects
+//	[24] nelems     number of objects
+//	[32] freelist   first free object, whose first word links the next
+//	[40] next       next span of the size class with free objects, of
+//	                the free spans or of the unused descriptors
+//	[48] nfree      number of free objects
+//	[56] class      size class, or one of the span states below
+//
+// The spans of a size class that have free objects are linked from
+// runtime.classSpans. Freed spans are merged with their free neighbours
+// by the collector and linked from runtime.freeSpans; their pages are
+// given back to the kernel, so that they are zero like fresh ones.
+//
+// An object starts with a header word: the pointer bitmap of its values,
+// or 0 if they hold no pointers, with the flags below in its low bits.
+// The header of a free object is the link of the free list, which has
+// neither flag set. Objects are zeroed when they are allocated.
+
+const (
+	pageShift    = 13
+	pageSize     = 1 << pageShift
+	arenaSize    = 1 << 32
+	arenaPages   = arenaSize / pageSize
+	spanPages    = 4
+	spanDescSize = 64
+	maxSmallSize = 2048
+
+	// minHeap is the heap size at which the first collection starts
+	minHeap = 4 << 20
+)
+
+// span states
+const (
+	spanLarge  = -1
+	spanFree   = -2
+	spanUnused = -3
+)
+
+// object header flags
+const (
+	objAllocated = 1
+	objMarked    = 2
+)
+
+// sizeClasses are the object sizes of small objects, header included.
+// Every size is a multiple of 16, so objects stay 16-byte aligned.
+var sizeClasses = []int{
+	16, 32, 48, 64, 80, 96, 112, 128, 160, 192, 224, 256,
+	320, 384, 448, 512, 640, 768, 896, 1024, 1280, 1536, 1792, 2048,
+}
+
+func emitMalloc() {
+	fmt.Printf("\t.bss\n")
+	fmt.Printf("\t.align 8\n")
+	for _, name := range []string{
+		"arenaStart", "arenaUsed", "arenaEnd", "pageTable", "spanDescs", "spanNext",
+		"descFree", "freeSpans", "heapLive", "heapPeak", "totalAlloc", "nextGC",
+	} {
+		fmt.Printf("runtime.%s:\n", name)
+		fmt.Printf("\t.zero 8\n")
+	}
+	fmt.Printf("runtime.classSpans:\n")
+	fmt.Printf("\t.zero %d\n", 8*len(sizeClasses))
+
+	// the size class of a size rounded up to 16, indexed by size/16
+	fmt.Printf("\t.section .rodata\n")
+	fmt.Printf("runtime.sizeToClass:\n")
+	class := make([]string, maxSmallSize/16+1)
+	c := 0
+	for i := range class {
+		for sizeClasses[c] < 16*i {
+			c++
+		}
+		class[i] = fmt.Sprint(c)
+	}
+	fmt.Printf("\t.byte %s\n", strings.Join(class, ", "))
+	fmt.Printf("\t.align 8\n")
+	fmt.Printf("runtime.classSizes:\n")
+	for _, size := range sizeClasses {
+		fmt.Printf("\t.quad %d\n", size)
+	}
+	fmt.Printf("\t.text\n")
+
+	emitHeapInit()
+	emitNewDesc()
+	emitAllocPages()
+	emitAlloc()
+}
+
+// emitHeapInit emits runtime.heapInit, which reserves the page table, the
+// span descriptors, the mark stack of the collector and the arena. The
+// kernel backs their pages with zeroed memory as they are first touched.
+// The mark stack has room for every object the arena can hold.
+func emitHeapInit() {
+	tableSize := arenaPages * 8
+	descsSize := arenaPages * spanDescSize
+	markSize := arenaSize / sizeClasses[0] * 8
+	fmt.Printf("runtime.heapInit:\n")
+
+	// mmap(nil, size, PROT_READ|PROT_WRITE, MAP_PRIVATE|MAP_ANONYMOUS|MAP_NORESERVE, -1, 0)
+	fmt.Printf("\tmov rax, 9\n")
+	fmt.Printf("\tmov rdi, 0\n")
+	fmt.Printf("\tmov rsi, %d\n", tableSize+descsSize+markSize+arenaSize)
+	fmt.Printf("\tmov rdx, 3\n")
+	fmt.Printf("\tmov r10, 0x4022\n")
+	fmt.Printf("\tmov r8, -1\n")
+	fmt.Printf("\tmov r9, 0\n")
+	fmt.Printf("\tsyscall\n")
+	fmt.Printf("\tcmp rax, -4096\n")
+	fmt.Printf("\tja runtime.oom\n")
+	fmt.Printf("\tmov [rip+runtime.pageTable], rax\n")
+	fmt.Printf("\tadd rax, %d\n", tableSize)
+	fmt.Printf("\tmov [rip+runtime.spanDescs], rax\n")
+	fmt.Printf("\tmov [rip+runtime.spanNext], rax\n")
+	fmt.Printf("\tadd rax, %d\n", descsSize)
+	fmt.Printf("\tmov [rip+runtime.markStack], rax\n")
+	fmt.Printf("\tmov rdx, %d\n", markSize)
+	fmt.Printf("\tadd rax, rdx\n")
+	fmt.Printf("\tmov [rip+runtime.arenaStart], rax\n")
+	fmt.Printf("\tmov [rip+runtime.arenaUsed], rax\n")
+	fmt.Printf("\tmov rdx, %d\n", arenaSize)
+	fmt.Printf("\tadd rax, rdx\n")
+	fmt.Printf("\tmov [rip+runtime.arenaEnd], rax\n")
+	fmt.Printf("\tjmp runtime.setNextGC\n")
+
+	fmt.Printf("runtime.oom:\n")
+	genWriteString("fatal error: out of memory\n")
+	fmt.Printf("\tjmp runtime.exit2\n")
+}
+
+// emitNewDesc emits runtime.newDesc, which returns an unused span
+// descriptor in rax. It uses rcx.
+func emitNewDesc() {
+	fmt.Printf("runtime.newDesc:\n")
+	fmt.Printf("\tmov rax, [rip+runtime.descFree]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .LnewDesc.bump\n")
+	fmt.Printf("\tmov rcx, [rax+40]\n")
+	fmt.Printf("\tmov [rip+runtime.descFree], rcx\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf(".LnewDesc.bump:\n")
+	fmt.Printf("\tmov rax, [rip+runtime.spanNext]\n")
+	fmt.Printf("\tadd qword ptr [rip+runtime.spanNext], %d\n", spanDescSize)
+	fmt.Printf("\tret\n")
+}
+
+// emitAllocPages emits runtime.allocPages, which returns in rax the
+// descriptor of a new span of rdi pages and records it in the page table.
+// The pages are taken from the first free span large enough, or else
+// from the end of the arena in use. It keeps r8 and r9.
+func emitAllocPages() {
+	fmt.Printf("runtime.allocPages:\n")
+	fmt.Printf("\tlea r10, [rip+runtime.freeSpans]\n")
+	fmt.Printf(".LallocPages.free:\n")
+	fmt.Printf("\tmov rax, [r10]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .LallocPages.bump\n")
+	fmt.Printf("\tcmp [rax+8], rdi\n")
+	fmt.Printf("\tjae .LallocPages.found\n")
+	fmt.Printf("\tlea r10, [rax+40]\n")
+	fmt.Printf("\tjmp .LallocPages.free\n")
+
+	// a free span of the right size is unlinked and a larger one split
+	fmt.Printf(".LallocPages.found:\n")
+	fmt.Printf("\tjne .LallocPages.split\n")
+	fmt.Printf("\tmov rcx, [rax+40]\n")
+	fmt.Printf("\tmov [r10], rcx\n")
+	fmt.Printf("\tjmp .LallocPages.map\n")
+	fmt.Printf(".LallocPages.split:\n")
+	fmt.Printf("\tmov r11, rax\n")
+	fmt.Printf("\tcall runtime.newDesc\n")
+	fmt.Printf("\tmov rsi, [r11]\n")
+	fmt.Printf("\tmov [rax], rsi\n")
+	fmt.Printf("\tmov [rax+8], rdi\n")
+	fmt.Printf("\tmov rcx, rdi\n")
+	fmt.Printf("\tshl rcx, %d\n", pageShift)
+	fmt.Printf("\tadd [r11], rcx\n")
+	fmt.Printf("\tsub [r11+8], rdi\n")
+	fmt.Printf("\tmov rcx, [r11]\n")
+	fmt.Printf("\tsub rcx, [rip+runtime.arenaStart]\n")
+	fmt.Printf("\tshr rcx, %d\n", pageShift)
+	fmt.Printf("\tmov rdx, [rip+runtime.pageTable]\n")
+	fmt.Printf("\tmov [rdx+rcx*8], r11\n")
+	fmt.Printf("\tjmp .LallocPages.map\n")
+
+	fmt.Printf(".LallocPages.bump:\n")
+	fmt.Printf("\tmov rsi, [rip+runtime.arenaUsed]\n")
+	fmt.Printf("\tmov rdx, [rip+runtime.arenaEnd]\n")
+	fmt.Printf("\tsub rdx, rsi\n")
+	fmt.Printf("\tshr rdx, %d\n", pageShift)
+	fmt.Printf("\tcmp rdi, rdx\n")
+	fmt.Printf("\tja runtime.oom\n")
+	fmt.Printf("\tmov rax, rdi\n")
+	fmt.Printf("\tshl rax, %d\n", pageShift)
+	fmt.Printf("\tadd [rip+runtime.arenaUsed], rax\n")
+	fmt.Printf("\tcall runtime.newDesc\n")
+	fmt.Printf("\tmov [rax], rsi\n")
+	fmt.Printf("\tmov [rax+8], rdi\n")
+
+	fmt.Printf(".LallocPages.map:\n")
+	fmt.Printf("\tmov rsi, [rax]\n")
+	fmt.Printf("\tmov rcx, [rax+8]\n")
+	fmt.Printf("\tsub rsi, [rip+runtime.arenaStart]\n")
+	fmt.Printf("\tshr rsi, %d\n", pageShift)
+	fmt.Printf("\tmov rdx, [rip+runtime.pageTable]\n")
+	fmt.Printf("\tlea rdx, [rdx+rsi*8]\n")
+	fmt.Printf(".LallocPages.loop:\n")
+	fmt.Printf("\tmov [rdx], rax\n")
+	fmt.Printf("\tadd rdx, 8\n")
+	fmt.Printf("\tdec rcx\n")
+	fmt.Printf("\tjnz .LallocPages.loop\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitAlloc emits runtime.alloc(size int, gcdata *bitmap) unsafe.Pointer,
+// which returns zeroed memory for values with the pointer bitmap gcdata.
+// A small object is taken from the first span of its size class with a
+// free object, and a new span is made when there is none. A large object
+// gets a span of its own. The collector runs first when the heap has
+// grown past its goal.
+func emitAlloc() {
+	fmt.Printf("runtime.alloc:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tcmp qword ptr [rip+runtime.arenaStart], 0\n")
+	fmt.Printf("\tjne .Lalloc.ready\n")
+	fmt.Printf("\tcall runtime.heapInit\n")
+	fmt.Printf(".Lalloc.ready:\n")
+	fmt.Printf("\tmov rax, [rip+runtime.heapLive]\n")
+	fmt.Printf("\tcmp rax, [rip+runtime.nextGC]\n")
+	fmt.Printf("\tjb .Lalloc.size\n")
+	fmt.Printf("\tcall runtime.gc\n")
+	fmt.Printf(".Lalloc.size:\n")
+	fmt.Printf("\tmov rax, [rbp+16]\n")
+	fmt.Printf("\tadd rax, 8\n")
+	fmt.Printf("\tcmp rax, %d\n", maxSmallSize)
+	fmt.Printf("\tja .Lalloc.large\n")
+
+	fmt.Printf("\tadd rax, 15\n")
+	fmt.Printf("\tshr rax, 4\n")
+	fmt.Printf("\tlea rcx, [rip+runtime.sizeToClass]\n")
+	fmt.Printf("\tmovzx r8, byte ptr [rcx+rax]\n")
+	fmt.Printf("\tlea r9, [rip+runtime.classSpans]\n")
+	fmt.Printf("\tmov rax, [r9+r8*8]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjnz .Lalloc.span\n")
+
+	// a new span with all its objects on the free list
+	fmt.Printf("\tmov rdi, %d\n", spanPages)
+	fmt.Printf("\tcall runtime.allocPages\n")
+	fmt.Printf("\tlea rcx, [rip+runtime.classSizes]\n")
+	fmt.Printf("\tmov rcx, [rcx+r8*8]\n")
+	fmt.Printf("\tmov [rax+16], rcx\n")
+	fmt.Printf("\tmov [rax+56], r8\n")
+	fmt.Printf("\tmov qword ptr [rax+40], 0\n")
+	fmt.Printf("\tmov rsi, [rax]\n")
+	fmt.Printf("\tmov [rax+32], rsi\n")
+	fmt.Printf("\tmov rdi, 0\n")
+	fmt.Printf(".Lalloc.link:\n")
+	fmt.Printf("\tlea rdx, [rsi+rcx]\n")
+	fmt.Printf("\tmov [rsi], rdx\n")
+	fmt.Printf("\tmov rsi, rdx\n")
+	fmt.Printf("\tinc rdi\n")
+	fmt.Printf("\tlea rdx, [rsi+rcx]\n")
+	fmt.Printf("\tmov r10, [rax]\n")
+	fmt.Printf("\tadd r10, %d\n", spanPages*pageSize)
+	fmt.Printf("\tcmp rdx, r10\n")
+	fmt.Printf("\tjbe .Lalloc.link\n")
+	fmt.Printf("\tsub rsi, rcx\n")
+	fmt.Printf("\tmov qword ptr [rsi], 0\n")
+	fmt.Printf("\tmov [rax+24], rdi\n")
+	fmt.Printf("\tmov [rax+48], rdi\n")
+	fmt.Printf("\tmov [r9+r8*8], rax\n")
+
+	// take the first free object, and drop the span from its class when
+	// it is full
+	fmt.Printf(".Lalloc.span:\n")
+	fmt.Printf("\tmov rdi, [rax+32]\n")
+	fmt.Printf("\tmov rdx, [rdi]\n")
+	fmt.Printf("\tmov [rax+32], rdx\n")
+	fmt.Printf("\tdec qword ptr [rax+48]\n")
+	fmt.Printf("\tjnz .Lalloc.zero\n")
+	fmt.Printf("\tmov rdx, [rax+40]\n")
+	fmt.Printf("\tmov [r9+r8*8], rdx\n")
+	fmt.Printf("\tmov qword ptr [rax+40], 0\n")
+	fmt.Printf(".Lalloc.zero:\n")
+	fmt.Printf("\tmov rdx, rdi\n")
+	fmt.Printf("\tmov r8, [rax+16]\n")
+	fmt.Printf("\tmov rcx, r8\n")
+	fmt.Printf("\tmov rax, 0\n")
+	fmt.Printf("\trep stosb\n")
+	fmt.Printf("\tmov rcx, r8\n")
+	fmt.Printf("\tjmp .Lalloc.done\n")
+
+	// the pages of a new large span are still zero
+	fmt.Printf(".Lalloc.large:\n")
+	fmt.Printf("\tlea rdi, [rax+%d]\n", pageSize-1)
+	fmt.Printf("\tshr rdi, %d\n", pageShift)
+	fmt.Printf("\tcall runtime.allocPages\n")
+	fmt.Printf("\tmov rcx, [rax+8]\n")
+	fmt.Printf("\tshl rcx, %d\n", pageShift)
+	fmt.Printf("\tmov [rax+16], rcx\n")
+	fmt.Printf("\tmov qword ptr [rax+24], 1\n")
+	fmt.Printf("\tmov qword ptr [rax+32], 0\n")
+	fmt.Printf("\tmov qword ptr [rax+40], 0\n")
+	fmt.Printf("\tmov qword ptr [rax+48], 0\n")
+	fmt.Printf("\tmov qword ptr [rax+56], %d\n", spanLarge)
+	fmt.Printf("\tmov rdx, [rax]\n")
+
+	// the object at rdx takes rcx bytes of the heap
+	fmt.Printf(".Lalloc.done:\n")
+	fmt.Printf("\tadd [rip+runtime.totalAlloc], rcx\n")
+	fmt.Printf("\tadd rcx, [rip+runtime.heapLive]\n")
+	fmt.Printf("\tmov [rip+runtime.heapLive], rcx\n")
+	fmt.Printf("\tcmp rcx, [rip+runtime.heapPeak]\n")
+	fmt.Printf("\tjbe .Lalloc.header\n")
+	fmt.Printf("\tmov [rip+runtime.heapPeak], rcx\n")
+	fmt.Printf(".Lalloc.header:\n")
+	fmt.Printf("\tmov rax, [rbp+24]\n")
+	fmt.Printf("\tor rax, %d\n", objAllocated)
+	fmt.Printf("\tmov [rdx], rax\n")
+	fmt.Printf("\tlea rax, [rdx+8]\n")
+	fmt.Printf("\tmov [rbp+32], rax\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+}
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Maps are hash tables of buckets with eight slots each. A map value
+// points to its header:
+//
+//	[0]  count      number of entries
+//	[8]  B          log2 of the number of buckets
+//	[16] buckets    array of 1<<B buckets
+//	[24] oldbuckets array being evacuated while the map grows, or 0
+//	[32] nevacuate  old buckets below this one are evacuated
+//	[40] seed       hash seed
+//
+// A bucket holds the tophash byte of each slot, which is 0 if the slot is
+// empty, 1 if its entry was deleted and otherwise the top byte of the hash,
+// then the evacuated flag, the keys, the elements and the overflow bucket.
+// When the load factor gets above 6.5 the table doubles and the entries
+// move to the new buckets a few at a time as the map is assigned to.
+//
+// The helpers take the map type descriptor in rdi, the header in rsi and
+// a pointer to the key in rdx.
+
+const hmapSize = 48
+
+// hiterSize is the size of a map iterator:
+//
+//	[0]  key        pointer to the current key, or 0 at the end
+//	[8]  elem       pointer to the current element
+//	[16] t          map type
+//	[24] h          map header
+//	[32] buckets    bucket array when the iteration started
+//	[40] B          log2 of its length
+//	[48] start      bucket the iteration started at
+//	[56] offset     slot each bucket is started at
+//	[64] i          number of buckets visited
+//	[72] bptr       current bucket
+//	[80] j          number of slots of bptr visited
+const hiterSize = 88
+
+func emitMapRuntime() {
+	fmt.Printf("\t.bss\n")
+	fmt.Printf("\t.align 8\n")
+	fmt.Printf("runtime.randState:\n")
+	fmt.Printf("\t.zero 8\n")
+
+	// the buckets and old buckets of a header are pointers
+	fmt.Printf("\t.section .rodata\n")
+	fmt.Printf("\t.align 8\n")
+	fmt.Printf("runtime.hmapGCData:\n")
+	fmt.Printf("\t.quad %d\n", hmapSize/8)
+	fmt.Printf("\t.quad 0xc\n")
+	fmt.Printf("\t.text\n")
+
+	emitFastrand()
+	emitMakemap()
+	emitMaplookup()
+	emitMapaccess()
+	emitMapassign()
+	emitMapinsert()
+	emitHashGrow()
+	emitGrowWork()
+	emitEvacuate()
+	emitMapdelete()
+	emitMapiterinit()
+	emitMapiternext()
+}
+
+// genRuntimeAlloc emits a call of runtime.alloc for size bytes with the
+// pointer bitmap data and leaves the memory in rax.
+func genRuntimeAlloc(size, data string) {
+	fmt.Printf("\tsub rsp, 8\n")
+	fmt.Printf("\tpush %s\n", data)
+	fmt.Printf("\tpush %s\n", size)
+	fmt.Printf("\tcall runtime.alloc\n")
+	fmt.Printf("\tadd rsp, 16\n")
+	fmt.Printf("\tpop rax\n")
+}
+
+// genKeyAddr sets dst to the address of key i of bucket b of the map type
+// t.
+func genKeyAddr(dst, t, b, i string) {
+	fmt.Printf("\tmov %s, %s\n", dst, i)
+	fmt.Printf("\timul %s, [%s]\n", dst, t)
+	fmt.Printf("\tlea %s, [%s+%s+16]\n", dst, b, dst)
+}
+
+// genElemAddr sets dst to the address of element i of bucket b of the map
+// type t. It uses r11.
+func genElemAddr(dst, t, b, i string) {
+	fmt.Printf("\tmov r11, %s\n", i)
+	fmt.Printf("\timul r11, [%s+8]\n", t)
+	fmt.Printf("\tadd r11, %s\n", b)
+	fmt.Printf("\tmov %s, [%s]\n", dst, t)
+	fmt.Printf("\tlea %s, [r11+%s*8+16]\n", dst, dst)
+}
+
+// genHash sets [rbp-32] to the hash of the key at rdx and [rbp-40] to its
+// tophash, given the map type at [rbp-8] and the header at [rbp-16].
+func genHash(name string) {
+	fmt.Printf("\tmov rdi, rdx\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tmov rsi, [rsi+40]\n")
+	fmt.Printf("\tmov rax, [rbp-8]\n")
+	fmt.Printf("\tcall [rax+24]\n")
+	fmt.Printf("\tmov [rbp-32], rax\n")
+	fmt.Printf("\tshr rax, 56\n")
+	fmt.Printf("\tcmp rax, 2\n")
+	fmt.Printf("\tjae .L%s.top\n", name)
+	fmt.Printf("\tadd rax, 2\n")
+	fmt.Printf(".L%s.top:\n", name)
+	fmt.Printf("\tmov [rbp-40], rax\n")
+}
+
+// genBucket sets rax to the bucket of hash in the array at [h+off] with
+// 1<<(B-shift) buckets, with the map type at [rbp-8] and the header at
+// [rbp-16].
+func genBucket(hash string, off, shift int) {
+	fmt.Printf("\tmov rdi, [rbp-16]\n")
+	fmt.Printf("\tmov rcx, [rdi+8]\n")
+	if shift > 0 {
+		fmt.Printf("\tsub rcx, %d\n", shift)
+	}
+	fmt.Printf("\tmov rdx, 1\n")
+	fmt.Printf("\tshl rdx, cl\n")
+	fmt.Printf("\tdec rdx\n")
+	fmt.Printf("\tand rdx, %s\n", hash)
+	fmt.Printf("\tmov rax, [rbp-8]\n")
+	fmt.Printf("\timul rdx, [rax+16]\n")
+	fmt.Printf("\tmov rax, [rdi+%d]\n", off)
+	fmt.Printf("\tadd rax, rdx\n")
+}
+
+// genMapPrologue sets up a frame of size bytes and saves rdi, rsi and rdx
+// at [rbp-8], [rbp-16] and [rbp-24].
+func genMapPrologue(size int) {
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tsub rsp, %d\n", size)
+	fmt.Printf("\tmov [rbp-8], rdi\n")
+	fmt.Printf("\tmov [rbp-16], rsi\n")
+	fmt.Printf("\tmov [rbp-24], rdx\n")
+}
+
+func genMapEpilogue() {
+	fmt.Printf("\tmov rsp, rbp\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitFastrand emits runtime.fastrand, which returns a pseudo-random number
+// in rax. The generator is seeded from the time stamp counter.
+func emitFastrand() {
+	fmt.Printf("runtime.fastrand:\n")
+	fmt.Printf("\tmov rax, [rip+runtime.randState]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjnz .Lfastrand.next\n")
+	fmt.Printf("\trdtsc\n")
+	fmt.Printf("\tshl rdx, 32\n")
+	fmt.Printf("\tor rax, rdx\n")
+	fmt.Printf("\tor rax, 1\n")
+	fmt.Printf(".Lfastrand.next:\n")
+	for _, s := range []string{"shl rdx, 13", "shr rdx, 7", "shl rdx, 17"} {
+		fmt.Printf("\tmov rdx, rax\n")
+		fmt.Printf("\t%s\n", s)
+		fmt.Printf("\txor rax, rdx\n")
+	}
+	fmt.Printf("\tmov [rip+runtime.randState], rax\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitMakemap emits runtime.makemap, which returns in rax a new map of
+// type rdi with room for rsi entries.
+func emitMakemap() {
+	fmt.Printf("runtime.makemap:\n")
+	fmt.Printf("\tmov rax, %d\n", maxAlloc)
+	fmt.Printf("\tcmp rsi, rax\n")
+	fmt.Printf("\tja .Lmakemap.size\n")
+	genMapPrologue(32)
+	fmt.Printf("\tlea rax, [rip+runtime.hmapGCData]\n")
+	genRuntimeAlloc(fmt.Sprint(hmapSize), "rax")
+	fmt.Printf("\tmov [rbp-24], rax\n")
+	fmt.Printf("\tcall runtime.fastrand\n")
+	fmt.Printf("\tmov rdi, [rbp-24]\n")
+	fmt.Printf("\tmov [rdi+40], rax\n")
+
+	// the smallest B that keeps the load factor at most 6.5, unless the
+	// buckets would be too large
+	fmt.Printf("\tmov rcx, 0\n")
+	fmt.Printf(".Lmakemap.loop:\n")
+	fmt.Printf("\tmov rax, 13\n")
+	fmt.Printf("\tshl rax, cl\n")
+	fmt.Printf("\tmov rdx, [rbp-16]\n")
+	fmt.Printf("\tadd rdx, rdx\n")
+	fmt.Printf("\tcmp rdx, rax\n")
+	fmt.Printf("\tjbe .Lmakemap.alloc\n")
+	fmt.Printf("\tmov rax, [rbp-8]\n")
+	fmt.Printf("\tmov rax, [rax+16]\n")
+	fmt.Printf("\tinc rcx\n")
+	fmt.Printf("\tshl rax, cl\n")
+	fmt.Printf("\tmov rdx, %d\n", maxAlloc)
+	fmt.Printf("\tcmp rax, rdx\n")
+	fmt.Printf("\tjbe .Lmakemap.loop\n")
+	fmt.Printf("\tdec rcx\n")
+	fmt.Printf(".Lmakemap.alloc:\n")
+	fmt.Printf("\tmov [rdi+8], rcx\n")
+	fmt.Printf("\tmov rax, [rbp-8]\n")
+	fmt.Printf("\tmov rax, [rax+16]\n")
+	fmt.Printf("\tshl rax, cl\n")
+	fmt.Printf("\tmov rdx, [rbp-8]\n")
+	genRuntimeAlloc("rax", "qword ptr [rdx+48]")
+	fmt.Printf("\tmov rdi, [rbp-24]\n")
+	fmt.Printf("\tmov [rdi+16], rax\n")
+	fmt.Printf("\tmov rax, rdi\n")
+	genMapEpilogue()
+
+	genRuntimeError(".Lmakemap.size", true, func() {
+		genWriteString("runtime error: makemap: size out of range")
+	})
+}
+
+// emitMaplookup emits runtime.maplookup, which returns the bucket holding
+// the key in rax and its slot in rcx, or 0 in rax if the key is missing.
+// Buckets that have not been evacuated yet are looked up in the old array.
+func emitMaplookup() {
+	fmt.Printf("runtime.maplookup:\n")
+	genMapPrologue(64)
+	genHash("maplookup")
+	fmt.Printf("\tmov rdi, [rbp-16]\n")
+	fmt.Printf("\tcmp qword ptr [rdi+24], 0\n")
+	fmt.Printf("\tje .Lmaplookup.new\n")
+	genBucket("[rbp-32]", 24, 1)
+	fmt.Printf("\tcmp byte ptr [rax+8], 0\n")
+	fmt.Printf("\tje .Lmaplookup.bucket\n")
+	fmt.Printf(".Lmaplookup.new:\n")
+	genBucket("[rbp-32]", 16, 0)
+
+	fmt.Printf(".Lmaplookup.bucket:\n")
+	fmt.Printf("\tmov [rbp-48], rax\n")
+	fmt.Printf("\tmov qword ptr [rbp-56], 0\n")
+	fmt.Printf(".Lmaplookup.slot:\n")
+	fmt.Printf("\tmov rax, [rbp-48]\n")
+	fmt.Printf("\tmov rcx, [rbp-56]\n")
+	fmt.Printf("\tcmp rcx, 8\n")
+	fmt.Printf("\tje .Lmaplookup.overflow\n")
+	fmt.Printf("\tinc qword ptr [rbp-56]\n")
+	fmt.Printf("\tmovzx rdx, byte ptr [rax+rcx]\n")
+	fmt.Printf("\tcmp rdx, [rbp-40]\n")
+	fmt.Printf("\tjne .Lmaplookup.slot\n")
+	fmt.Printf("\tmov r8, [rbp-8]\n")
+	genKeyAddr("rdi", "r8", "rax", "rcx")
+	fmt.Printf("\tmov rsi, [rbp-24]\n")
+	fmt.Printf("\tcall [r8+32]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lmaplookup.slot\n")
+	fmt.Printf("\tmov rax, [rbp-48]\n")
+	fmt.Printf("\tmov rcx, [rbp-56]\n")
+	fmt.Printf("\tdec rcx\n")
+	genMapEpilogue()
+
+	fmt.Printf(".Lmaplookup.overflow:\n")
+	fmt.Printf("\tmov rdx, [rbp-8]\n")
+	fmt.Printf("\tmov rdx, [rdx+16]\n")
+	fmt.Printf("\tmov rax, [rax+rdx-8]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjnz .Lmaplookup.bucket\n")
+	genMapEpilogue()
+}
+
+// emitMapaccess emits runtime.mapaccess, which returns a pointer to the
+// element of the key in rax and whether it is present in rcx. Missing keys
+// yield a pointer to the zero value.
+func emitMapaccess() {
+	fmt.Printf("runtime.mapaccess:\n")
+	fmt.Printf("\ttest rsi, rsi\n")
+	fmt.Printf("\tjz .Lmapaccess.missing\n")
+	fmt.Printf("\tcmp qword ptr [rsi], 0\n")
+	fmt.Printf("\tje .Lmapaccess.missing\n")
+	fmt.Printf("\tpush rdi\n")
+	fmt.Printf("\tcall runtime.maplookup\n")
+	fmt.Printf("\tpop rdi\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lmapaccess.missing\n")
+	genElemAddr("rax", "rdi", "rax", "rcx")
+	fmt.Printf("\tmov rcx, 1\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf(".Lmapaccess.missing:\n")
+	fmt.Printf("\tlea rax, [rip+runtime.zeroVal]\n")
+	fmt.Printf("\tmov rcx, 0\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitMapassign emits runtime.mapassign, which returns in rax a pointer to
+// the element of the key, adding the key if it is missing.
+func emitMapassign() {
+	fmt.Printf("runtime.mapassign:\n")
+	fmt.Printf("\ttest rsi, rsi\n")
+	fmt.Printf("\tjz .Lmapassign.nil\n")
+	genMapPrologue(32)
+	fmt.Printf(".Lmapassign.again:\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tmov rdx, [rbp-24]\n")
+	fmt.Printf("\tcmp qword ptr [rsi+24], 0\n")
+	fmt.Printf("\tje .Lmapassign.lookup\n")
+	fmt.Printf("\tcall runtime.growWork\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tmov rdx, [rbp-24]\n")
+	fmt.Printf(".Lmapassign.lookup:\n")
+	fmt.Printf("\tcall runtime.maplookup\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lmapassign.insert\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	genElemAddr("rax", "rdi", "rax", "rcx")
+	genMapEpilogue()
+
+	// grow if the new entry would put the load factor above 6.5 and the
+	// map is not growing already
+	fmt.Printf(".Lmapassign.insert:\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tcmp qword ptr [rsi+24], 0\n")
+	fmt.Printf("\tjne .Lmapassign.put\n")
+	fmt.Printf("\tmov rax, [rsi]\n")
+	fmt.Printf("\tinc rax\n")
+	fmt.Printf("\tadd rax, rax\n")
+	fmt.Printf("\tmov rcx, [rsi+8]\n")
+	fmt.Printf("\tmov rdx, 13\n")
+	fmt.Printf("\tshl rdx, cl\n")
+	fmt.Printf("\tcmp rax, rdx\n")
+	fmt.Printf("\tjbe .Lmapassign.put\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tcall runtime.hashGrow\n")
+	fmt.Printf("\tjmp .Lmapassign.again\n")
+	fmt.Printf(".Lmapassign.put:\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rdx, [rbp-24]\n")
+	fmt.Printf("\tcall runtime.mapinsert\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tinc qword ptr [rsi]\n")
+	genMapEpilogue()
+
+	genRuntimeError(".Lmapassign.nil", true, func() {
+		genWriteString("assignment to entry in nil map")
+	})
+}
+
+// emitMapinsert emits runtime.mapinsert, which stores a key that is not in
+// the map in a free slot of the current bucket array and returns a pointer
+// to its element in rax. It does not count the entry.
+func emitMapinsert() {
+	fmt.Printf("runtime.mapinsert:\n")
+	genMapPrologue(64)
+	genHash("mapinsert")
+	genBucket("[rbp-32]", 16, 0)
+	fmt.Printf(".Lmapinsert.bucket:\n")
+	fmt.Printf("\tmov rcx, 0\n")
+	fmt.Printf(".Lmapinsert.slot:\n")
+	fmt.Printf("\tcmp byte ptr [rax+rcx], 2\n")
+	fmt.Printf("\tjb .Lmapinsert.found\n")
+	fmt.Printf("\tinc rcx\n")
+	fmt.Printf("\tcmp rcx, 8\n")
+	fmt.Printf("\tjb .Lmapinsert.slot\n")
+	fmt.Printf("\tmov rdx, [rbp-8]\n")
+	fmt.Printf("\tmov rdx, [rdx+16]\n")
+	fmt.Printf("\tlea rdi, [rax+rdx-8]\n")
+	fmt.Printf("\tmov rax, [rdi]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjnz .Lmapinsert.bucket\n")
+	fmt.Printf("\tmov [rbp-48], rdi\n")
+	fmt.Printf("\tmov rax, [rbp-8]\n")
+	genRuntimeAlloc("rdx", "qword ptr [rax+48]")
+	fmt.Printf("\tmov rdi, [rbp-48]\n")
+	fmt.Printf("\tmov [rdi], rax\n")
+	fmt.Printf("\tmov rcx, 0\n")
+
+	fmt.Printf(".Lmapinsert.found:\n")
+	fmt.Printf("\tmov rdx, [rbp-40]\n")
+	fmt.Printf("\tmov [rax+rcx], dl\n")
+	fmt.Printf("\tmov [rbp-48], rax\n")
+	fmt.Printf("\tmov [rbp-56], rcx\n")
+	fmt.Printf("\tmov r8, [rbp-8]\n")
+	genKeyAddr("rdi", "r8", "rax", "rcx")
+	fmt.Printf("\tmov rsi, [rbp-24]\n")
+	fmt.Printf("\tmov rcx, [r8+40]\n")
+	fmt.Printf("\tcall runtime.memmove\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	genElemAddr("rax", "rdi", "[rbp-48]", "[rbp-56]")
+	genMapEpilogue()
+}
+
+// emitHashGrow emits runtime.hashGrow, which doubles the buckets of the
+// map. The entries stay in the old array until they are evacuated.
+func emitHashGrow() {
+	fmt.Printf("runtime.hashGrow:\n")
+	genMapPrologue(32)
+	fmt.Printf("\tmov rax, [rsi+16]\n")
+	fmt.Printf("\tmov [rsi+24], rax\n")
+	fmt.Printf("\tinc qword ptr [rsi+8]\n")
+	fmt.Printf("\tmov qword ptr [rsi+32], 0\n")
+	fmt.Printf("\tmov rcx, [rsi+8]\n")
+	fmt.Printf("\tmov rax, [rdi+16]\n")
+	fmt.Printf("\tshl rax, cl\n")
+	genRuntimeAlloc("rax", "qword ptr [rdi+48]")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tmov [rsi+16], rax\n")
+	genMapEpilogue()
+}
+
+// emitGrowWork emits runtime.growWork, which evacuates the old bucket of
+// the key, so that it can be updated in the new array, and one more.
+func emitGrowWork() {
+	fmt.Printf("runtime.growWork:\n")
+	genMapPrologue(48)
+	genHash("growWork")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tmov rcx, [rsi+8]\n")
+	fmt.Printf("\tdec rcx\n")
+	fmt.Printf("\tmov rdx, 1\n")
+	fmt.Printf("\tshl rdx, cl\n")
+	fmt.Printf("\tdec rdx\n")
+	fmt.Printf("\tand rdx, [rbp-32]\n")
+	fmt.Printf("\tcall runtime.evacuate\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tcmp qword ptr [rsi+24], 0\n")
+	fmt.Printf("\tje .LgrowWork.done\n")
+	fmt.Printf("\tmov rdx, [rsi+32]\n")
+	fmt.Printf("\tcall runtime.evacuate\n")
+	fmt.Printf(".LgrowWork.done:\n")
+	genMapEpilogue()
+}
+
+// emitEvacuate emits runtime.evacuate, which moves the entries of old
+// bucket rdx to the new array. The old bucket keeps its entries for
+// iterators that started before the map grew. When the last old bucket is
+// evacuated the growth is over.
+func emitEvacuate() {
+	fmt.Printf("runtime.evacuate:\n")
+	genMapPrologue(48)
+	fmt.Printf("\tmov rax, rdx\n")
+	fmt.Printf("\timul rax, [rdi+16]\n")
+	fmt.Printf("\tadd rax, [rsi+24]\n")
+	fmt.Printf("\tcmp byte ptr [rax+8], 0\n")
+	fmt.Printf("\tjne .Levacuate.advance\n")
+	fmt.Printf("\tmov [rbp-32], rax\n")
+	fmt.Printf(".Levacuate.bucket:\n")
+	fmt.Printf("\tmov qword ptr [rbp-40], 0\n")
+	fmt.Printf(".Levacuate.slot:\n")
+	fmt.Printf("\tmov rax, [rbp-32]\n")
+	fmt.Printf("\tmov rcx, [rbp-40]\n")
+	fmt.Printf("\tcmp byte ptr [rax+rcx], 2\n")
+	fmt.Printf("\tjb .Levacuate.next\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	genKeyAddr("rdx", "rdi", "rax", "rcx")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tcall runtime.mapinsert\n")
+	fmt.Printf("\tmov [rbp-48], rax\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	genElemAddr("rsi", "rdi", "[rbp-32]", "[rbp-40]")
+	fmt.Printf("\tmov rcx, [rdi+8]\n")
+	fmt.Printf("\tmov rdi, [rbp-48]\n")
+	fmt.Printf("\tcall runtime.memmove\n")
+	fmt.Printf(".Levacuate.next:\n")
+	fmt.Printf("\tinc qword ptr [rbp-40]\n")
+	fmt.Printf("\tcmp qword ptr [rbp-40], 8\n")
+	fmt.Printf("\tjb .Levacuate.slot\n")
+	fmt.Printf("\tmov rax, [rbp-32]\n")
+	fmt.Printf("\tmov rdx, [rbp-8]\n")
+	fmt.Printf("\tmov rdx, [rdx+16]\n")
+	fmt.Printf("\tmov rax, [rax+rdx-8]\n")
+	fmt.Printf("\tmov [rbp-32], rax\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjnz .Levacuate.bucket\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tmov rax, [rbp-24]\n")
+	fmt.Printf("\timul rax, [rdi+16]\n")
+	fmt.Printf("\tadd rax, [rsi+24]\n")
+	fmt.Printf("\tmov byte ptr [rax+8], 1\n")
+
+	fmt.Printf(".Levacuate.advance:\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tmov rax, [rbp-24]\n")
+	fmt.Printf("\tcmp rax, [rsi+32]\n")
+	fmt.Printf("\tjne .Levacuate.done\n")
+	fmt.Printf("\tmov rcx, [rsi+8]\n")
+	fmt.Printf("\tdec rcx\n")
+	fmt.Printf("\tmov rdx, 1\n")
+	fmt.Printf("\tshl rdx, cl\n")
+	fmt.Printf(".Levacuate.loop:\n")
+	fmt.Printf("\tinc rax\n")
+	fmt.Printf("\tcmp rax, rdx\n")
+	fmt.Printf("\tjae .Levacuate.finished\n")
+	fmt.Printf("\tmov rcx, rax\n")
+	fmt.Printf("\timul rcx, [rdi+16]\n")
+	fmt.Printf("\tadd rcx, [rsi+24]\n")
+	fmt.Printf("\tcmp byte ptr [rcx+8], 0\n")
+	fmt.Printf("\tjne .Levacuate.loop\n")
+	fmt.Printf("\tmov [rsi+32], rax\n")
+	genMapEpilogue()
+	fmt.Printf(".Levacuate.finished:\n")
+	fmt.Printf("\tmov [rsi+32], rax\n")
+	fmt.Printf("\tmov qword ptr [rsi+24], 0\n")
+	fmt.Printf(".Levacuate.done:\n")
+	genMapEpilogue()
+}
+
+// emitMapdelete emits runtime.mapdelete, which removes the key from the
+// map if it is there.
+func emitMapdelete() {
+	fmt.Printf("runtime.mapdelete:\n")
+	fmt.Printf("\ttest rsi, rsi\n")
+	fmt.Printf("\tjz .Lmapdelete.ret\n")
+	fmt.Printf("\tcmp qword ptr [rsi], 0\n")
+	fmt.Printf("\tje .Lmapdelete.ret\n")
+	genMapPrologue(48)
+	fmt.Printf("\tcmp qword ptr [rsi+24], 0\n")
+	fmt.Printf("\tje .Lmapdelete.lookup\n")
+	fmt.Printf("\tcall runtime.growWork\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tmov rdx, [rbp-24]\n")
+	fmt.Printf(".Lmapdelete.lookup:\n")
+	fmt.Printf("\tcall runtime.maplookup\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lmapdelete.done\n")
+	fmt.Printf("\tmov byte ptr [rax+rcx], 1\n")
+	fmt.Printf("\tmov [rbp-32], rax\n")
+	fmt.Printf("\tmov [rbp-40], rcx\n")
+	fmt.Printf("\tmov rdx, [rbp-8]\n")
+	genKeyAddr("rdi", "rdx", "rax", "rcx")
+	fmt.Printf("\tmov rcx, [rdx]\n")
+	fmt.Printf("\tmov rax, 0\n")
+	fmt.Printf("\trep stosb\n")
+	genElemAddr("rdi", "rdx", "[rbp-32]", "[rbp-40]")
+	fmt.Printf("\tmov rcx, [rdx+8]\n")
+	fmt.Printf("\trep stosb\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tdec qword ptr [rsi]\n")
+	fmt.Printf(".Lmapdelete.done:\n")
+	genMapEpilogue()
+	fmt.Printf(".Lmapdelete.ret:\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitMapiterinit emits runtime.mapiterinit, which starts the iterator at
+// rdx over the map at a random bucket and slot and moves it to the first
+// entry. A map that is growing is evacuated first.
+func emitMapiterinit() {
+	fmt.Printf("runtime.mapiterinit:\n")
+	genMapPrologue(32)
+	fmt.Printf("\tmov qword ptr [rdx], 0\n")
+	fmt.Printf("\tmov [rdx+16], rdi\n")
+	fmt.Printf("\tmov [rdx+24], rsi\n")
+	fmt.Printf("\ttest rsi, rsi\n")
+	fmt.Printf("\tjz .Lmapiterinit.done\n")
+	fmt.Printf("\tcmp qword ptr [rsi], 0\n")
+	fmt.Printf("\tje .Lmapiterinit.done\n")
+	fmt.Printf(".Lmapiterinit.grow:\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rsi, [rbp-16]\n")
+	fmt.Printf("\tcmp qword ptr [rsi+24], 0\n")
+	fmt.Printf("\tje .Lmapiterinit.start\n")
+	fmt.Printf("\tmov rdx, [rsi+32]\n")
+	fmt.Printf("\tcall runtime.evacuate\n")
+	fmt.Printf("\tjmp .Lmapiterinit.grow\n")
+
+	fmt.Printf(".Lmapiterinit.start:\n")
+	fmt.Printf("\tmov rdi, [rbp-24]\n")
+	fmt.Printf("\tmov rax, [rsi+16]\n")
+	fmt.Printf("\tmov [rdi+32], rax\n")
+	fmt.Printf("\tmov rax, [rsi+8]\n")
+	fmt.Printf("\tmov [rdi+40], rax\n")
+	fmt.Printf("\tcall runtime.fastrand\n")
+	fmt.Printf("\tmov rdi, [rbp-24]\n")
+	fmt.Printf("\tmov rcx, [rdi+40]\n")
+	fmt.Printf("\tmov rdx, 1\n")
+	fmt.Printf("\tshl rdx, cl\n")
+	fmt.Printf("\tdec rdx\n")
+	fmt.Printf("\tand rdx, rax\n")
+	fmt.Printf("\tmov [rdi+48], rdx\n")
+	fmt.Printf("\tshr rax, 32\n")
+	fmt.Printf("\tand rax, 7\n")
+	fmt.Printf("\tmov [rdi+56], rax\n")
+	fmt.Printf("\tmov qword ptr [rdi+64], -1\n")
+	fmt.Printf("\tmov qword ptr [rdi+72], 0\n")
+	fmt.Printf("\tcall runtime.mapiternext\n")
+	fmt.Printf(".Lmapiterinit.done:\n")
+	genMapEpilogue()
+}
+
+// emitMapiternext emits runtime.mapiternext, which moves the iterator at
+// rdi to the next entry. If the map grew since the iteration started, the
+// entries are still read from the old array, but each is looked up again
+// to skip deleted keys and find the current element.
+func emitMapiternext() {
+	fmt.Printf("runtime.mapiternext:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tpush rdi\n")
+	fmt.Printf(".Lmapiternext.slot:\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov rax, [rdi+72]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lmapiternext.bucket\n")
+	fmt.Printf("\tmov rcx, [rdi+80]\n")
+	fmt.Printf("\tcmp rcx, 8\n")
+	fmt.Printf("\tjae .Lmapiternext.overflow\n")
+	fmt.Printf("\tinc qword ptr [rdi+80]\n")
+	fmt.Printf("\tadd rcx, [rdi+56]\n")
+	fmt.Printf("\tand rcx, 7\n")
+	fmt.Printf("\tcmp byte ptr [rax+rcx], 2\n")
+	fmt.Printf("\tjb .Lmapiternext.slot\n")
+	fmt.Printf("\tmov r8, [rdi+16]\n")
+	genKeyAddr("rdx", "r8", "rax", "rcx")
+	fmt.Printf("\tmov [rdi], rdx\n")
+	genElemAddr("rsi", "r8", "rax", "rcx")
+	fmt.Printf("\tmov [rdi+8], rsi\n")
+	fmt.Printf("\tmov rsi, [rdi+24]\n")
+	fmt.Printf("\tmov rax, [rsi+16]\n")
+	fmt.Printf("\tcmp rax, [rdi+32]\n")
+	fmt.Printf("\tje .Lmapiternext.done\n")
+	fmt.Printf("\tmov rdi, r8\n")
+	fmt.Printf("\tcall runtime.maplookup\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lmapiternext.slot\n")
+	fmt.Printf("\tmov rdi, [rbp-8]\n")
+	fmt.Printf("\tmov r8, [rdi+16]\n")
+	genElemAddr("rsi", "r8", "rax", "rcx")
+	fmt.Printf("\tmov [rdi+8], rsi\n")
+	fmt.Printf("\tjmp .Lmapiternext.done\n")
+
+	fmt.Printf(".Lmapiternext.overflow:\n")
+	fmt.Printf("\tmov rdx, [rdi+16]\n")
+	fmt.Printf("\tmov rdx, [rdx+16]\n")
+	fmt.Printf("\tmov rax, [rax+rdx-8]\n")
+	fmt.Printf("\tmov [rdi+72], rax\n")
+	fmt.Printf("\tmov qword ptr [rdi+80], 0\n")
+	fmt.Printf("\tjmp .Lmapiternext.slot\n")
+
+	fmt.Printf(".Lmapiternext.bucket:\n")
+	fmt.Printf("\tmov rax, [rdi+64]\n")
+	fmt.Printf("\tinc rax\n")
+	fmt.Printf("\tmov [rdi+64], rax\n")
+	fmt.Printf("\tmov rcx, [rdi+40]\n")
+	fmt.Printf("\tmov rdx, 1\n")
+	fmt.Printf("\tshl rdx, cl\n")
+	fmt.Printf("\tcmp rax, rdx\n")
+	fmt.Printf("\tjae .Lmapiternext.end\n")
+	fmt.Printf("\tdec rdx\n")
+	fmt.Printf("\tadd rax, [rdi+48]\n")
+	fmt.Printf("\tand rax, rdx\n")
+	fmt.Printf("\tmov rdx, [rdi+16]\n")
+	fmt.Printf("\timul rax, [rdx+16]\n")
+	fmt.Printf("\tadd rax, [rdi+32]\n")
+	fmt.Printf("\tmov [rdi+72], rax\n")
+	fmt.Printf("\tmov qword ptr [rdi+80], 0\n")
+	fmt.Printf("\tjmp .Lmapiternext.slot\n")
+	fmt.Printf(".Lmapiternext.end:\n")
+	fmt.Printf("\tmov qword ptr [rdi], 0\n")
+	fmt.Printf(".Lmapiternext.done:\n")
+	genMapEpilogue()
+}
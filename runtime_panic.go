@@ -0,0 +1,509 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A panic runs the pending deferred calls of every frame on the stack,
+// innermost first. The stack is walked along the frame pointers as by the
+// collector, and the return address into each frame finds in the frame map
+// of its function the routine making the deferred calls, which is called
+// on the frame. If a deferred call recovers the panic, the frame resumes
+// at its epilogue, which makes the rest of its deferred calls and returns
+// normally. Otherwise the program reports the panic and exits.
+//
+// A panic record lives in the frame of runtime.gopanic and holds the
+// value, the record of the panic it interrupted, whether it is recovered,
+// and the frame whose deferred calls are being made. runtime.panicking is
+// the current record.
+//
+// Run-time errors are panics whose value is of type runtime.errorType. It
+// points to an object holding the routine that writes the message and the
+// registers the message is made from.
+
+// kindRuntimeError is the kind of runtime.errorType, which no type of the
+// program has.
+const kindRuntimeError = 64
+
+// emitPanicRuntime emits the routines that panic, recover and report
+// panics.
+func emitPanicRuntime() {
+	fmt.Printf("\t.bss\n")
+	fmt.Printf("\t.align 8\n")
+	fmt.Printf("runtime.panicking:\n")
+	fmt.Printf("\t.zero 8\n")
+	name := "runtime.Error"
+	fmt.Printf("\t.section .data.rel.ro\n")
+	fmt.Printf("\t.align 8\n")
+	fmt.Printf("runtime.errorType:\n")
+	fmt.Printf("\t.quad 40\n")
+	fmt.Printf("\t.quad %d\n", kindRuntimeError)
+	fmt.Printf("\t.quad %s\n", stringLabel(name))
+	fmt.Printf("\t.quad %d\n", len(name))
+	fmt.Printf("\t.quad 0\n")
+	fmt.Printf("\t.text\n")
+
+	emitGopanic()
+	emitGorecover()
+	emitPanicError()
+	emitPrintPanics()
+	emitPrintPanicValue()
+	emitWriteFloat()
+	emitWriteHex()
+
+	genRuntimeError("runtime.panicnil", false, func() {
+		genWriteString("panic called with nil argument")
+	})
+	genRuntimeError("runtime.panicDivide", true, func() {
+		genWriteString("runtime error: integer divide by zero")
+	})
+	genRuntimeError("runtime.panicShift", true, func() {
+		genWriteString("runtime error: negative shift amount")
+	})
+}
+
+// emitGopanic emits runtime.gopanic(v interface{}), which panics with v.
+func emitGopanic() {
+	fmt.Printf("runtime.gopanic:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tcmp qword ptr [rbp+16], 0\n")
+	fmt.Printf("\tje runtime.panicnil\n")
+
+	// the record is at rbp-40 and rbp-48 is the frame pointer of the
+	// callee of the frame
+	fmt.Printf("\tsub rsp, 48\n")
+	fmt.Printf("\tmov rax, [rbp+16]\n")
+	fmt.Printf("\tmov [rbp-40], rax\n")
+	fmt.Printf("\tmov rax, [rbp+24]\n")
+	fmt.Printf("\tmov [rbp-32], rax\n")
+	fmt.Printf("\tmov rax, [rip+runtime.panicking]\n")
+	fmt.Printf("\tmov [rbp-24], rax\n")
+	fmt.Printf("\tmov qword ptr [rbp-16], 0\n")
+	fmt.Printf("\tmov qword ptr [rbp-8], 0\n")
+	fmt.Printf("\tlea rax, [rbp-40]\n")
+	fmt.Printf("\tmov [rip+runtime.panicking], rax\n")
+	fmt.Printf("\tmov [rbp-48], rbp\n")
+
+	fmt.Printf(".Lgopanic.frame:\n")
+	fmt.Printf("\tmov rax, [rbp-48]\n")
+	fmt.Printf("\tmov rcx, [rax]\n")
+	fmt.Printf("\tcmp rcx, [rip+runtime.stackTop]\n")
+	fmt.Printf("\tje .Lgopanic.fatal\n")
+	fmt.Printf("\tmov [rbp-48], rcx\n")
+	fmt.Printf("\tmov rax, [rax+8]\n")
+	fmt.Printf("\tcall runtime.findStackMap\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lgopanic.frame\n")
+	fmt.Printf("\tcmp qword ptr [rax+32], 0\n")
+	fmt.Printf("\tje .Lgopanic.frame\n")
+	fmt.Printf("\tmov rcx, [rbp-48]\n")
+	fmt.Printf("\tmov [rbp-8], rcx\n")
+	fmt.Printf("\tpush rax\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rcx\n")
+	fmt.Printf("\tcall [rax+32]\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tpop rax\n")
+	fmt.Printf("\tcmp qword ptr [rbp-16], 0\n")
+	fmt.Printf("\tje .Lgopanic.frame\n")
+
+	// The panics interrupted by this one are over too if their records
+	// are in the frames that are left.
+	fmt.Printf("\tmov rcx, [rbp-8]\n")
+	fmt.Printf("\tmov rdx, rcx\n")
+	fmt.Printf("\tsub rdx, [rax]\n")
+	fmt.Printf("\tmov rsi, [rbp-24]\n")
+	fmt.Printf(".Lgopanic.drop:\n")
+	fmt.Printf("\ttest rsi, rsi\n")
+	fmt.Printf("\tjz .Lgopanic.resume\n")
+	fmt.Printf("\tcmp rsi, rdx\n")
+	fmt.Printf("\tjae .Lgopanic.resume\n")
+	fmt.Printf("\tmov rsi, [rsi+16]\n")
+	fmt.Printf("\tjmp .Lgopanic.drop\n")
+	fmt.Printf(".Lgopanic.resume:\n")
+	fmt.Printf("\tmov [rip+runtime.panicking], rsi\n")
+	fmt.Printf("\tmov rsp, rdx\n")
+	fmt.Printf("\tmov rbp, rcx\n")
+	fmt.Printf("\tjmp [rax+40]\n")
+
+	// the panics are reported oldest first, then the functions on the
+	// stack innermost first
+	fmt.Printf(".Lgopanic.fatal:\n")
+	fmt.Printf("\tmov rax, [rip+runtime.panicking]\n")
+	fmt.Printf("\tcall runtime.printpanics\n")
+	genWriteString("\ngoroutine 1 [running]:\n")
+	fmt.Printf("\tmov rax, rbp\n")
+	fmt.Printf(".Lgopanic.trace:\n")
+	fmt.Printf("\tmov rcx, [rax]\n")
+	fmt.Printf("\tcmp rcx, [rip+runtime.stackTop]\n")
+	fmt.Printf("\tje runtime.exit2\n")
+	fmt.Printf("\tpush rcx\n")
+	fmt.Printf("\tmov rax, [rax+8]\n")
+	fmt.Printf("\tcall runtime.findStackMap\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lgopanic.next\n")
+	fmt.Printf("\tmov rsi, [rax+48]\n")
+	fmt.Printf("\tmov rdx, [rax+56]\n")
+	fmt.Printf("\tcall runtime.write\n")
+	genWriteString("()\n")
+	fmt.Printf(".Lgopanic.next:\n")
+	fmt.Printf("\tpop rax\n")
+	fmt.Printf("\tjmp .Lgopanic.trace\n")
+}
+
+// emitGorecover emits runtime.gorecover, which recovers the current panic
+// for the function whose frame pointer is rax. It does only if the function
+// is called by the frame making the deferred calls, either directly or
+// through the function of a defer record. The value is returned in rax and
+// rdx, and is nil if nothing is recovered.
+func emitGorecover() {
+	fmt.Printf("runtime.gorecover:\n")
+	fmt.Printf("\tmov rcx, [rip+runtime.panicking]\n")
+	fmt.Printf("\ttest rcx, rcx\n")
+	fmt.Printf("\tjz .Lgorecover.none\n")
+	fmt.Printf("\tcmp qword ptr [rcx+24], 0\n")
+	fmt.Printf("\tjne .Lgorecover.none\n")
+	fmt.Printf("\tmov rdx, [rax]\n")
+	fmt.Printf("\tcmp rdx, [rcx+32]\n")
+	fmt.Printf("\tje .Lgorecover.ok\n")
+	fmt.Printf("\tpush rcx\n")
+	fmt.Printf("\tpush rax\n")
+	fmt.Printf("\tmov rax, [rax+8]\n")
+	fmt.Printf("\tcall runtime.findStackMap\n")
+	fmt.Printf("\tmov rdx, rax\n")
+	fmt.Printf("\tpop rax\n")
+	fmt.Printf("\tpop rcx\n")
+	fmt.Printf("\ttest rdx, rdx\n")
+	fmt.Printf("\tjz .Lgorecover.none\n")
+	fmt.Printf("\tcmp qword ptr [rdx+64], 0\n")
+	fmt.Printf("\tje .Lgorecover.none\n")
+	fmt.Printf("\tmov rdx, [rax]\n")
+	fmt.Printf("\tmov rdx, [rdx]\n")
+	fmt.Printf("\tcmp rdx, [rcx+32]\n")
+	fmt.Printf("\tjne .Lgorecover.none\n")
+	fmt.Printf(".Lgorecover.ok:\n")
+	fmt.Printf("\tmov qword ptr [rcx+24], 1\n")
+	fmt.Printf("\tmov rax, [rcx]\n")
+	fmt.Printf("\tmov rdx, [rcx+8]\n")
+	fmt.Printf("\tret\n")
+	fmt.Printf(".Lgorecover.none:\n")
+	fmt.Printf("\tmov rax, 0\n")
+	fmt.Printf("\tmov rdx, 0\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitPanicError emits runtime.panicError, which panics with a run-time
+// error written by the routine in r8 from rax, rcx, rdx and rsi.
+func emitPanicError() {
+	regs := []string{"r8", "rax", "rcx", "rdx", "rsi"}
+	fmt.Printf("runtime.panicError:\n")
+	for i := len(regs) - 1; i >= 0; i-- {
+		fmt.Printf("\tpush %s\n", regs[i])
+	}
+	genRuntimeAlloc("40", "0")
+	for i := range regs {
+		fmt.Printf("\tpop qword ptr [rax+%d]\n", 8*i)
+	}
+	fmt.Printf("\tpush rax\n")
+	fmt.Printf("\tlea rax, [rip+runtime.errorType]\n")
+	fmt.Printf("\tpush rax\n")
+	fmt.Printf("\tcall runtime.gopanic\n")
+}
+
+// genRuntimeError emits the routine name, which panics with a run-time
+// error. Unless frame is set, it is jumped to from a routine with a frame
+// of its own. print writes the message from the error object at [rsp],
+// which holds rax at offset 8, rcx at 16, rdx at 24 and rsi at 32.
+func genRuntimeError(name string, frame bool, print func()) {
+	fmt.Printf("%s:\n", name)
+	if frame {
+		fmt.Printf("\tpush rbp\n")
+		fmt.Printf("\tmov rbp, rsp\n")
+	}
+	fmt.Printf("\tlea r8, [rip+%s.error]\n", name)
+	fmt.Printf("\tjmp runtime.panicError\n")
+	fmt.Printf("%s.error:\n", name)
+	fmt.Printf("\tpush rax\n")
+	print()
+	fmt.Printf("\tpop rax\n")
+	fmt.Printf("\tret\n")
+}
+
+// genWriteErrorInt writes the register of the error at [rsp] that is at
+// off in decimal.
+func genWriteErrorInt(off int) {
+	fmt.Printf("\tmov rax, [rsp]\n")
+	fmt.Printf("\tmov rax, [rax+%d]\n", off)
+	fmt.Printf("\tcall runtime.writeInt\n")
+}
+
+// emitPrintPanics emits runtime.printpanics, which writes the panic of the
+// record in rax after those it interrupted.
+func emitPrintPanics() {
+	fmt.Printf("runtime.printpanics:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tpush rax\n")
+	fmt.Printf("\tmov rax, [rax+16]\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjz .Lprintpanics.self\n")
+	fmt.Printf("\tcall runtime.printpanics\n")
+	genWriteString("\t")
+	fmt.Printf(".Lprintpanics.self:\n")
+	genWriteString("panic: ")
+	fmt.Printf("\tmov rcx, [rbp-8]\n")
+	fmt.Printf("\tmov rax, [rcx]\n")
+	fmt.Printf("\tmov rdx, [rcx+8]\n")
+	fmt.Printf("\tcall runtime.printpanicval\n")
+	fmt.Printf("\tmov rcx, [rbp-8]\n")
+	fmt.Printf("\tcmp qword ptr [rcx+24], 0\n")
+	fmt.Printf("\tje .Lprintpanics.end\n")
+	genWriteString(" [recovered]")
+	fmt.Printf(".Lprintpanics.end:\n")
+	genWriteString("\n")
+	fmt.Printf("\tmov rsp, rbp\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitPrintPanicValue emits runtime.printpanicval, which writes the value
+// with type descriptor rax and data word rdx as the Go toolchain does:
+// booleans and numbers as such, wrapped in the type name if it is a
+// defined type, and other values as the type and the data word.
+func emitPrintPanicValue() {
+	fmt.Printf("runtime.printpanicval:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tpush rax\n")
+	fmt.Printf("\tpush rdx\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjnz .Lprintpanicval.type\n")
+	genWriteString("nil")
+	fmt.Printf("\tjmp .Lprintpanicval.done\n")
+
+	fmt.Printf(".Lprintpanicval.type:\n")
+	fmt.Printf("\tmov rcx, [rax+8]\n")
+	fmt.Printf("\tcmp rcx, %d\n", kindRuntimeError)
+	fmt.Printf("\tjne .Lprintpanicval.basic\n")
+	fmt.Printf("\tmov rax, rdx\n")
+	fmt.Printf("\tcall [rax]\n")
+	fmt.Printf("\tjmp .Lprintpanicval.done\n")
+
+	fmt.Printf(".Lprintpanicval.basic:\n")
+	fmt.Printf("\tcmp rcx, %d\n", typeKindBool)
+	fmt.Printf("\tja .Lprintpanicval.other\n")
+	fmt.Printf("\tmov rsi, [rax+16]\n")
+	fmt.Printf("\tcmp dword ptr [rsi], 0x6e69616d\n") // "main"
+	fmt.Printf("\tje .Lprintpanicval.named\n")
+	fmt.Printf("\tcall .Lprintpanicval.value\n")
+	fmt.Printf("\tjmp .Lprintpanicval.done\n")
+	fmt.Printf(".Lprintpanicval.named:\n")
+	fmt.Printf("\tcall runtime.writeType\n")
+	genWriteString("(")
+	fmt.Printf("\tcall .Lprintpanicval.value\n")
+	genWriteString(")")
+	fmt.Printf("\tjmp .Lprintpanicval.done\n")
+
+	fmt.Printf(".Lprintpanicval.other:\n")
+	genWriteString("(")
+	fmt.Printf("\tmov rax, [rbp-8]\n")
+	fmt.Printf("\tcall runtime.writeType\n")
+	genWriteString(") ")
+	fmt.Printf("\tmov rax, [rbp-16]\n")
+	fmt.Printf("\tcall runtime.writeHex\n")
+	fmt.Printf(".Lprintpanicval.done:\n")
+	fmt.Printf("\tmov rsp, rbp\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+
+	// the boxed boolean or number, on the frame of runtime.printpanicval
+	fmt.Printf(".Lprintpanicval.value:\n")
+	fmt.Printf("\tmov rax, [rbp-8]\n")
+	fmt.Printf("\tmov rcx, [rax+8]\n")
+	fmt.Printf("\tmov rdx, [rbp-16]\n")
+	fmt.Printf("\tcmp rcx, %d\n", typeKindBool)
+	fmt.Printf("\tje .Lprintpanicval.bool\n")
+	fmt.Printf("\tcmp rcx, %d\n", typeKindFloat32)
+	fmt.Printf("\tje .Lprintpanicval.float32\n")
+	fmt.Printf("\tcmp rcx, %d\n", typeKindFloat64)
+	fmt.Printf("\tje .Lprintpanicval.float64\n")
+
+	// an integer is shifted to the top of rax and back
+	fmt.Printf("\tmov r8, rcx\n")
+	fmt.Printf("\tmov ecx, 8\n")
+	fmt.Printf("\tsub rcx, [rax]\n")
+	fmt.Printf("\tshl ecx, 3\n")
+	fmt.Printf("\tmov rax, [rdx]\n")
+	fmt.Printf("\tshl rax, cl\n")
+	fmt.Printf("\tcmp r8, %d\n", typeKindInt64)
+	fmt.Printf("\tja .Lprintpanicval.unsigned\n")
+	fmt.Printf("\tsar rax, cl\n")
+	fmt.Printf("\tjmp runtime.writeInt\n")
+	fmt.Printf(".Lprintpanicval.unsigned:\n")
+	fmt.Printf("\tshr rax, cl\n")
+	fmt.Printf("\tjmp runtime.writeUint\n")
+
+	fmt.Printf(".Lprintpanicval.bool:\n")
+	fmt.Printf("\tcmp byte ptr [rdx], 0\n")
+	fmt.Printf("\tje .Lprintpanicval.false\n")
+	genWriteString("true")
+	fmt.Printf("\tret\n")
+	fmt.Printf(".Lprintpanicval.false:\n")
+	genWriteString("false")
+	fmt.Printf("\tret\n")
+
+	fmt.Printf(".Lprintpanicval.float32:\n")
+	fmt.Printf("\tcvtss2sd xmm0, dword ptr [rdx]\n")
+	fmt.Printf("\tjmp runtime.writeFloat\n")
+	fmt.Printf(".Lprintpanicval.float64:\n")
+	fmt.Printf("\tmovsd xmm0, qword ptr [rdx]\n")
+	fmt.Printf("\tjmp runtime.writeFloat\n")
+}
+
+// emitWriteFloat emits runtime.writeFloat, which writes xmm0 with seven
+// significant digits and a three-digit exponent, like +1.500000e+000, as
+// the Go toolchain prints floats.
+func emitWriteFloat() {
+	fmt.Printf("runtime.writeFloat:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tsub rsp, 16\n")
+	fmt.Printf("\tucomisd xmm0, xmm0\n")
+	fmt.Printf("\tjp .LwriteFloat.nan\n")
+	fmt.Printf("\txorpd xmm2, xmm2\n")
+	fmt.Printf("\tmovapd xmm1, xmm0\n")
+	fmt.Printf("\taddsd xmm1, xmm0\n")
+	fmt.Printf("\tucomisd xmm1, xmm0\n")
+	fmt.Printf("\tjne .LwriteFloat.finite\n")
+	fmt.Printf("\tucomisd xmm0, xmm2\n")
+	fmt.Printf("\tje .LwriteFloat.finite\n")
+	fmt.Printf("\tja .LwriteFloat.pinf\n")
+	genWriteString("-Inf")
+	fmt.Printf("\tjmp .LwriteFloat.done\n")
+	fmt.Printf(".LwriteFloat.pinf:\n")
+	genWriteString("+Inf")
+	fmt.Printf("\tjmp .LwriteFloat.done\n")
+	fmt.Printf(".LwriteFloat.nan:\n")
+	genWriteString("NaN")
+	fmt.Printf("\tjmp .LwriteFloat.done\n")
+
+	// the buffer of 14 bytes is at rbp-16 and r8 is the exponent
+	fmt.Printf(".LwriteFloat.finite:\n")
+	fmt.Printf("\tmov byte ptr [rbp-16], '+'\n")
+	fmt.Printf("\tmov r8, 0\n")
+	fmt.Printf("\tmovq rax, xmm0\n")
+	fmt.Printf("\ttest rax, rax\n")
+	fmt.Printf("\tjns .LwriteFloat.positive\n")
+	fmt.Printf("\tmov byte ptr [rbp-16], '-'\n")
+	fmt.Printf("\tbtr rax, 63\n")
+	fmt.Printf("\tmovq xmm0, rax\n")
+	fmt.Printf(".LwriteFloat.positive:\n")
+	fmt.Printf("\tmov rax, 0x4024000000000000\n") // 10
+	fmt.Printf("\tmovq xmm3, rax\n")
+	fmt.Printf("\tucomisd xmm0, xmm2\n")
+	fmt.Printf("\tje .LwriteFloat.digits\n")
+
+	// scale the value to [1, 10)
+	fmt.Printf("\tmov rax, 0x3ff0000000000000\n") // 1
+	fmt.Printf("\tmovq xmm4, rax\n")
+	fmt.Printf(".LwriteFloat.up:\n")
+	fmt.Printf("\tucomisd xmm0, xmm3\n")
+	fmt.Printf("\tjb .LwriteFloat.down\n")
+	fmt.Printf("\tinc r8\n")
+	fmt.Printf("\tdivsd xmm0, xmm3\n")
+	fmt.Printf("\tjmp .LwriteFloat.up\n")
+	fmt.Printf(".LwriteFloat.down:\n")
+	fmt.Printf("\tucomisd xmm0, xmm4\n")
+	fmt.Printf("\tjae .LwriteFloat.round\n")
+	fmt.Printf("\tdec r8\n")
+	fmt.Printf("\tmulsd xmm0, xmm3\n")
+	fmt.Printf("\tjmp .LwriteFloat.down\n")
+
+	// round at the seventh digit
+	fmt.Printf(".LwriteFloat.round:\n")
+	fmt.Printf("\tmov rax, 0x4014000000000000\n") // 5
+	fmt.Printf("\tmovq xmm1, rax\n")
+	fmt.Printf("\tmov ecx, 7\n")
+	fmt.Printf(".LwriteFloat.half:\n")
+	fmt.Printf("\tdivsd xmm1, xmm3\n")
+	fmt.Printf("\tdec ecx\n")
+	fmt.Printf("\tjnz .LwriteFloat.half\n")
+	fmt.Printf("\taddsd xmm0, xmm1\n")
+	fmt.Printf("\tucomisd xmm0, xmm3\n")
+	fmt.Printf("\tjb .LwriteFloat.digits\n")
+	fmt.Printf("\tinc r8\n")
+	fmt.Printf("\tdivsd xmm0, xmm3\n")
+
+	fmt.Printf(".LwriteFloat.digits:\n")
+	fmt.Printf("\tlea rdi, [rbp-15]\n")
+	fmt.Printf(".LwriteFloat.digit:\n")
+	fmt.Printf("\tcvttsd2si rax, xmm0\n")
+	fmt.Printf("\tcvtsi2sd xmm1, rax\n")
+	fmt.Printf("\tsubsd xmm0, xmm1\n")
+	fmt.Printf("\tmulsd xmm0, xmm3\n")
+	fmt.Printf("\tadd al, '0'\n")
+	fmt.Printf("\tmov [rdi], al\n")
+	fmt.Printf("\tinc rdi\n")
+	fmt.Printf("\tlea rax, [rbp-14]\n")
+	fmt.Printf("\tcmp rdi, rax\n")
+	fmt.Printf("\tjne .LwriteFloat.next\n")
+	fmt.Printf("\tinc rdi\n")
+	fmt.Printf(".LwriteFloat.next:\n")
+	fmt.Printf("\tlea rax, [rbp-7]\n")
+	fmt.Printf("\tcmp rdi, rax\n")
+	fmt.Printf("\tjb .LwriteFloat.digit\n")
+	fmt.Printf("\tmov byte ptr [rbp-14], '.'\n")
+	fmt.Printf("\tmov byte ptr [rbp-7], 'e'\n")
+	fmt.Printf("\tmov byte ptr [rbp-6], '+'\n")
+	fmt.Printf("\ttest r8, r8\n")
+	fmt.Printf("\tjns .LwriteFloat.exp\n")
+	fmt.Printf("\tneg r8\n")
+	fmt.Printf("\tmov byte ptr [rbp-6], '-'\n")
+	fmt.Printf(".LwriteFloat.exp:\n")
+	fmt.Printf("\tmov rax, r8\n")
+	fmt.Printf("\tmov ecx, 10\n")
+	for _, off := range []int{-3, -4, -5} {
+		fmt.Printf("\tmov edx, 0\n")
+		fmt.Printf("\tdiv rcx\n")
+		fmt.Printf("\tadd dl, '0'\n")
+		fmt.Printf("\tmov [rbp%+d], dl\n", off)
+	}
+	fmt.Printf("\tlea rsi, [rbp-16]\n")
+	fmt.Printf("\tmov rdx, 14\n")
+	fmt.Printf("\tcall runtime.write\n")
+	fmt.Printf(".LwriteFloat.done:\n")
+	fmt.Printf("\tmov rsp, rbp\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+}
+
+// emitWriteHex emits runtime.writeHex, which writes rax in hexadecimal
+// with a 0x prefix.
+func emitWriteHex() {
+	fmt.Printf("runtime.writeHex:\n")
+	fmt.Printf("\tpush rbp\n")
+	fmt.Printf("\tmov rbp, rsp\n")
+	fmt.Printf("\tsub rsp, 32\n")
+	fmt.Printf("\tmov rsi, rbp\n")
+	fmt.Printf(".LwriteHex.loop:\n")
+	fmt.Printf("\tmov edx, eax\n")
+	fmt.Printf("\tand edx, 15\n")
+	fmt.Printf("\tadd dl, '0'\n")
+	fmt.Printf("\tcmp dl, '9'\n")
+	fmt.Printf("\tjbe .LwriteHex.digit\n")
+	fmt.Printf("\tadd dl, 39\n")
+	fmt.Printf(".LwriteHex.digit:\n")
+	fmt.Printf("\tdec rsi\n")
+	fmt.Printf("\tmov [rsi], dl\n")
+	fmt.Printf("\tshr rax, 4\n")
+	fmt.Printf("\tjnz .LwriteHex.loop\n")
+	fmt.Printf("\tsub rsi, 2\n")
+	fmt.Printf("\tmov word ptr [rsi], 0x7830\n") // "0x"
+	fmt.Printf("\tmov rdx, rbp\n")
+	fmt.Printf("\tsub rdx, rsi\n")
+	fmt.Printf("\tcall runtime.write\n")
+	fmt.Printf("\tmov rsp, rbp\n")
+	fmt.Printf("\tpop rbp\n")
+	fmt.Printf("\tret\n")
+}
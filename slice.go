@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"go/constant"
+)
+
+// A slice value is three words: a pointer to its first element, its length
+// and its capacity. Indexing and slicing are checked against the bounds at
+// run time.
+
+// indexedType returns the type of the array or slice indexed through a
+// value of type ty.
+func indexedType(ty *typ) *typ {
+	if ty.kind == typeKindPtr && ty.base.kind == typeKindArray {
+		return ty.base
+	}
+	return ty
+}
+
+func addIndexType(e *indexExpr) {
+	addType(e.child)
+	ty := indexedType(e.child.getType())
+	if ty.kind == typeKindMap {
+		addType(e.idx)
+		e.idx = checkAssignable(e.idx, ty.key, "map index")
+		e.setType(ty.base)
+		return
+	}
+	if ty.kind != typeKindArray && ty.kind != typeKindSlice {
+		panic(fmt.Sprintf("invalid operation: cannot index %s", e.child.getType()))
+	}
+	n, ok := checkIndex(e.idx)
+	if ok && ty.kind == typeKindArray && n >= int64(ty.length) {
+		panic(fmt.Sprintf("invalid argument: index %d out of bounds [0:%d]", n, ty.length))
+	}
+	e.setType(ty.base)
+}
+
+func addSliceType(e *sliceExpr) {
+	addType(e.child)
+	ty := indexedType(e.child.getType())
+	switch ty.kind {
+	case typeKindArray:
+		if ty == e.child.getType() && !addressable(e.child) {
+			panic(fmt.Sprintf("invalid operation: %s (slice of unaddressable value)", ty))
+		}
+		e.setType(sliceOf(ty.base))
+	case typeKindSlice:
+		e.setType(ty)
+	default:
+		panic(fmt.Sprintf("cannot slice %s", e.child.getType()))
+	}
+
+	// constant indices must be in range and in order
+	prev := int64(-1)
+	for _, idx := range []expression{e.lo, e.hi, e.max} {
+		if idx == nil {
+			continue
+		}
+		n, ok := checkIndex(idx)
+		if !ok {
+			continue
+		}
+		if ty.kind == typeKindArray && n > int64(ty.length) {
+			panic(fmt.Sprintf("invalid argument: index %d out of bounds [0:%d]", n, ty.length+1))
+		}
+		if n < prev {
+			panic(fmt.Sprintf("invalid slice indices: %d < %d", n, prev))
+		}
+		prev = n
+	}
+}
+
+// checkIndex checks that idx can index an array or a slice and returns its
+// value if it is a constant.
+func checkIndex(idx expression) (int64, bool) {
+	addType(idx)
+	convertUntyped(idx, newLiteralType("int"))
+	if !isInteger(idx.getType()) {
+		panic(fmt.Sprintf("invalid argument: index of type %s must be integer", idx.getType()))
+	}
+	c, ok := idx.(*constExpr)
+	if !ok {
+		return 0, false
+	}
+	n, _ := constant.Int64Val(c.val)
+	if n < 0 {
+		panic(fmt.Sprintf("invalid argument: index %d (constant of type int) must not be negative", n))
+	}
+	return n, true
+}
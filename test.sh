@@ -20,114 +20,748 @@ assert() {
     exit 1
   fi
 }
-assert 0 'func main() int {return 0}'
-assert 42 'func main() int {return 42}'
 
-assert 48 'func main() int{return 42 + 3+ 4-1}'
-assert 12 'func main() int {return 5 * 6 / 2 + 5 - 8}'
-assert 10 'func main() int {return 5 * -6 / 2 + -5 + 30}'
-assert 160 'func main() int {return 5 * (-6 / (2 + -5) + 30)}'
+assert_error() {
+  input="$1"
 
-assert 2 'func main() int { 1; return 2; }'
-assert 1 'func main() int { return 1; 2; }'
-assert 2 'func main() int { return (1 + 3) / 2 }'
+  if ./gc "${input}" > tmp.s 2> /dev/null; then
+    echo "$input => compile error expected"
+    exit 1
+  fi
+  echo "$input => compile error" "OK!"
+}
+
+assert_escape() {
+  expected="$1"
+  input="$2"
+
+  if ! ./gc -m "${input}" 2>&1 > /dev/null | grep -qxF "${expected}"; then
+    echo "$input => $expected expected"
+    exit 1
+  fi
+  echo "$input => $expected" "OK!"
+}
+
+assert_stderr() {
+  expected="$1"
+  input="$2"
+
+  ./gc "${input}" > tmp.s || exit
+  cc -o tmp tmp.s
+  if ! ./tmp 2>&1 > /dev/null | grep -qF "${expected}"; then
+    echo "$input => $expected expected"
+    exit 1
+  fi
+  echo "$input => $expected" "OK!"
+}
+assert 0 'package main; func main() int {return 0}'
+assert 42 'package main; func main() int {return 42}'
+
+assert 48 'package main; func main() int{return 42 + 3+ 4-1}'
+assert 12 'package main; func main() int {return 5 * 6 / 2 + 5 - 8}'
+assert 10 'package main; func main() int {return 5 * -6 / 2 + -5 + 30}'
+assert 160 'package main; func main() int {return 5 * (-6 / (2 + -5) + 30)}'
+
+assert 2 'package main; func main() int { 1; return 2; }'
+assert 1 'package main; func main() int { return 1; 2; }'
+assert 2 'package main; func main() int { return (1 + 3) / 2 }'
 
 echo "local variables"
 echo ""
-assert 5 'func main() int { var a = 5; return a; }'
-assert 3 'func main() int { var foo=3; return foo; }'
-assert 8 'func main() int { var foo123=3; var bar=5; return foo123+bar; }'
-assert 8 'func main() int { var foo123, bar = 3, 5; return foo123+bar; }'
-assert 3 'func main() int { var foo123, bar = 3, 5; return foo123; }'
-assert 5 'func main() int { var foo123, bar = 3, 5; return bar; }'
+assert 5 'package main; func main() int { var a = 5; return a; }'
+assert 3 'package main; func main() int { var foo=3; return foo; }'
+assert 8 'package main; func main() int { var foo123=3; var bar=5; return foo123+bar; }'
+assert 8 'package main; func main() int { var foo123, bar = 3, 5; return foo123+bar; }'
+assert 3 'package main; func main() int { var foo123, bar = 3, 5; return foo123; }'
+assert 5 'package main; func main() int { var foo123, bar = 3, 5; return bar; }'
 echo ""
 
 echo "blocks"
 echo ""
-assert 5 'func main() int { {var a = 5; return a} }'
+assert 5 'package main; func main() int { {var a = 5; return a} }'
 echo ""
 
 echo "if"
 echo ""
-assert 5 'func main() int { var i = 5; if i == 5 { return i}; return 0;  }'
-assert 5 'func main() int { if i := 5; i == 5 { return i}; return 0; }'
-assert 5 'func main() int { var i = 5; if i == 5 { return i} else { return 3}  }'
-assert 3 'func main() int { var i = 3; if i == 5 { return i} else { return 3}  }'
-assert 3 'func main() int { var i = 3; if i == 5 { return i} else if i == 3 { return 3}  }'
-assert 3 'func main() int { i := 1; if i == 5 { return i} else if i == 4 { return 4} else { i = 3 } return i }'
-assert 4 'func main() int { i := 4; if i == 5 { return i} else if i == 4 { return 4} else { i = 3 } return i }'
-assert 1 'func main() int{if 100 > 50 { return 1} else { return 0 }}'
-assert 0 'func main() int {if 100 < 50 { return 1 } else {return 0 }}'
-assert 1 'func main() int {if 100 == 100 {return 1 }else { return 0 }}'
-assert 0 'func main() int {if 100 == 50 {return 1 }else { return 0 }}'
-assert 1 'func main() int {if 100 != 50 {return 1 }else { return 0 }}'
+assert 5 'package main; func main() int { var i = 5; if i == 5 { return i}; return 0;  }'
+assert 5 'package main; func main() int { if i := 5; i == 5 { return i}; return 0; }'
+assert 5 'package main; func main() int { var i = 5; if i == 5 { return i} else { return 3}  }'
+assert 3 'package main; func main() int { var i = 3; if i == 5 { return i} else { return 3}  }'
+assert 3 'package main; func main() int { var i = 3; if i == 5 { return i} else if i == 3 { return 3}  }'
+assert 3 'package main; func main() int { i := 1; if i == 5 { return i} else if i == 4 { return 4} else { i = 3 } return i }'
+assert 4 'package main; func main() int { i := 4; if i == 5 { return i} else if i == 4 { return 4} else { i = 3 } return i }'
+assert 1 'package main; func main() int{if 100 > 50 { return 1} else { return 0 }}'
+assert 0 'package main; func main() int {if 100 < 50 { return 1 } else {return 0 }}'
+assert 1 'package main; func main() int {if 100 == 100 {return 1 }else { return 0 }}'
+assert 0 'package main; func main() int {if 100 == 50 {return 1 }else { return 0 }}'
+assert 1 'package main; func main() int {if 100 != 50 {return 1 }else { return 0 }}'
 echo ""
 
 echo "for"
 echo ""
-assert 55 'func main() int { i:=0; j:=0; for i=0; i<=10; i=i+1 { j=i+j }; return j; }'
-assert 55 'func main() int { i:=0; j:=0; for ; i<=10; i=i+1 { j=i+j }; return j; }'
-assert 55 'func main() int { i:=0; j := 0; for ; i<=10; { j=i+j; i=i+1 }; return j; }'
-assert 55 'func main() int { i := 0; j := 0; for i<=10 { j=i+j; i=i+1 }; return j; }'
-assert 3 'func main() int { for {return 3;} return 5; }'
+assert 55 'package main; func main() int { i:=0; j:=0; for i=0; i<=10; i=i+1 { j=i+j }; return j; }'
+assert 55 'package main; func main() int { i:=0; j:=0; for ; i<=10; i=i+1 { j=i+j }; return j; }'
+assert 55 'package main; func main() int { i:=0; j := 0; for ; i<=10; { j=i+j; i=i+1 }; return j; }'
+assert 55 'package main; func main() int { i := 0; j := 0; for i<=10 { j=i+j; i=i+1 }; return j; }'
+assert 3 'package main; func main() int { for {return 3;} return 5; }'
 echo ""
 
 echo "pointer"
 echo ""
-assert 3 'func main() int { { var x=3; return *&x; } }'
-assert 3 'func main() int { { x := 3; var y = &x; z := &y; return **z; } }'
+assert 3 'package main; func main() int { { var x=3; return *&x; } }'
+assert 3 'package main; func main() int { { x := 3; var y = &x; z := &y; return **z; } }'
 echo ""
 
 echo "byte"
 echo ""
-assert 3 'func main() byte { byte x = 3; return x }'
-assert 3 'func main() byte { byte x, y = 3, 2; return x }'
-assert 2 'func main() byte { byte x, y = 3, 2; return y }'
+assert 3 'package main; func main() byte { var x byte = 3; return x }'
+assert 3 'package main; func main() byte { var x, y byte = 3, 2; return x }'
+assert 2 'package main; func main() byte { var x, y byte = 3, 2; return y }'
 echo ""
 
 echo "function"
 echo ""
-assert 32 'func main() int { return ret32() }; func ret32() int { return 32; }'
-assert 7 'func main() int { return add2(3,4); }; func add2(x int, y int) int { return x+y }'
-assert 1 'func main() int { return sub2(4,3); }; func sub2(x int, y int) int { return x-y }'
-assert 55 'func main() int { return fib(9); }; func fib(x int) int { if x <= 1 { return 1}; return fib(x-1) + fib(x-2) }'
-assert 5 'func main() int {return myFunction(1, myFunction(1, 3))}; func myFunction(a, b int) int {return a + b}'
-assert 13 'func myFunction(a, b int) int {return a + b}; func main() int { a := 6; return myFunction(a, 7)}'
+assert 32 'package main; func main() int { return ret32() }; func ret32() int { return 32; }'
+assert 7 'package main; func main() int { return add2(3,4); }; func add2(x int, y int) int { return x+y }'
+assert 1 'package main; func main() int { return sub2(4,3); }; func sub2(x int, y int) int { return x-y }'
+assert 55 'package main; func main() int { return fib(9); }; func fib(x int) int { if x <= 1 { return 1}; return fib(x-1) + fib(x-2) }'
+assert 5 'package main; func main() int {return myFunction(1, myFunction(1, 3))}; func myFunction(a, b int) int {return a + b}'
+assert 13 'package main; func myFunction(a, b int) int {return a + b}; func main() int { a := 6; return myFunction(a, 7)}'
 echo ""
 
 echo "function"
 echo ""
-assert 35 'func main() int {a, b := myFunction(3, 4); return a * b}; func myFunction(x, y int) (int, int) { lvar := 5; return x + y, 5 }'
-assert 7 'func main() int {a, b := myFunction(3, 4); return a}; func myFunction(x, y int) (int, int) { lvar := 5; return x + y, 5 }'
-assert 5 'func main() int {a, b := myFunction(3, 4); return b}; func myFunction(x, y int) (int, int) { lvar := 5; return x + y, 5 }'
-assert 11 'func main() int {a, b := myFunction(3, 4); return a}; func myFunction(x, y int) (int, int) { lvar := 5; y = y * 2; return x + y, 5 }'
-assert 11 'func main() int {a, b := myFunction(3, 4); return a}; func myFunction(x, y int) (int, byte) { var lvar byte = 5; y = y * 2; return x + y, 5 }'
+assert 35 'package main; func main() int {a, b := myFunction(3, 4); return a * b}; func myFunction(x, y int) (int, int) { lvar := 5; return x + y, 5 }'
+assert 7 'package main; func main() int {a, b := myFunction(3, 4); return a}; func myFunction(x, y int) (int, int) { lvar := 5; return x + y, 5 }'
+assert 5 'package main; func main() int {a, b := myFunction(3, 4); return b}; func myFunction(x, y int) (int, int) { lvar := 5; return x + y, 5 }'
+assert 11 'package main; func main() int {a, b := myFunction(3, 4); return a}; func myFunction(x, y int) (int, int) { lvar := 5; y = y * 2; return x + y, 5 }'
+assert 11 'package main; func main() int {a, b := myFunction(3, 4); return a}; func myFunction(x, y int) (int, byte) { var lvar byte = 5; y = y * 2; return x + y, 5 }'
 
 echo ""
 
 echo "struct"
 echo ""
-assert 5 'func main() int { var x struct { a int; b int; }; x.a = 5; x.b = 3; return x.a}'
-assert 3 'func main() int { var x struct { a int; b int; }; x.a = 5; x.b = 3; return x.b}'
-assert 5 'func main() int { x := struct { a int; b int; }{}; x.a = 5; x.b = 3; return x.a}'
-assert 3 'func main() int { x := struct { a int; b int; }{}; x.a = 5; x.b = 3; return x.b}'
+assert 5 'package main; func main() int { var x struct { a int; b int; }; x.a = 5; x.b = 3; return x.a}'
+assert 3 'package main; func main() int { var x struct { a int; b int; }; x.a = 5; x.b = 3; return x.b}'
+assert 5 'package main; func main() int { x := struct { a int; b int; }{}; x.a = 5; x.b = 3; return x.a}'
+assert 3 'package main; func main() int { x := struct { a int; b int; }{}; x.a = 5; x.b = 3; return x.b}'
 echo ""
 
 echo "array"
 echo ""
-assert 5 'func main() int { var x [2]int; x[0] = 5; x[1] = 3; return x[0]}'
-assert 3 'func main() int { var x [2]int; x[0] = 5; x[1] = 3; return x[1]}'
-assert 3 'func main() int { x := [2]int{5, 3}; return x[1]}'
-assert 5 'func main() int { x := [2]int{5, 3}; return x[0]}'
-assert 6 'func main() int { x := [2]int{1+2+3, 1+2*3}; return x[0]}'
-assert 7 'func main() int { x := [2]int{1+2+3, 1+2*3}; return x[1]}'
+assert 5 'package main; func main() int { var x [2]int; x[0] = 5; x[1] = 3; return x[0]}'
+assert 3 'package main; func main() int { var x [2]int; x[0] = 5; x[1] = 3; return x[1]}'
+assert 3 'package main; func main() int { x := [2]int{5, 3}; return x[1]}'
+assert 5 'package main; func main() int { x := [2]int{5, 3}; return x[0]}'
+assert 6 'package main; func main() int { x := [2]int{1+2+3, 1+2*3}; return x[0]}'
+assert 7 'package main; func main() int { x := [2]int{1+2+3, 1+2*3}; return x[1]}'
 echo ""
 
 echo "boolean"
 echo ""
-assert 5 'func main() int { if check() { return 5 }; return 3 }; func check() bool { return 1 == 1 }'
-assert 3 'func main() int { if !check() { return 5 }; return 3 }; func check() bool { return 1 == 1 }'
-assert 3 'func main() int { if check() { return 5 }; return 3 }; func check() bool { return 1 != 1 }'
+assert 5 'package main; func main() int { if check() { return 5 }; return 3 }; func check() bool { return 1 == 1 }'
+assert 3 'package main; func main() int { if !check() { return 5 }; return 3 }; func check() bool { return 1 == 1 }'
+assert 3 'package main; func main() int { if check() { return 5 }; return 3 }; func check() bool { return 1 != 1 }'
+echo ""
+
+echo "global variables"
+echo ""
+assert 3 'package main; var x int; func main() int { x = 3; return x }'
+assert 5 'package main; var x = 5; func main() int { return x }'
+assert 7 'package main; var b byte = 7; func main() byte { return b }'
+assert 9 'package main; var ( a int = 4; b = a + 1 ); func main() int { return a + b }'
+assert 4 'package main; var a = [3]int{1, 2, 3}; func main() int { return a[0] + a[2] }'
+assert 2 'package main; var n int; func inc() { n = n + 1 }; func main() int { inc(); inc(); return n }'
+assert 5 'package main; var a = b + 1; var b = f(); func f() int { return 4 }; func main() int { return a }'
+assert 42 'package main; var x = g(); var y = h(); func g() int { return y * 2 }; func h() int { return 21 }; func main() int { return x }'
+assert 3 'package main; func main() int { return x }; var x, y = pair(); func pair() (int, int) { return 3, 4 }'
+assert 3 'package main; var x = 7; func main() int { x := 3; return x }'
+assert 7 'package main; var x = 7; func main() int { { x := 3; x = x + 1 }; return x }'
+assert_error 'package main; var a = b; var b = a; func main() int { return a }'
+assert_error 'package main; var x = f(); func f() int { return x }; func main() int { return x }'
+assert_error 'package main; func main() int { return y }'
+assert_error 'func main() int { return 0 }'
+echo ""
+
+echo "constants"
+echo ""
+assert 2 'package main; const ( a = iota; b; c ); func main() int { return c }'
+assert 8 'package main; const ( _ = iota; KB = 1 << (10 * iota); MB ); func main() int { return MB >> 17 }'
+assert 4 'package main; const big = 1 << 100; func main() int { return big >> 98 }'
+assert 250 'package main; const c byte = 200; func main() byte { return c + 50 }'
+assert 9 'package main; func main() int { const x = 3; return x * x }'
+assert 42 'package main; func main() int { return b }; const b = a * 2; const a = 21'
+assert 4 'package main; const n = 2 + 1; var a [n * 2]int; func main() int { a[5] = 4; return a[5] }'
+assert 3 'package main; const ( x, y = iota * 2, iota + 3; z, w ); func main() int { return z + w - x - y }'
+assert 31 'package main; func main() int { return 0x1f }'
+assert 15 'package main; func main() int { return 0b101 + 0o12 }'
+assert 10 'package main; func main() int { return 1_000 / 100 }'
+assert 7 'package main; func main() int { x := false; if !x && true { return 7 }; return 0 }'
+assert 1 'package main; const t = 3 > 2 && !false; func main() int { if t { return 1 }; return 0 }'
+assert 6 'package main; func main() int { x := -7; return -x / 1 - 7 % 6 }'
+assert_error 'package main; const c byte = 200; func main() byte { return c + 100 }'
+assert_error 'package main; var x byte = 256; func main() byte { return x }'
+assert_error 'package main; func main() int { return 1 << 64 }'
+assert_error 'package main; func main() int { return 1 / 0 }'
+assert_error 'package main; var x int; const c = x; func main() int { return c }'
+echo ""
+
+echo "operators"
+echo ""
+assert 2 'package main; func main() int { x := 17; return x % 5 }'
+assert 9 'package main; func main() int { x := 12; return x & 10 | 1 }'
+assert 5 'package main; func main() int { x := 6; return x ^ 3 }'
+assert 32 'package main; func main() int { x := 1; return x << 5 }'
+assert 8 'package main; func main() int { x := -64; return -(x >> 3) }'
+assert 10 'package main; func main() int { x := 15; return x &^ 5 }'
+assert 250 'package main; func main() int { x := 5; return ^x + 256 }'
+assert 0 'package main; func main() int { x, s := 1, 64; return x << s }'
+assert 1 'package main; func main() int { x := -1; s := 100; return -(x >> s) }'
+assert 1 'package main; func main() int { x := 3; if x > 1 && x < 5 { return 1 }; return 0 }'
+assert 0 'package main; var n int; func f() bool { n = n + 1; return true }; func main() int { if n == 0 || f() { return n }; return 9 }'
+assert 1 'package main; var n int; func f() bool { n = n + 1; return false }; func main() int { if f() && f() { return 9 }; return n }'
+echo ""
+
+echo "type declarations"
+echo ""
+assert 7 'package main; type point struct { x int; y int }; func f(p *point) int { return (*p).x + (*p).y }; func main() int { var p point; p.x = 3; p.y = 4; return f(&p) }'
+assert 5 'package main; func f() celsius { return 5 }; type celsius int; func main() int { var c celsius = f(); var d celsius; d = c; return 5 }'
+assert 3 'package main; type node struct { next *node; val int }; func main() int { var a, b node; a.val = 1; b.val = 2; a.next = &b; return a.val + (*a.next).val }'
+assert 6 'package main; type myInt = int; func main() int { var x myInt = 6; var y int = x; return y }'
+assert 4 'package main; type arr [2]int; func main() int { a := arr{1, 3}; return a[0] + a[1] }'
+assert 9 'package main; func main() int { type t int; var x t = 4; var y t = 5; if x + y == 9 { return 9 }; return 0 }'
+assert 2 'package main; type ( a int; b = a ); func main() int { var x a = 2; var y b = x; var z a = y; return 2 }'
+assert 3 'package main; func main() int { type pair struct { a, b int }; p := pair{}; p.a = 3; return p.a + p.b }'
+assert_error 'package main; type t int; func main() int { var x t = 1; var y int = x; return y }'
+assert_error 'package main; type t int; func main() int { var x t = 1; var y int = 2; return x + y }'
+assert_error 'package main; type t struct { a int; b t }; func main() int { return 0 }'
+assert_error 'package main; type a = b; type b = a; func main() int { return 0 }'
+assert_error 'package main; type t int; func f(x int) int { return x }; func main() int { var x t; return f(x) }'
+echo ""
+
+echo "methods"
+echo ""
+assert 7 'package main; type counter int; func (counter) seven() int { return 7 }; func main() int { var c counter; return c.seven() }'
+assert 5 'package main; func main() int { var c counter; c.inc(); c.inc(); p := &c; p.inc(); p.inc(); p.inc(); return c.n }; type counter struct { n int }; func (c *counter) inc() { (*c).n = (*c).n + 1 }'
+assert 12 'package main; type num int; func (n num) add(m num) num { return n + m }; func main() int { var n num = 5; if n.add(7) == 12 { return 12 }; return 0 }'
+assert 9 'package main; type v struct { a int }; func (x v) get() int { return x.a }; func main() int { var x v; x.a = 9; p := &x; return p.get() }'
+assert 11 'package main; type v struct { a int }; func (x *v) add(n int) int { (*x).a = (*x).a + n; return (*x).a }; func main() int { var x v; f := x.add; f(4); return f(7) }'
+assert 3 'package main; type v struct { a int }; func (x v) get() int { return x.a }; func main() int { var x v; x.a = 3; f := x.get; x.a = 8; return f() }'
+assert 6 'package main; type v struct { a int }; func (x v) get(n int) int { return x.a * n }; func main() int { var x v; x.a = 3; f := v.get; return f(x, 2) }'
+assert 10 'package main; type v struct { a int }; func (x v) get(n int) int { return x.a * n }; func main() int { var x v; x.a = 5; return v.get(x, 2) }'
+assert 8 'package main; type v struct { a int }; func (x v) get(n int) int { return x.a * n }; func main() int { var x v; x.a = 4; f := (*v).get; return f(&x, 2) }'
+assert 4 'package main; type v struct { a int }; func (x *v) set(n int) { (*x).a = n }; func main() int { var x v; (*v).set(&x, 4); return x.a }'
+assert 15 'package main; type v struct { a int }; func (x v) two() (int, int) { return x.a, 2 * x.a }; func main() int { var x v; x.a = 5; f := x.two; a, b := f(); return a + b }'
+assert_error 'package main; type v struct { a int }; func (x *v) set() {}; func main() int { f := v.set; return 0 }'
+assert_error 'package main; type v int; func (x v) m() {}; func (x *v) m() {}; func main() int { return 0 }'
+assert_error 'package main; type v struct { m int }; func (x v) m() {}; func main() int { return 0 }'
+assert_error 'package main; type v int; func (x v) m() {}; func main() int { var x v; f := x.m; return f() }'
+echo ""
+
+echo "interfaces"
+echo ""
+assert 7 'package main; type shape interface { area() int }; type sq struct { s int }; func (q sq) area() int { return q.s * q.s }; type rect struct { w, h int }; func (r *rect) area() int { return (*r).w * (*r).h }; func total(a, b shape) int { return a.area() + b.area() }; func main() int { var q sq; q.s = 1; var r rect; r.w = 2; r.h = 3; return total(q, &r) }'
+assert 40 'package main; type num int; func (n num) get() int { return 40 }; type getter interface { get() int }; func main() int { var n num; var g getter = n; return g.get() }'
+assert 5 'package main; func main() int { var x any = 5; v := x.(int); return v }'
+assert 9 'package main; func main() int { var x interface{} = 3; v, ok := x.(bool); if ok { return 1 }; if v { return 2 }; w, ok2 := x.(int); if ok2 { return w * 3 }; return 0 }'
+assert 6 'package main; type I interface { m() int }; type J interface { m() int; n() int }; type t int; func (x t) m() int { return 1 }; func (x t) n() int { return 5 }; func main() int { var v t; var i I = v; j, ok := i.(J); if !ok { return 0 }; return j.m() + j.n() }'
+assert 3 'package main; type I interface { m() int }; type t int; func (x t) m() int { return 3 }; func main() int { var v t; var i I = v; var e any = i; k := e.(I); return k.m() }'
+assert 4 'package main; type I interface { m(a, b int) (int, int) }; type t struct { x int }; func (p *t) m(a, b int) (int, int) { (*p).x = a; return b, a }; func main() int { var v t; var i I = &v; a, b := i.m(1, 3); return a + b - v.x + 1 }'
+assert 8 'package main; type I interface { get() int }; type J interface { I; set(int) }; type t struct { x int }; func (p *t) get() int { return (*p).x }; func (p *t) set(n int) { (*p).x = n }; func main() int { var v t; var j J = &v; j.set(8); var i I = j; return i.get() }'
+assert 1 'package main; type t struct { x int }; func main() int { var v t; var e any = &v; p := e.(*t); (*p).x = 1; return v.x }'
+assert 2 'package main; func main() int { var x any = true; v := x.(int); return v }'
+assert 2 'package main; func main() int { var x any; v := x.(int); return v }'
+assert 2 'package main; type I interface { m() int }; type J interface { m() int; n() int }; type t int; func (x t) m() int { return 1 }; func main() int { var v t; var i I = v; j := i.(J); return j.m() }'
+assert 4 'package main; type I interface { m() int }; type t int; func (x t) m() int { return int(x) }; func f() t { return 4 }; func main() int { var i I = f(); return i.m() }'
+assert 5 'package main; type I interface { m() int }; type t int; func (x t) m() int { return int(x) }; func f() t { return 4 }; func g() I { return f() }; func main() int { return g().m() + 1 }'
+assert 43 'package main; type I interface { m() int }; type t int; func (x t) m() int { return int(x) }; func f() (t, t) { return 4, 3 }; func main() int { var i, j I; i, j = f(); return i.m()*10 + j.m() }'
+assert 7 'package main; func mk() int { return 7 }; func main() int { m := map[int]any{}; m[1] = mk(); return m[1].(int) }'
+assert 8 'package main; func mk() int { return 8 }; func main() int { var a [2]any; a[1] = mk(); return a[1].(int) }'
+assert 9 'package main; func mk() (int, bool) { return 9, true }; func main() int { var x any; var ok bool; x, ok = mk(); if !ok { return 0 }; return x.(int) }'
+assert_error 'package main; type I interface { m() int }; type t int; func main() int { var v t; var i I = v; return 0 }'
+assert_error 'package main; type I interface { m() int }; type t int; func (p *t) m() int { return 0 }; func main() int { var v t; var i I = v; return 0 }'
+assert_error 'package main; type I interface { m() int }; type t int; func main() int { var i I; v := i.(t); return 0 }'
+assert_error 'package main; func mk() int32 { return 8 }; func main() int { var a [4]byte; a[1] = mk(); return 0 }'
+assert_error 'package main; func main() int { x := 1; v := x.(int); return v }'
+echo ""
+
+echo "type switches"
+echo ""
+assert 3 'package main; func f(x any) int { switch v := x.(type) { case int: return v + 1; case bool: if v { return 10 }; return 20; default: return 30 }; return 0 }; func main() int { return f(2) }'
+assert 10 'package main; func f(x any) int { switch v := x.(type) { case int: return v + 1; case bool: if v { return 10 }; return 20; default: return 30 }; return 0 }; func main() int { return f(true) }'
+assert 30 'package main; type t struct { a int }; func f(x any) int { switch x.(type) { case int, bool: return 1; case nil: return 2; default: return 30 }; return 0 }; func main() int { var v t; return f(v) }'
+assert 2 'package main; func f(x any) int { switch x.(type) { case int, bool: return 1; case nil: return 2 }; return 0 }; func main() int { var x any; return f(x) }'
+assert 7 'package main; type I interface { m() int }; type t int; func (x t) m() int { return 7 }; func f(x any) int { switch v := x.(type) { case I: return v.m(); case int: return 1 }; return 0 }; func main() int { var v t; return f(v) }'
+assert 5 'package main; func main() int { var x any = 4; n := 0; switch n = 9; y := x.(type) { case bool: n = 1; case int: n = n + y - 8 }; return n }'
+assert 1 'package main; func main() int { var x any = 1; switch v := x.(type) { case int, bool: var w any = v; if _, ok := w.(int); ok { return 1 } }; return 0 }'
+assert_error 'package main; func main() int { var x any; switch x.(type) { case int: case int: }; return 0 }'
+assert_error 'package main; type I interface { m() }; func main() int { var x I; switch x.(type) { case int: }; return 0 }'
+assert_error 'package main; func main() int { x := 1; switch x.(type) { }; return 0 }'
+assert_error 'package main; func main() int { var x any; y := x.(type); return 0 }'
+echo ""
+
+echo "slices"
+echo ""
+assert 6 'package main; func sum(s []int) int { t := 0; for i := 0; i < len(s); i = i + 1 { t = t + s[i] }; return t }; func main() int { return sum([]int{1, 2, 3}) }'
+assert 15 'package main; func main() int { var s []int; for i := 1; i <= 5; i = i + 1 { s = append(s, i) }; return s[0] + s[1] + s[2] + s[3] + s[4] }'
+assert 24 'package main; func main() int { s := make([]int, 2, 10); s = append(s, 1, 2, 3); return len(s) * 10 / 10 + cap(s) + s[4] * 3 }'
+assert 17 'package main; func main() int { var a [5]int; a[1] = 10; b := a[1:3]; b[1] = 7; return a[2] + b[0] }'
+assert 11 'package main; func main() int { var a [5]int; p := &a; s := p[1:3:4]; return len(s) + cap(s) * 2 + len(a[2:]) + p[0] }'
+assert 5 'package main; func main() int { s := []int{1, 2, 3}; t := []int{4, 5}; s = append(s, t...); return len(s) }'
+assert 41 'package main; func main() int { s := []int{1, 2, 3, 4, 5}; copy(s[1:], s); return s[4] * 10 + s[1] }'
+assert 2 'package main; func main() int { s := []int{1, 2, 3}; d := make([]int, 2); return copy(d, s) }'
+assert 14 'package main; func main() int { var s []int; c := 0; for i := 0; i < 2000; i = i + 1 { if cap(s) == len(s) { c = c + 1 }; s = append(s, i) }; return c }'
+assert 3 'package main; func main() int { s := []int{1, 2, 3}; t := s[:1]; t = append(t, 9); return s[1] - 6 }'
+assert 10 'package main; type ints []int; func (s ints) sum() int { return s[0] + s[1] }; func main() int { s := ints{3, 4}; s = append(s, 1); return s[:2].sum() + len(s) }'
+assert 4 'package main; func main() int { var x any = []int{4}; s := x.([]int); return s[0] }'
+assert 2 'package main; func main() int { s := []int{1, 2, 3}; i := 3; return s[i] }'
+assert 2 'package main; func main() int { var a [3]int; i := -1; a[i] = 1; return 0 }'
+assert 2 'package main; func main() int { s := []int{1, 2, 3}; i := 4; return len(s[:i]) }'
+assert 2 'package main; func main() int { s := []int{1, 2, 3}; i := 2; j := 1; return len(s[i:j]) }'
+assert 2 'package main; func main() int { n := -1; s := make([]int, n); return len(s) }'
+assert_error 'package main; func main() int { var a [3]int; return a[3] }'
+assert_error 'package main; func main() int { var a [3]int; return len(a[1:4]) }'
+assert_error 'package main; func main() int { s := []int{1}; return len(s[2:1]) }'
+assert_error 'package main; func main() int { s := []int{1}; t := s; if s == t { return 1 }; return 0 }'
+assert_error 'package main; func main() int { s := make([]int, 2, 1); return len(s) }'
+assert_error 'package main; func main() int { s := []int{1}; s = append(s, true); return 0 }'
+assert_error 'package main; func main() int { s := []int{1}; var t []bool; return copy(s, t) }'
+assert_error 'package main; func main() int { s := []int{1}; return len(s[0::1]) }'
+echo ""
+
+echo "maps"
+echo ""
+assert 6 'package main; func main() int { m := map[int]int{1: 2, 3: 4}; return m[1] + m[3] + m[5] }'
+assert 13 'package main; func main() int { m := make(map[int]int); m[7] = 6; m[8] = 7; m[7] = m[7] + m[8]; return m[7] }'
+assert 21 'package main; func main() int { m := map[int]bool{}; m[2] = false; v, ok := m[2]; w, ok2 := m[3]; r := 0; if ok { r = r + 1 }; if ok2 || v || w { r = r + 10 }; return r + 20 }'
+assert 2 'package main; func main() int { m := map[int]int{1: 1, 2: 2, 3: 3}; delete(m, 2); delete(m, 9); _, ok := m[2]; if ok { return 100 }; return len(m) }'
+assert 0 'package main; func main() int { var m map[int]int; delete(m, 1); return len(m) + m[4] }'
+assert 200 'package main; func main() int { m := make(map[int]int, 3); for i := 0; i < 10000; i = i + 1 { m[i*7] = i }; for i := 0; i < 10000; i = i + 2 { delete(m, i*7) }; s := 0; for i := 0; i < 10000; i = i + 1 { v, ok := m[i*7]; if ok && v != i { return 1 }; if ok { s = s + 1 } }; return s / 25 }'
+assert 9 'package main; func main() int { m := map[[2]int]int{}; var k [2]int; k[0] = 1; k[1] = 2; m[k] = 4; var j [2]int; j[0] = 1; j[1] = 2; m[j] = m[j] + 5; k[1] = 3; return m[j] + m[k] }'
+assert 5 'package main; type p struct { x, y int }; func main() int { m := map[p]int{}; var a p; a.x = 1; m[a] = 5; var b p; b.x = 1; return m[b] }'
+assert 11 'package main; func main() int { m := map[bool]byte{true: 10}; m[false] = 1; if m[true] == 10 && m[false] == 1 { return 11 }; return 0 }'
+assert 3 'package main; func main() int { var x int; var y int; m := map[*int]int{&x: 1}; m[&y] = 2; return m[&x] + m[&y] }'
+assert 4 'package main; type set map[int]bool; func (s set) has(n int) bool { return s[n] }; func main() int { s := set{4: true}; if s.has(4) && !s.has(5) { return 4 }; return 0 }'
+assert 7 'package main; func f(m map[int]int) { m[1] = 7 }; func main() int { m := map[int]int{}; f(m); return m[1] }'
+assert 2 'package main; func main() int { var m map[int]int; m[1] = 1; return 0 }'
+assert 2 'package main; func main() int { n := -1; m := make(map[int]int, n); return len(m) }'
+assert_error 'package main; func main() int { m := map[[]int]int{}; return len(m) }'
+assert_error 'package main; func main() int { m := map[int]int{}; n := m; if m == n { return 1 }; return 0 }'
+assert_error 'package main; func main() int { m := map[int]int{1: 2, 1: 3}; return len(m) }'
+assert_error 'package main; func main() int { m := map[int]int{}; m[true] = 1; return 0 }'
+assert_error 'package main; func main() int { m := map[int]int{}; return cap(m) }'
+assert_error 'package main; func main() int { s := []int{}; delete(s, 0); return 0 }'
+assert_error 'package main; func main() int { m := map[int]int{}; p := &m[1]; return 0 }'
+echo ""
+
+echo "for range"
+echo ""
+assert 10 'package main; func main() int { s := 0; for i := range 5 { s = s + i }; return s }'
+assert 36 'package main; func main() int { s := 0; a := []int{1, 2, 3}; for i, v := range a { s = s + i*10 + v }; return s }'
+assert 20 'package main; func main() int { var a [4]int; a[2] = 5; s := 0; for i, v := range a { a[3] = 9; s = s + v + i }; return s + a[3] }'
+assert 10 'package main; func main() int { var a [4]int; s := 0; for i := range &a { s = s + i }; for range a { s = s + 1 }; return s }'
+assert 10 'package main; func main() int { var a [3]int; p := &a; for i, v := range p { p[2] = 4; a[i] = v + i + 1 }; return a[0] + a[1] + a[2] }'
+assert 3 'package main; func main() int { s := []int{1, 2, 3}; n := 0; for range s { s = append(s, 0); n = n + 1 }; return n }'
+assert 33 'package main; func main() int { s := 0; for i := range 3 { i = i + 10; s = s + i }; return s }'
+assert 15 'package main; func main() int { var i, v int; for i, v = range []int{4, 5} { }; return i*10 + v }'
+assert 140 'package main; func main() int { m := map[int]int{1: 10, 2: 20, 3: 30}; s := 0; for k, v := range m { s = s + k*v }; return s }'
+assert 253 'package main; func main() int { m := map[int]int{}; for i := range 1000 { m[i] = i }; s := 0; n := 0; for k, v := range m { if k != v { return 1 }; s = s + v; n = n + 1 }; return s / 1000 + n / 100 }'
+assert 100 'package main; func main() int { m := map[int]int{}; for i := range 100 { m[i] = 0 }; n := 0; for k := range m { if k < 100 { n = n + 1; m[k+1000] = 1; m[k] = m[k] + 1 } }; for i := range 100 { if m[i] != 1 { return 1 } }; return n }'
+assert 50 'package main; func main() int { m := map[int]int{}; for i := range 100 { m[i] = i }; n := 0; for k := range m { n = n + 1; delete(m, k ^ 1) }; return n }'
+assert 1 'package main; func main() int { d := 0; for range 50 { m := map[int]int{}; for i := range 8 { m[i] = i }; f := -1; for k := range m { if f < 0 { f = k } }; if f != 0 { d = d + 1 } }; if d > 20 { return 1 }; return 0 }'
+assert 0 'package main; func main() int { var m map[int]bool; for range m { return 1 }; return 0 }'
+assert 2 'package main; func main() int { var ps []*int; for i := range 3 { ps = append(ps, &i) }; return *ps[0] + *ps[2] }'
+assert 3 'package main; func main() int { var a [2]int; s := 0; for i, v := range a { b := &v; *b = i + 1; s = s + v }; return s }'
+assert_error 'package main; func main() int { for i, j := range 3 { }; return 0 }'
+assert_error 'package main; func main() int { for i := range true { }; return 0 }'
+assert_error 'package main; func main() int { for i, j, k := range []int{} { }; return 0 }'
+assert_error 'package main; func main() int { for i := range 3 { }; return i }'
+echo ""
+
+echo "composite literals"
+echo ""
+assert 5 'package main; type p struct { x, y int }; func main() int { q := &p{y: 5}; return (*q).x + (*q).y }'
+assert 4 'package main; type p struct { x, y int }; func main() int { return p{3, 4}.y }'
+assert 20 'package main; func main() int { a := [5]int{2: 7, 8}; return a[2] + a[3] + a[4] + len(a) }'
+assert 14 'package main; func main() int { a := [...]int{1, 2, 3, 9: 4}; return len(a) + a[9] }'
+assert 7 'package main; type p struct { x, y int }; func main() int { s := []p{{1, 2}, {y: 3}}; return s[0].y + s[1].y + len(s) }'
+assert 3 'package main; type p struct { x, y int }; func main() int { s := []*p{{1, 2}, {y: 3}}; return (*s[1]).y }'
+assert 8 'package main; func main() int { p := &[2][3]int{{1, 2, 3}, {4, 5, 6}}; return p[1][2] + [2][2]int{{1, 2}, {3, 4}}[0][1] }'
+assert 5 'package main; func main() int { s := [][]int{{1}, {2, 3}}; return len(s) + s[1][1] }'
+assert 10 'package main; type p struct { x, y int }; func f() *p { return &p{x: 9} }; func main() int { a := f(); b := f(); (*a).x = 1; return (*a).x + (*b).x }'
+assert 7 'package main; type p struct { x, y int }; var g = p{1, 2}; var h = [3]int{1: 5}; func main() int { return g.y + h[1] }'
+assert 5 'package main; type p struct { a [2]int; b int }; func main() int { q := &p{a: [...]int{1, 2}, b: 3}; return (*q).a[1] + (*q).b }'
+assert 6 'package main; type p struct { x, y int }; type q struct { a p; b int }; func main() int { v := &q{a: p{y: 2}, b: 3}; return (*v).a.y * (*v).b }'
+assert 3 'package main; func main() int { s := 0; for i := 0; i < 3; i = i + 1 { a := [3]int{1: i}; s = s + a[0] + a[1] + a[2] }; return s }'
+assert 4 'package main; func main() int { m := map[int][]int{1: {2, 3}, 2: {}}; return len(m[1]) + m[1][1] - len(m[2]) - 1 }'
+assert_error 'package main; type p struct { x, y int }; func main() int { v := p{x: 1, 2}; return 0 }'
+assert_error 'package main; type p struct { x, y int }; func main() int { v := p{z: 1}; return 0 }'
+assert_error 'package main; type p struct { x, y int }; func main() int { v := p{x: 1, x: 2}; return 0 }'
+assert_error 'package main; type p struct { x, y int }; func main() int { v := p{1}; return 0 }'
+assert_error 'package main; type p struct { x, y int }; func main() int { v := p{1, 2, 3}; return 0 }'
+assert_error 'package main; func main() int { a := [2]int{2: 1}; return 0 }'
+assert_error 'package main; func main() int { a := []int{1: 1, 1: 2}; return 0 }'
+assert_error 'package main; func main() int { i := 1; a := []int{i: 1}; return 0 }'
+assert_error 'package main; func main() int { a := [2]int{1, 2, 3}; return 0 }'
+assert_error 'package main; type p struct { x, y int }; func main() int { v := p{x: true}; return 0 }'
+echo ""
+
+echo "value semantics"
+echo ""
+assert 11 'package main; type p struct { x, y, z int }; func main() int { a := p{1, 2, 3}; b := a; b.x = 9; return a.x + b.x + a.y - 1 }'
+assert 21 'package main; type p struct { x, y, z int }; func f(v p) int { v.x = 5; return v.x + v.y + v.z }; func main() int { a := p{1, 2, 3}; return f(a) + a.x + a.y + a.z + 5 }'
+assert 13 'package main; type p struct { x, y, z int }; func f(n int) p { return p{n, n + 1, n + 2} }; func main() int { v := f(3); return v.x + v.y + v.z + f(0).y }'
+assert 9 'package main; func f() [3]int { return [3]int{2, 3, 4} }; func main() int { a := f(); return a[0] + a[1] + a[2] }'
+assert 7 'package main; func f() [3]int { return [3]int{2, 3, 4} }; func main() int { i := 2; return f()[i] + f()[1] }'
+assert 11 'package main; func main() int { a := [2][3]int{{1, 2, 3}, {4, 5, 6}}; b := a; b[1][2] = 0; return a[1][2] + b[1][1] }'
+assert 6 'package main; func main() int { a := [2][3]int{{1, 2, 3}, {4, 5, 6}}; a[0] = a[1]; a[1][0] = 0; return a[0][0] + a[1][0] + 2 }'
+assert 19 'package main; func main() int { a, b := 9, 1; a, b = b, a; return a*10 + b }'
+assert 19 'package main; func main() int { a := [2]int{9, 0}; b := [2]int{1, 0}; a, b = b, a; return a[0]*10 + b[0] }'
+assert 19 'package main; type T struct { x, y int }; func main() int { a := T{9, 0}; b := T{1, 0}; a, b = b, a; return a.x*10 + b.x }'
+assert 11 'package main; type T struct { x, y int }; func main() int { a := T{9, 2}; b := T{1, 3}; a, b = T{}, a; return a.x*10 + b.x + b.y }'
+assert 5 'package main; type p struct { a, b int }; func main() int { m := map[int]p{1: {2, 3}}; return m[1].a + m[1].b + m[2].a }'
+assert 9 'package main; type k struct { a, b int }; func main() int { m := map[k]int{}; m[k{1, 2}] = 4; m[k{2, 1}] = 5; return m[k{1, 2}] + m[k{2, 1}] }'
+assert 6 'package main; type p struct { x, y int }; func f() p { return p{2, 4} }; var g = f(); func main() int { return g.x + g.y }'
+assert 21 'package main; func main() int { a := [3][2]int{{1, 2}, {3, 4}, {5, 6}}; s := 0; for _, v := range a { s = s + v[0] + v[1] }; return s }'
+assert 1 'package main; func main() int { a := [3][2]int{{1, 2}, {3, 4}, {5, 6}}; var ps []*[2]int; for _, v := range a { ps = append(ps, &v) }; if ps[0] == ps[1] { return 0 }; return ps[0][0] }'
+assert 8 'package main; type p struct { a [2]int; b byte }; func swap(v p) p { t := v.a[0]; v.a[0] = v.a[1]; v.a[1] = t; return v }; func main() int { v := swap(p{[2]int{3, 5}, 0}); if v.b != 0 { return 0 }; return v.a[0]*10 + v.a[1] - 45 }'
+assert 0 'package main; type p struct { a [100]int }; func main() int { var v p; v.a[99] = 1; var w p; v = w; return v.a[99] }'
+assert 4 'package main; type p struct { x, y int }; func (v p) sum() int { return v.x + v.y }; func main() int { v := p{1, 2}; f := v.sum; v.x = 5; return f() + 1 }'
+assert 11 'package main; type p struct { x, y, z int }; type s interface { sum() int }; func (v p) sum() int { return v.x + v.y + v.z }; func main() int { var i interface{} = p{1, 2, 3}; q := i.(p); var t s = q; q.x = 100; r, ok := i.(p); if !ok { return 0 }; return q.y + t.sum() + r.z }'
+assert_error 'package main; func main() int { a := [2]int{}; b := [2]int{}; if a == b { return 1 }; return 0 }'
+echo ""
+
+echo "struct layout"
+echo ""
+assert 24 'package main; import "unsafe"; type p struct { a byte; b int; c byte }; func main() int { var v p; return int(unsafe.Sizeof(v)) }'
+assert 8 'package main; import "unsafe"; type p struct { a byte; b int; c byte }; func main() int { var v p; return int(unsafe.Offsetof(v.b) + unsafe.Offsetof(v.a)) }'
+assert 16 'package main; import "unsafe"; type p struct { a byte; b int; c byte }; func main() int { var v p; return int(unsafe.Offsetof(v.c)) }'
+assert 8 'package main; import "unsafe"; type p struct { a byte; b int }; func main() int { var v p; return int(unsafe.Alignof(v)) }'
+assert 3 'package main; import "unsafe"; type p struct { a, b, c byte }; func main() int { var v p; return int(unsafe.Sizeof(v) * unsafe.Alignof(v)) }'
+assert 16 'package main; import "unsafe"; type p struct { a byte; b [0]int }; func main() int { var v p; return int(unsafe.Sizeof(v)) }'
+assert 0 'package main; import "unsafe"; func main() int { var v struct{}; var a [4]struct{}; return int(unsafe.Sizeof(v) + unsafe.Sizeof(a)) }'
+assert 48 'package main; import "unsafe"; type p struct { a byte; b int; c byte }; func main() int { var a [2]p; return int(unsafe.Sizeof(a)) }'
+assert 57 'package main; import "unsafe"; type p struct { a byte; s []int; b byte }; func main() int { var v p; return int(unsafe.Sizeof(v) + unsafe.Offsetof(v.b) - 15) }'
+assert 16 'package main; import ( "unsafe" ); type q struct { a byte; i interface{} }; const n = unsafe.Offsetof(q{}.i) * 2; func main() int { return int(n / 2) + 8 }'
+assert 8 'package main; import "unsafe"; func main() int { return int(unsafe.Sizeof(1)) }'
+assert 14 'package main; type p struct { a byte; b int; c byte }; var g = p{1, 2, 3}; func main() int { v := p{4, 5, 6}; w := v; w.b = w.b + g.b; if w.a != 4 || w.c != 6 || g.c != 3 { return 0 }; return w.b + 7 }'
+assert_error 'package main; import "fmt"; func main() int { return 0 }'
+assert_error 'package main; import "unsafe"; func main() int { return unsafe.Size(1) }'
+assert_error 'package main; import "unsafe"; func main() int { x := 1; return unsafe.Offsetof(x) }'
+assert_error 'package main; import "unsafe"; var unsafe int; func main() int { return 0 }'
+echo ""
+
+echo "sized integers"
+echo ""
+assert 1 'package main; func main() int { var x int8 = 127; x = x + 1; if x == -128 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint8 = 255; x = x + 1; if x == 0 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint16 = 0; x = x - 1; if x == 65535 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x int32 = 2147483647; x = x * 2; if x == -2 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint32 = 1; x = x << 31; x = x << 1; if x == 0 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint64 = 18446744073709551615; var y uint64 = 2; if x / y == 9223372036854775807 && x % 10 == 5 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint = 1 << 63; var y uint = 1; if y < x && x > 5 && x >= x { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x int64 = -1 << 63; var y int64 = 1; if x < y { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint64 = 1 << 63; x = x >> 62; var y int64 = -8; y = y >> 1; if x == 2 && y == -4 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint8 = 200; var y uint8 = 7; if x / y == 28 && x % y == 4 && x >> 7 == 1 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x int8 = -7; var y int8 = 2; if x / y == -3 && x % y == -1 && x >> 1 == -4 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x int64 = -1 << 63; var y int64 = -1; if x / y == x && x % y == 0 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint8 = 5; var y int16 = 5; if ^x == 250 && ^y == -6 && -x == 251 { return 1 }; return 0 }'
+assert 1 'package main; const b uint8 = 0; const c = ^b; const d = ^0; func main() int { if c == 255 && d == -1 { return 1 }; return 0 }'
+assert 1 'package main; type p struct { a int8; b uint16; c int32; d uint32 }; func main() int { v := p{-1, 65535, -2, 4294967295}; w := v; if w.a == -1 && w.b == 65535 && w.c == -2 && w.d == 4294967295 { return 1 }; return 0 }'
+assert 1 'package main; var g int16 = -300; var h = [2]uint32{1: 4000000000}; func main() int { if g == -300 && h[1] == 4000000000 { return 1 }; return 0 }'
+assert 1 'package main; func f(a int8, b uint32) uint32 { if a < 0 { return b + 1 }; return b }; func main() int { if f(-1, 4294967295) == 0 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { m := map[int16]uint8{-1: 1, 300: 2}; if m[-1] == 1 && m[300] == 2 && m[44] == 0 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uintptr = 1; x = x - 2; if x > 1 << 62 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { n := 0; var i uint8; for i = 250; i > 5; i = i + 1 { n = n + 1 }; if n == 6 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var a int8 = 100; var b byte = 1; var c uint8 = b; if a + a == -56 && c == 1 { return 1 }; return 0 }'
+assert 1 'package main; import "unsafe"; func main() int { var a int16; var b uint32; var c uintptr; if unsafe.Sizeof(a) == 2 && unsafe.Sizeof(b) == 4 && unsafe.Alignof(c) == 8 { return 1 }; return 0 }'
+assert 1 'package main; import "unsafe"; type p struct { a byte; b int }; func main() int { var v p; var n uintptr = unsafe.Sizeof(v) + unsafe.Offsetof(v.b); if n == 24 { return 1 }; return 0 }'
+assert 2 'package main; func main() int { n := -1; return 1 << n }'
+assert_stderr 'panic: runtime error: negative shift amount' 'package main; func main() int { var n int8 = -1; return 8 >> n }'
+assert 4 'package main; func f() (r int) { defer func() { if recover() != nil { r = 4 } }(); n := -3; return 1 << n }; func main() int { return f() }'
+assert 12 'package main; func main() int { n := 3; var m int8 = 2; return 1<<n + 16>>m }'
+assert_error 'package main; func main() int { var x uint8 = 256; return 0 }'
+assert_error 'package main; func main() int { var x int8 = -129; return 0 }'
+assert_error 'package main; func main() int { var x uint = -1; return 0 }'
+assert_error 'package main; func main() int { var x int8 = 1; var y int16 = 1; if x == y { return 1 }; return 0 }'
+assert_error 'package main; const c uint8 = 255 + 1; func main() int { return 0 }'
+assert_error 'package main; import "unsafe"; func main() int { return unsafe.Sizeof(1) }'
+echo ""
+
+echo "floats"
+echo ""
+assert 1 'package main; func main() int { x := 1.5; y := .5; if x + y == 2 && x - y == 1 && x * y == 0.75 && x / y == 3 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x float64 = 1e3; var y = 0x1p-2; if x == 1000 && y == 0.25 && 2.5e-1 == y { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x float32 = 0.1; var y float32 = 0.2; var z float32 = 0.3; if x + y == z { return 1 }; return 0 }'
+assert 0 'package main; func main() int { var x float64 = 0.1; var y float64 = 0.2; var z float64 = 0.3; if x + y == z { return 1 }; return 0 }'
+assert 1 'package main; func main() int { x := 1.5; y := 2.5; if x < y && x <= y && y > x && y >= x && x != y && !(x == y) { return 1 }; return 0 }'
+assert 1 'package main; func main() int { x := 0.0; n := x / x; if n != n && !(n == n) && !(n < 1) && !(n >= 1) { return 1 }; return 0 }'
+assert 1 'package main; func main() int { x := 0.0; y := -x; if 1 / x > 0 && 1 / y < 0 && x == y { return 1 }; return 0 }'
+assert 1 'package main; func main() int { f := -2.7; g := 2.7; if int(f) == -2 && int(g) == 2 && int8(g) == 2 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { i := 7; var u uint64 = 1 << 63 + 1; f := float64(i) / 2; if f == 3.5 && float64(u) == 0x1p63 && uint64(float64(u)) == 1 << 63 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var f float32 = 1e10; var u uint64 = 18446744073709551615; if uint64(f) == 10000000000 && float32(u) == 0x1p64 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var d float64 = 16777217; var f float32 = float32(d); if float64(f) == 16777216 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x int32 = -5; var u uint8 = 200; if float32(x) == -5 && float64(u) == 200 && int(uint8(float64(u))) == 200 { return 1 }; return 0 }'
+assert 1 'package main; const c = 1 << 10 / 4.0; const d float32 = 1.0 / 3; func main() int { var e float32 = 1; if c == 256 && d == e / 3 { return 1 }; return 0 }'
+assert 1 'package main; type p struct { a float32; b float64 }; func f(x float32, y float64) p { return p{x * 2, y * 2} }; func main() int { v := f(1.25, -0.5); if v.a == 2.5 && v.b == -1 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { m := map[float64]int{1.5: 1, 0: 2}; x := 0.0; if m[1.5] == 1 && m[-x] == 2 && m[2] == 0 { return 1 }; return 0 }'
+assert 1 'package main; var g = []float64{0.5, 1.5, 2}; func main() int { s := 0.0; for _, v := range g { s = s + v }; if s == 4 { return 1 }; return 0 }'
+assert_error 'package main; func main() int { x := 1.5; y := 2.5; if x % y == 1 { return 1 }; return 0 }'
+assert_error 'package main; func main() int { var x int = 1.5; return x }'
+assert_error 'package main; func main() int { var x float32 = 1; var y float64 = 1; if x == y { return 1 }; return 0 }'
+assert_error 'package main; func main() int { x := 1.5; return int(x << 1) }'
+assert_error 'package main; func main() int { return int(1.5) }'
+echo ""
+
+echo "conversions"
+echo ""
+assert 1 'package main; func main() int { x := 300; if byte(x) == 44 && int8(x) == 44 && int8(200 + x) == -12 && uint16(-x) == 65236 { return 1 }; return 0 }'
+assert 3 'package main; func main() int { return (int)(3) }'
+assert 5 'package main; type T int; func main() int { return int((T)(4)) + int(((T))(1)) }'
+assert 255 'package main; func main() int { return int((uint8)(255)) }'
+assert 3 'package main; func main() int { var e any = (interface{})(3); return e.(int) }'
+assert 7 'package main; type T struct { a int }; func (t *T) m() int { return t.a }; func main() int { v := T{6}; return (*T).m(&v) + (T{1}).a }'
+assert 8 'package main; func main() int { return (func(x int) int { return x + 1 })(6) + ([]int{1, 2})[0] }'
+assert 6 'package main; func main() int { x := 3; return (x) + (*(&x)) }'
+assert_error 'package main; func main() int { return (int) }'
+assert 1 'package main; func main() int { var x int8 = -1; if uint8(x) == 255 && uint64(x) == 18446744073709551615 && int(uint32(x)) == 4294967295 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var u uint8 = 200; if int8(u) == -56 && int64(u) == 200 && uint16(int8(u)) == 65480 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var f float64 = -1.9; var g float32 = 3.99; if int(f) == -1 && int32(g) == 3 && float32(f) < -1.89 { return 1 }; return 0 }'
+assert 1 'package main; type celsius float64; type temp celsius; func main() int { c := celsius(21.5); t := temp(c); f := float64(t) * 2; if f == 43 { return 1 }; return 0 }'
+assert 1 'package main; type T int; func (t T) twice() int { return int(t) * 2 }; func main() int { x := 21; if T(x).twice() == 42 { return 1 }; return 0 }'
+assert 1 'package main; type p struct { a, b int }; type q struct { a, b int }; func main() int { v := p{1, 2}; w := q(v); w.a = 5; if w.a + w.b == 7 && v.a == 1 && q(v).b == 2 { return 1 }; return 0 }'
+assert 1 'package main; type p struct { a int }; type q p; func main() int { v := &p{3}; w := (*q)(v); w.a = 4; if v.a == 4 { return 1 }; return 0 }'
+assert 1 'package main; type ints []int; func main() int { s := []int{1, 2}; t := ints(s); u := []int(t); u[0] = 9; if s[0] == 9 && len(t) == 2 { return 1 }; return 0 }'
+assert 1 'package main; type counts map[int]int; func main() int { m := counts{}; m[1] = 2; n := map[int]int(m); if n[1] == 2 { return 1 }; return 0 }'
+assert 1 'package main; type myint int; func main() int { x := 5; p := &x; q := (*int)(p); *q = 6; var n myint = myint(x); if x == 6 && n == 6 { return 1 }; return 0 }'
+assert 1 'package main; const c = int8(100); const d = uint8(c) * 2; const e = float32(0.1); const f = int(2.0) + int(1e2); func main() int { if d == 200 && float64(e) != 0.1 && f == 102 { return 1 }; return 0 }'
+assert 1 'package main; type b bool; const t = b(true); func main() int { var x interface{} = int8(3); if t && x.(int8) == 3 { return 1 }; return 0 }'
+assert 1 'package main; type s interface { f() int }; type T int; func (t T) f() int { return int(t) }; func main() int { x := s(T(7)); var e interface{} = interface{}(x); if e.(s).f() == 7 { return 1 }; return 0 }'
+assert 1 'package main; func main() int { var x uint64 = 1 << 40; y := uint64(uint32(x)) + uint64(int16(x - 1)); if y == 18446744073709551615 { return 1 }; return 0 }'
+assert 7 'package main; func main() int { var x float32 = 2.5; y := float64(x) + float64(float32(1.25)); return int(y * 2) }'
+assert 2 'package main; type p struct { a, b int }; func main() int { v := p{1, 2}; w := struct { a, b int }(v); return w.b }'
+assert_error 'package main; func main() int { return int8(200) }'
+assert_error 'package main; func main() int { x := int(1.5); return x }'
+assert_error 'package main; func main() int { return uint(-1) }'
+assert_error 'package main; type p struct { a int }; type q struct { b int }; func main() int { v := q(p{1}); return v.b }'
+assert_error 'package main; func main() int { x := 1; p := &x; return int(p) }'
+assert_error 'package main; func main() int { return int(true) }'
+echo ""
+
+echo "selectors"
+echo ""
+assert 7 'package main; type p struct { a, b int }; func main() int { v := &p{3, 4}; return v.a + v.b }'
+assert 9 'package main; type p struct { a, b int }; func main() int { v := p{3, 4}; q := &v; q.b = 6; return v.a + v.b }'
+assert 5 'package main; type n struct { v int; next *n }; func main() int { c := &n{v: 3}; b := &n{2, c}; a := n{0, b}; return a.next.v + a.next.next.v }'
+assert 8 'package main; type p struct { a [2]int }; func f(x *p) { x.a[1] = 8 }; func main() int { var v p; f(&v); return v.a[1] }'
+assert 6 'package main; type p struct { a int }; type q struct { in p }; func main() int { v := &q{p{5}}; w := &v.in; w.a = w.a + 1; return v.in.a }'
+assert 4 'package main; type p struct { a int }; func g() *p { return &p{4} }; func main() int { return g().a }'
+assert 3 'package main; type p struct { a int }; type pp *p; func main() int { var x pp = &p{3}; return x.a }'
+assert 5 'package main; type p struct { a, b int }; func mk() int { return 5 }; func main() int { var v p; v.b = mk(); return v.a + v.b }'
+assert 56 'package main; type p struct { a, b int }; func mk() (int, int) { return 5, 6 }; func main() int { var v p; v.a, v.b = mk(); return v.a*10 + v.b }'
+assert 5 'package main; type p struct { a, b int }; func mk() int { return 5 }; func main() int { v := &p{}; v.b = mk(); return v.a + v.b }'
+assert_error 'package main; type p struct { a int }; func main() int { v := p{1}; return v.b }'
+assert_error 'package main; type p struct { a int }; func main() int { v := &p{1}; return v.b }'
+assert_error 'package main; func main() int { x := 1; return x.a }'
+assert_error 'package main; type p struct { a int }; func main() int { v := &p{1}; w := &v; return w.a }'
+echo ""
+
+echo "embedded fields"
+echo ""
+assert 7 'package main; type base struct { a, b int }; type s struct { base; c int }; func main() int { var v s; v.a = 1; v.base.b = 2; v.c = 4; return v.a + v.b + v.c }'
+assert 6 'package main; type base struct { a int }; type s struct { *base; c int }; func main() int { v := s{&base{5}, 1}; return v.a + v.c }'
+assert 3 'package main; type base struct { a int }; func (b base) get() int { return b.a }; func (b *base) set(x int) { b.a = x }; type s struct { base }; func main() int { var v s; v.set(3); return v.get() }'
+assert 9 'package main; type base struct { a int }; func (b *base) inc() { b.a = b.a + 1 }; type s struct { *base }; func main() int { v := s{&base{8}}; v.inc(); return v.base.a }'
+assert 2 'package main; type a struct { x int }; type b struct { a; x int }; func main() int { v := b{a{1}, 2}; return v.x }'
+assert 5 'package main; type a struct { x int }; type b struct { a }; type c struct { b; y int }; func main() int { var v c; v.x = 5; return v.b.a.x }'
+assert 4 'package main; type a struct { x int }; func (p a) m() int { return p.x * 2 }; type b struct { a }; type c struct { b }; func main() int { v := c{b{a{2}}}; f := v.m; return f() }'
+assert 12 'package main; type I interface { m() int }; type a struct { x int }; func (p a) m() int { return p.x }; type b struct { y int; a }; func main() int { var i I = b{3, a{12}}; return i.m() }'
+assert 8 'package main; type I interface { m() int }; type a struct { x int }; func (p *a) m() int { return p.x }; type b struct { a }; func main() int { v := &b{a{8}}; var i I = v; return i.m() }'
+assert 7 'package main; type I interface { m() int }; type a struct { x int }; func (p *a) m() int { return p.x }; type b struct { *a }; func main() int { var i I = b{&a{7}}; return i.m() }'
+assert 6 'package main; type a struct { x int }; func (p a) m() int { return p.x + 1 }; type b struct { a }; func main() int { f := b.m; return f(b{a{5}}) }'
+assert 1 'package main; type a struct { x int }; type b struct { a }; func main() int { var e interface{} = b{a{1}}; v := e.(b); return v.x }'
+assert 1 'package main; type I interface { m() int }; type a struct { x int }; func (p a) m() int { return 1 }; type b struct { a }; func main() int { var e interface{} = b{}; if _, ok := e.(I); ok { return 1 }; return 0 }'
+assert 18 'package main; import "unsafe"; type a struct { x, y int }; type b struct { z byte; a }; func main() int { var v b; return int(unsafe.Offsetof(v.y)) + int(unsafe.Offsetof(v.a.y)) - 6 }'
+assert 3 'package main; type node struct { *node; v int }; func main() int { n := node{&node{v: 3}, 1}; return n.node.v }'
+assert 4 'package main; type a struct { x int }; func main() int { v := struct { a; y int }{a{3}, 1}; return v.x + v.y }'
+assert 4 'package main; type I interface { M() int }; type T int; func (t T) M() int { return int(t) }; type D struct{ I }; func main() int { d := D{T(4)}; return d.M() }'
+assert 8 'package main; type I interface { M() int }; type T int; func (t T) M() int { return int(t) }; type D struct{ I }; func main() int { d := D{T(4)}; var i I = d; var j I = &d; return i.M() + j.M() }'
+assert 25 'package main; type I interface { M(a, b int) (int, int) }; type T int; func (t T) M(a, b int) (int, int) { return b + int(t), a }; type D struct{ n int; I }; type E struct { *D }; func main() int { e := E{&D{1, T(5)}}; x, y := e.M(1, 2); var i I = e; p, q := i.M(3, 4); return x*1000 + y*100 + p*10 + q }'
+assert 4 'package main; type I interface { M() int }; type J interface { M() int; N() int }; type T int; func (t T) M() int { return int(t) }; type D struct{ I }; func main() int { var e any = D{T(4)}; if j, ok := e.(J); ok { return j.N() }; return e.(I).M() }'
+assert 9 'package main; type I interface { M() int }; type T int; func (t T) M() int { return int(t) }; type D struct{ I }; type X struct{}; func (X) M() int { return 9 }; type E struct { D; X }; func main() int { var i I = E{D{T(4)}, X{}}; return i.M() }'
+assert 2 'package main; type I interface { M() int }; type D struct{ I }; func main() int { var d D; return d.M() }'
+assert 12 'package main; type I interface{ M() int }; type A struct{}; func (A) M() int {return 1}; type B struct{}; func (B) M() int {return 2}; func f() int { type T struct{ A }; var i I = T{}; return i.M() }; func g() int { type T struct{ B }; var i I = T{}; return i.M() }; func main() int { return f()*10 + g() }'
+assert 12 'package main; type I interface{ M() int }; type A struct{}; func (A) M() int {return 1}; type B struct{}; func (B) M() int {return 2}; type T struct{ A }; func f() int { type T struct{ B }; var i I = &T{}; return i.M() }; func main() int { var i I = T{}; return i.M()*10 + f() }'
+assert_error 'package main; type a struct { x int }; type b struct { x int }; type c struct { a; b }; func main() int { var v c; return v.x }'
+assert_error 'package main; type a struct{}; func (a) m() int { return 1 }; type b struct{}; func (b) m() int { return 2 }; type c struct { a; b }; func main() int { var v c; return v.m() }'
+assert_error 'package main; type I interface { m() int }; type a struct{}; func (p *a) m() int { return 1 }; type b struct { a }; func main() int { var i I = b{}; return i.m() }'
+assert_error 'package main; type a struct { x int }; type b struct { a }; func main() int { var v b; return v.y }'
+assert_error 'package main; type I interface { M() int }; type J interface { M() int }; type D struct{ I; J }; func main() int { var d D; return d.M() }'
+assert_error 'package main; import "unsafe"; type a struct { x int }; type b struct { *a }; func main() int { var v b; return int(unsafe.Offsetof(v.x)) }'
+echo ""
+
+echo "nil and new"
+echo ""
+assert 1 'package main; func main() int { var p *int; if p == nil { return 1 }; return 0 }'
+assert 5 'package main; func main() int { p := new(int); *p = 5; if p != nil { return *p }; return 0 }'
+assert 0 'package main; type t struct { a, b int; c [3]int }; func main() int { p := new(t); return p.a + p.b + p.c[2] }'
+assert 1 'package main; func main() int { p := new(int); q := new(int); r := p; if p != q && p == r && *p == *q { return 1 }; return 0 }'
+assert 6 'package main; type n struct { v int; next *n }; func main() int { var l *n; for i := 1; i <= 3; i = i + 1 { l = &n{i, l} }; s := 0; for p := l; p != nil; p = p.next { s = s + p.v }; return s }'
+assert 1 'package main; type T int; func (T) m() int { return 1 }; func main() int { var s []int; var m map[int]int; var e interface{}; f := T.m; if s == nil && m == nil && e == nil && f != nil && nil == s { return 1 }; return 0 }'
+assert 1 'package main; func main() int { s := []int{}; m := map[int]int{}; var e interface{} = 0; if s != nil && m != nil && e != nil { return 1 }; return 0 }'
+assert 1 'package main; func f() *int { return nil }; func g(p *int, s []int) int { if p == nil && s == nil { return 1 }; return 0 }; func main() int { return g(f(), nil) }'
+assert 1 'package main; type t struct { p *int; s []int }; func main() int { v := t{nil, nil}; var e interface{} = (*int)(nil); if v.p == nil && v.s == nil && e != nil { return 1 }; return 0 }'
+assert 3 'package main; func main() int { pp := new(*int); x := 3; *pp = &x; return **pp }'
+assert 2 'package main; func main() int { var p *int; return *p }'
+assert 2 'package main; type t struct { a, b int }; func main() int { var p *t; p.b = 1; return 0 }'
+assert 2 'package main; type t struct { a int }; func (v t) get() int { return v.a }; func main() int { var p *t; return p.get() }'
+assert 2 'package main; func main() int { var p *[3]int; return p[1] }'
+assert 2 'package main; func main() int { var p *[3]int; p[1] = 3; return 0 }'
+assert 2 'package main; func main() int { var p *[3]int; s := 0; for i, v := range p { s = s + i + v }; return s }'
+assert 2 'package main; func main() int { var p *[3]int; s := p[:]; return len(s) }'
+assert 3 'package main; func main() int { var p *[3]int; s := 0; for i := range p { s = s + i }; return s }'
+assert_error 'package main; func main() int { x := nil; return 0 }'
+assert_error 'package main; func main() int { var x int = nil; return x }'
+assert_error 'package main; func main() int { if nil == nil { return 1 }; return 0 }'
+assert_error 'package main; func main() int { p := new(int); q := new(int8); if p == q { return 1 }; return 0 }'
+assert_error 'package main; func main() int { p := new(int); if p < p { return 1 }; return 0 }'
+assert_error 'package main; type T int; func (T) m() int { return 1 }; func main() int { f := T.m; if f == f { return 1 }; return 0 }'
+assert_error 'package main; func main() int { p := new(); return 0 }'
+echo ""
+
+echo "heap allocation"
+echo ""
+assert 45 'package main; type n struct { v int; next *n }; func main() int { var l *n; for i := 0; i < 100000; i = i + 1 { l = &n{i % 10, l} }; s := 0; for p := l; p != nil; p = p.next { s = s + p.v }; return s / 10000 }'
+assert 1 'package main; func main() int { p := new([100]int); q := new([100]int); for i := 0; i < 100; i = i + 1 { p[i] = 1 }; if q[99] == 0 && p[99] == 1 { return 1 }; return 0 }'
+assert 7 'package main; func main() int { s := make([]int, 1000000); s[999999] = 7; t := make([]int, 3000); return s[999999] + t[2999] }'
+assert 3 'package main; type big struct { a [300]int; b int }; func main() int { s := 0; for i := 0; i < 3; i = i + 1 { p := new(big); s = s + p.b + 1; p.b = 9 }; return s }'
+assert 30 'package main; func main() int { var ps [30]*int8; for i := 0; i < 30; i = i + 1 { p := new(int8); *p = int8(i); ps[i] = p }; n := 0; for i := 0; i < 30; i = i + 1 { if int(*ps[i]) == i { n = n + 1 } }; return n }'
+assert 1 'package main; func main() int { var s []int; for i := 0; i < 50000; i = i + 1 { s = append(s, i) }; m := map[int]int{}; for i := 0; i < 5000; i = i + 1 { m[i] = i }; if s[49999] == 49999 && m[4999] == 4999 && len(m) == 5000 { return 1 }; return 0 }'
+echo ""
+
+echo "garbage collection"
+echo ""
+assert 10 'package main; type n struct { v int; next *n; pad [30]int }; func main() int { s := 0; for j := 0; j < 200; j = j + 1 { var l *n; for i := 0; i < 10000; i = i + 1 { l = &n{i % 10, l, [30]int{}} }; for p := l; p != nil; p = p.next { s = s + p.v } }; return s / 900000 }'
+assert 63 'package main; func main() int { m := map[int][]int{}; for i := 0; i < 200000; i = i + 1 { m[i%1000] = make([]int, 50); m[i%1000][3] = i }; return m[999][3] % 256 }'
+assert 220 'package main; func main() int { s := 0; for i := 0; i < 3000; i = i + 1 { b := make([]int, 10000); b[9999] = i; s = s + b[9999] % 2 }; return s % 256 }'
+GOGC=0 assert 45 'package main; type n struct { v int; next *n }; var l *n; func main() int { for i := 0; i < 1000; i = i + 1 { l = &n{i % 10, l} }; s := 0; for p := l; p != nil; p = p.next { s = s + p.v }; return s / 100 }'
+GOGC=0 assert 6 'package main; type shape interface { area() int }; type sq struct { s int; p *int }; func (q sq) area() int { return q.s * q.s + *q.p }; func main() int { var ss []shape; for i := 0; i < 100; i = i + 1 { ss = append(ss, sq{i % 3, new(int)}) }; s := 0; for _, x := range ss { s = s + x.area() }; return s / 25 }'
+GOGC=0 assert 1 'package main; func main() int { m := map[int][]*int{}; for i := 0; i < 300; i = i + 1 { p := new(int); *p = i; m[i%2] = append(m[i%2], p) }; if len(m[1]) == 150 && *m[0][149] == 298 { return 1 }; return 0 }'
+GOGC=0 assert 34 'package main; type N struct { v int; next *N }; func churn() int { s := 0; for i := 0; i < 100; i = i + 1 { n := &N{i, nil}; s = s + n.v }; return s % 7 }; func sum(a *N, b int, c *N) int { return a.v + b + c.v + c.next.v }; func main() int { return sum(&N{1, nil}, churn(), &N{2, &N{30, nil}}) }'
+GOGC=0 assert 121 'package main; type N struct { v int; next *N }; func churn() int { s := 0; for i := 0; i < 100; i = i + 1 { n := &N{i, nil}; s = s + n.v }; return s % 7 }; func main() int { ns := []*N{&N{1, nil}, &N{2, nil}}; x := append(ns, &N{churn(), nil}, &N{3, nil}); return x[0].v + x[1].v*10 + x[2].v*100 + x[3].v*1000 - 3000 }'
+GOGC=0 assert 43 'package main; type N struct { v int; next *N }; func churn() int { s := 0; for i := 0; i < 100; i = i + 1 { n := &N{i, nil}; s = s + n.v }; return s % 7 }; type I interface { get(int) int }; func (n *N) get(k int) int { return n.v + k }; func main() int { var i I = &N{40, nil}; return i.get(churn()) + (&N{2, nil}).v * churn() }'
+./gc 'package main; func main() int { s := 0; for i := 0; i < 100000; i = i + 1 { b := make([]int, 100); s = s + b[99] }; return s }' > tmp.s && cc -o tmp tmp.s
+if ! ./tmp -gcstats 2>&1 | grep -q '^gc: [1-9][0-9]* collections'; then
+  echo "-gcstats => collections expected"
+  exit 1
+fi
+echo "-gcstats => OK!"
+echo ""
+
+echo "escape analysis"
+echo ""
+assert 12 'package main; func f(n int) *int { x := n; return &x }; func main() int { a := f(1); b := f(2); return *a*10 + *b }'
+assert 12 'package main; func f(n int) *int { return &n }; func main() int { a := f(1); b := f(2); return *a*10 + *b }'
+assert 210 'package main; func main() int { var ps []*int; for i := 0; i < 3; i = i + 1 { x := i; ps = append(ps, &x) }; return *ps[0] + *ps[1]*10 + *ps[2]*100 }'
+assert 0 'package main; func main() int { var prev *int; n := 0; for _, v := range []int{1, 2, 3} { if prev == &v { n = n + 1 }; prev = &v }; return n }'
+assert 42 'package main; type T struct { n int }; func (t *T) inc() { t.n = t.n + 1 }; func (t T) addr() *int { return &t.n }; func main() int { var t T; t.inc(); t.inc(); p := t.addr(); *p = 40; return t.n + *p }'
+assert 6 'package main; var g *int; func rec(p *int, n int) { if n == 0 { g = p; return }; rec(p, n - 1) }; func main() int { x := 5; rec(&x, 3); x = 6; return *g }'
+GOGC=0 assert 45 'package main; type N struct { v int; next *N }; func push(l *N, v int) *N { n := N{v, l}; return &n }; func main() int { var l *N; for i := 0; i < 10; i = i + 1 { l = push(l, i) }; s := 0; for p := l; p != nil; p = p.next { s = s + p.v }; return s }'
+assert_escape 'f: moved to heap: x' 'package main; func f() *int { x := 1; return &x }; func main() int { return *f() }'
+assert_escape 'main: x does not escape' 'package main; func get(p *int) int { return *p }; func main() int { x := 1; return get(&x) }'
+assert_escape 'get: p does not escape' 'package main; func get(p *int) int { return *p }; func main() int { x := 1; return get(&x) }'
+assert_escape 'id: leaking param: p to result ~r0 level=0' 'package main; func id(p *int) *int { return p }; func main() int { x := 1; return *id(&x) }'
+assert_escape 'main: moved to heap: x' 'package main; var g *int; func set(p *int) { g = p }; func main() int { x := 1; set(&x); return *g }'
+assert_escape 'set: leaking param: p' 'package main; var g *int; func set(p *int) { g = p }; func main() int { x := 1; set(&x); return *g }'
+assert_escape 'main: x does not escape' 'package main; func main() int { a := [3]int{1, 2, 3}; x := 0; p := &x; s := a[:]; for i := range s { *p = *p + s[i] }; return x }'
+echo ""
+
+echo "closures"
+echo ""
+assert 5 'package main; func main() int { add := func(a int, b int) int { return a + b }; return add(2, 3) }'
+assert 2 'package main; func main() int { n := 0; inc := func() { n = n + 1 }; inc(); inc(); return n }'
+assert 10 'package main; func apply(n int) int { k := 3; m := func(v int) int { return v * k + n }; return m(2) }; func main() int { return apply(4) }'
+assert 21 'package main; func main() int { x := 1; f := func() int { g := func() int { x = x * 10; return x }; return g() + 1 }; y := f(); return x + y }'
+assert 10 'package main; func main() int { x := 2; f := func() int { y := 3; g := func() int { return x * y }; y = 5; return g() }; return f() }'
+assert 14 'package main; type T struct { a int; b [3]int }; func main() int { t := T{1, [3]int{2, 3, 4}}; f := func(i int) int { return t.b[i] + t.a }; t.a = 10; return f(2) }'
+assert 20 'package main; var k = 4; var f = func(x int) int { return x * k }; func main() int { return f(5) }'
+assert 5 'package main; var a = func() int { return b }(); var b = f(); func f() int { return 5 }; func main() int { return a }'
+assert 10 'package main; var a = func() func() int { return func() int { return b * 2 } }()(); var b = f(); func f() int { return 5 }; func main() int { return a }'
+assert 7 'package main; var a = f(); var c = g(); func g() int { return 7 }; func f() (r int) { defer func() { r = c }(); return 0 }; func main() int { return a }'
+assert 10 'package main; func main() int { s := 0; for i := 0; i < 5; i = i + 1 { func() { s = s + i }() }; return s }'
+assert 210 'package main; func main() int { var ps []*int; for i := 0; i < 3; i = i + 1 { f := func() *int { return &i }; ps = append(ps, f()) }; return *ps[0] + *ps[1]*10 + *ps[2]*100 }'
+assert 0 'package main; func main() int { var prev *int; n := 0; for i := 0; i < 3; i = i + 1 { if prev == &i { n = n + 1 }; prev = &i }; return n }'
+assert 8 'package main; type I interface { get() int }; type P struct { n int }; func (p P) get() int { return p.n }; func main() int { var i I = P{7}; f := func() int { return i.get() }; i = P{8}; return f() }'
+GOGC=0 assert 90 'package main; type N struct { v int; next *N }; func main() int { var l *N; s := 0; for i := 0; i < 200; i = i + 1 { add := func(v int) { l = &N{v, l} }; add(i % 10); sum := func() int { t := 0; for p := l; p != nil; p = p.next { t = t + p.v }; return t }; s = sum() }; return s / 10 }'
+assert_escape 'main: moved to heap: n' 'package main; func main() int { n := 0; inc := func() { n = n + 1 }; inc(); return n }'
+assert_escape 'main.func1: moved to heap: y' 'package main; func main() int { x := 2; f := func() int { y := 3; g := func() int { return x * y }; return g() }; return f() }'
+echo ""
+
+echo "function values"
+echo ""
+assert 7 'package main; func apply(f func(int) int, v int) int { return f(v) }; func inc(n int) int { return n + 1 }; func main() int { return apply(inc, 6) }'
+assert 9 'package main; func sq(n int) int { return n * n }; func main() int { var f func(int) int; f = sq; return f(3) }'
+assert 1 'package main; func main() int { var f func(int) int; if f == nil { return 1 }; return 0 }'
+assert 0 'package main; func sq(n int) int { return n * n }; func main() int { f := sq; if f != nil { return 0 }; return 1 }'
+assert 11 'package main; type T struct { op func(int, int) int; n int }; func add(a int, b int) int { return a + b }; func main() int { t := T{add, 5}; return t.op(t.n, 6) }'
+assert 3 'package main; func counter() func() int { n := 0; return func() int { n = n + 1; return n } }; func main() int { c := counter(); c(); c(); return c() }'
+assert 12 'package main; func compose(f func(int) int, g func(int) int) func(int) int { return func(x int) int { return g(f(x)) } }; func dbl(x int) int { return x * 2 }; func main() int { return compose(dbl, func(x int) int { return x + 2 })(5) }'
+assert 6 'package main; func main() int { fs := []func() int{}; for i := 1; i <= 3; i = i + 1 { fs = append(fs, func() int { return i }) }; s := 0; for _, f := range fs { s = s + f() }; return s }'
+assert_error 'package main; func sq(n int) int { return n * n }; func main() int { var f func(int, int) int; f = sq; return 0 }'
+assert_error 'package main; func sq(n int) int { return n * n }; func main() int { f := sq; g := sq; if f == g { return 1 }; return 0 }'
+assert_error 'package main; func main() int { var f func(int) int; return f(1, 2) }'
+assert 5 'package main; func inc(n int) int { return n + 1 }; func mk() func(int) int { return inc }; func main() int { var a [2]func(int) int; a[0] = mk(); return a[0](4) }'
+assert_error 'package main; func mk() func(byte) int { return nil }; func main() int { var a [2]func(int) int; a[0] = mk(); return 0 }'
+assert_error 'package main; func mk() (func(byte) int, int) { return nil, 0 }; func main() int { var f func(int) int; var n int; f, n = mk(); return n }'
+echo ""
+
+echo "defer"
+echo ""
+assert 42 'package main; func g() (r int) { defer func() { r = r * 2 }(); return 21 }; func main() int { return g() }'
+assert 65 'package main; var trace int; func add(d int) { trace = trace*10 + d }; func f() { defer add(1); defer add(2); x := 3; defer add(x); x = 9 }; func main() int { f(); return trace - 256 }'
+assert 42 'package main; var s int; func run() { for i := 0; i < 3; i = i + 1 { defer func() { s = s*10 + i }() }; defer func(v int) { s = s*10 + v }(7) }; func main() int { run(); return s % 256 }'
+assert 121 'package main; type T struct { n int }; var s int; func (t T) get() { s = s*10 + t.n }; func (t *T) ptr() { s = s*10 + t.n }; func run() { t := T{1}; defer func() { s = s + 100 }(); defer t.get(); defer t.ptr(); t.n = 2 }; func main() int { run(); return s }'
+assert 44 'package main; var s int; type I interface { m(int) }; type T struct { k int }; func (t T) m(v int) { s = s + t.k*v }; func two() (int, int) { return 3, 4 }; func h(a int, b int) { s = s*100 + a*10 + b }; func run() { var i I = T{2}; defer i.m(5); i = T{7}; defer h(two()) }; func main() int { run(); return s }'
+assert 32 'package main; func f() (a int, b int) { defer func() { a = a + b }(); a, b = 1, 2; return }; func main() int { x, y := f(); return x*10 + y }'
+assert 6 'package main; func f() (p *int) { x := 5; defer func() { *p = *p + 1 }(); return &x }; func main() int { return *f() }'
+assert 7 'package main; var n int; func f(k int) int { defer func() { n = n + k }(); if k > 3 { return k }; return f(k + 2) }; func main() int { f(1); return n - 2 }'
+GOGC=0 assert 251 'package main; type N struct { v int; next *N }; var total int; func sum(l *N) { for p := l; p != nil; p = p.next { total = total + p.v } }; func run() { for i := 0; i < 50; i = i + 1 { l := &N{i, nil}; l = &N{1, l}; defer sum(l) } }; func main() int { run(); return total % 256 }'
+assert_error 'package main; func main() int { x := []int{1}; defer len(x); return 0 }'
+assert_error 'package main; func main() int { defer 1; return 0 }'
+assert_error 'package main; func f() int { return }; func main() int { return f() }'
+assert_stderr 'panic: assignment to entry in nil map' 'package main; func main() int { defer func() { var m map[int]int; m[1] = 1 }(); a := []int{}; i := 5; return a[i] }'
+echo ""
+
+echo "panic and recover"
+echo ""
+assert 2 'package main; func main() int { panic(42) }'
+assert_stderr 'panic: 42' 'package main; func f() { panic(42) }; func main() int { f(); return 0 }'
+assert_stderr 'goroutine 1 [running]:' 'package main; func main() int { panic(42) }'
+assert_stderr 'main.f()' 'package main; func f() { panic(true) }; func main() int { f(); return 0 }'
+assert_stderr 'panic: main.T(-7)' 'package main; type T int; func main() int { panic(T(-7)) }'
+assert_stderr 'panic: +1.500000e+000' 'package main; func main() int { panic(1.5) }'
+assert_stderr 'panic: 1 [recovered]' 'package main; func main() int { defer func() { recover(); panic(2) }(); panic(1) }'
+assert_stderr 'panic: runtime error: integer divide by zero' 'package main; func main() int { a := 0; return 5 / a }'
+assert_stderr 'panic: panic called with nil argument' 'package main; func main() int { var e interface{}; panic(e) }'
+assert 42 'package main; func f() (r int) { defer func() { r = recover().(int) + 1 }(); panic(41) }; func main() int { return f() }'
+assert 0 'package main; func g() { panic(5) }; func f() int { g(); return 1 }; func main() int { defer func() { recover() }(); return f() }'
+assert 0 'package main; func main() int { var g func(); defer func() { recover() }(); defer g(); var p *int; return *p }'
+assert 7 'package main; func f() (r int) { defer func() { if recover() != nil { r = 7 } }(); a := []int{}; i := 5; return a[i] }; func main() int { return f() }'
+assert 9 'package main; func main() int { if recover() == nil { return 9 }; return 1 }'
+assert 52 'package main; var s int; func f() (r int) { for i := 0; i < 2; i = i + 1 { defer func() { s = s + 1 }() }; defer func() { if v := recover(); v != nil { r = v.(int) } }(); panic(5) }; func main() int { return f()*10 + s }'
+assert 142 'package main; var s int; func f() { defer func() { s = s*10 + 1 }(); defer func() { recover(); s = s*10 + 2 }(); defer func() { s = s*10 + 3 }(); panic(0) }; func main() int { f(); s = s*10 + 4; return s % 256 }'
+assert 33 'package main; func g(n int) int { if n == 0 { var p *int; return *p }; return g(n - 1) }; func f() (r int) { defer func() { if recover() != nil { r = 33 } }(); return g(5) }; func main() int { return f() }'
+assert 11 'package main; func inner() { defer func() { v := recover(); panic(v.(int) + 1) }(); panic(10) }; func outer() (r int) { defer func() { r = recover().(int) }(); inner(); return 0 }; func main() int { return outer() }'
+assert 201 'package main; var s int; func f() { defer func() { s = s + recover().(int) }(); defer func() { defer func() { s = s + 100*recover().(int) }(); panic(2) }(); panic(1) }; func main() int { f(); return s }'
+assert 5 'package main; func f() (r int) { defer func() { if recover() != nil { r = 5 } }(); var m map[int]int; m[1] = 2; return 0 }; func main() int { return f() }'
+assert 6 'package main; func f() (r int) { defer func() { if recover() != nil { r = 6 } }(); a := 1; b := 0; return a % b }; func main() int { return f() }'
+assert 7 'package main; type I interface { M() int }; func f() (r int) { defer func() { if recover() != nil { r = 7 } }(); var i I; return i.M() }; func main() int { return f() }'
+assert_stderr 'nil pointer dereference' 'package main; type I interface { M() int }; func main() int { var i I; return i.M() }'
+GOGC=0 assert 42 'package main; type N struct { v int; next *N }; func f() (r int) { defer func() { v := recover().(*N); for i := 0; i < 100; i = i + 1 { x := &N{i, nil}; x.next = nil }; r = v.v + v.next.v }(); n := &N{40, &N{2, nil}}; panic(n) }; func main() int { return f() }'
+assert_stderr 'panic: 3' 'package main; func helper() interface{} { return recover() }; func f() { defer func() { helper() }(); panic(3) }; func main() int { f(); return 0 }'
+assert_stderr 'panic: 1' 'package main; func main() int { for i := 0; i < 1; i = i + 1 { defer recover() }; panic(1) }'
+assert_error 'package main; func main() int { panic(1, 2) }'
+assert_error 'package main; func main() int { recover(1); return 0 }'
 echo ""
 
 echo OK
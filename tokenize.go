@@ -22,13 +22,12 @@ const (
 type token struct {
 	kind tokenKind
 	val  string
-	num  int // for int
 }
 
 func tokenize() {
 	for len(in) > 0 {
 
-		if in[0] == ' ' {
+		if in[0] == ' ' || in[0] == '\t' || in[0] == '\r' {
 			in = in[1:]
 			continue
 		}
@@ -45,8 +44,19 @@ func tokenize() {
 			continue
 		}
 
-		if strings.Contains("+-*/()=<>!,{}&:.[]", in[0:1]) {
-			if len(in) > 1 && (in[0:2] == "<=" || in[0:2] == ">=" || in[0:2] == "==" || in[0:2] == "!=" || in[0:2] == ":=") {
+		if isDigit() || in[0] == '.' && len(in) > 1 && in[1] >= '0' && in[1] <= '9' {
+			tokens = append(tokens, &token{kind: tokenKindLiteral, val: numberLit()})
+			continue
+		}
+
+		if strings.HasPrefix(in, "...") {
+			tokens = append(tokens, &token{kind: tokenKindOperator, val: "..."})
+			in = in[3:]
+			continue
+		}
+
+		if strings.Contains("+-*/%()=<>!,{}&|^:.[]", in[0:1]) {
+			if len(in) > 1 && inOperators(in[0:2]) {
 				tokens = append(tokens, &token{kind: tokenKindOperator, val: in[0:2]})
 				in = in[2:]
 			} else {
@@ -56,7 +66,7 @@ func tokenize() {
 			continue
 		}
 
-		if in[0] >= 'a' && in[0] <= 'z' {
+		if isAlpha() {
 			name := in[0:1]
 			in = in[1:]
 			for len(in) > 0 && (isAlpha() || isDigit()) {
@@ -67,8 +77,14 @@ func tokenize() {
 			continue
 		}
 
-		if isDigit() {
-			tokens = append(tokens, &token{kind: tokenKindLiteral, num: toInt()})
+		if in[0] == '"' {
+			// the literal is checked when it is parsed
+			end := strings.IndexAny(in[1:], "\"\n")
+			if end < 0 || in[1+end] != '"' {
+				panic("string literal not terminated")
+			}
+			tokens = append(tokens, &token{kind: tokenKindLiteral, val: in[:end+2]})
+			in = in[end+2:]
 			continue
 		}
 
@@ -78,21 +94,50 @@ func tokenize() {
 	autoInsertSemicolon()
 }
 
+// numberLit consumes an integer or floating-point literal. The literal is
+// checked when it is parsed. An exponent may have a sign: after e in a
+// decimal literal and after p in a hexadecimal one.
+func numberLit() string {
+	hex := strings.HasPrefix(in, "0x") || strings.HasPrefix(in, "0X")
+	n := 1
+	for ; n < len(in); n++ {
+		c := in[n]
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '.' {
+			continue
+		}
+		prev := in[n-1] | 0x20
+		if (c == '+' || c == '-') && (prev == 'p' || !hex && prev == 'e') {
+			continue
+		}
+		break
+	}
+	lit := in[:n]
+	in = in[n:]
+	return lit
+}
+
 func isDigit() bool {
 	return in[0] >= '0' && in[0] <= '9'
 }
 
 func isAlpha() bool {
-	return (in[0] >= 'a' && in[0] <= 'z') || (in[0] >= 'A' && in[0] <= 'Z')
+	return (in[0] >= 'a' && in[0] <= 'z') || (in[0] >= 'A' && in[0] <= 'Z') || in[0] == '_'
 }
 
-func toInt() int {
-	var ret int
-	for len(in) > 0 && (in[0] >= '0' && in[0] <= '9') {
-		ret = ret*10 + int(in[0]-'0')
-		in = in[1:]
-	}
-	return ret
+func inOperators(val string) bool {
+	_, ok := map[string]struct{}{
+		"<=": {},
+		">=": {},
+		"==": {},
+		"!=": {},
+		":=": {},
+		"<<": {},
+		">>": {},
+		"&&": {},
+		"||": {},
+		"&^": {},
+	}[val]
+	return ok
 }
 
 func identifierToken(val string) *token {
@@ -107,20 +152,44 @@ func identifierToken(val string) *token {
 
 func inKeywords(val string) bool {
 	_, ok := map[string]struct{}{
-		"return": {},
-		"for":    {},
-		"if":     {},
-		"else":   {},
+		"return":  {},
+		"for":     {},
+		"if":      {},
+		"else":    {},
+		"package": {},
+		"var":     {},
+		"func":    {},
+		"const":   {},
+		"type":    {},
+		"switch":  {},
+		"case":    {},
+		"default": {},
+		"map":     {},
+		"range":   {},
+		"import":  {},
 	}[val]
 	return ok
 }
 
 func inTypes(val string) bool {
 	_, ok := map[string]struct{}{
-		"int":    {},
-		"byte":   {},
-		"bool":   {},
-		"struct": {},
+		"int":       {},
+		"int8":      {},
+		"int16":     {},
+		"int32":     {},
+		"int64":     {},
+		"uint":      {},
+		"uint8":     {},
+		"uint16":    {},
+		"uint32":    {},
+		"uint64":    {},
+		"uintptr":   {},
+		"float32":   {},
+		"float64":   {},
+		"byte":      {},
+		"bool":      {},
+		"struct":    {},
+		"interface": {},
 	}[val]
 	return ok
 }
@@ -136,9 +205,13 @@ func autoInsertSemicolon() {
 
 	needed := func() bool {
 
+		if len(tokens) == 0 {
+			return false
+		}
 		finalTok := tokens[len(tokens)-1]
 
-		if finalTok.kind == tokenKindLiteral || finalTok.kind == tokenKindIdentifier {
+		if finalTok.kind == tokenKindLiteral || finalTok.kind == tokenKindIdentifier ||
+			finalTok.kind == tokenKindType {
 			return true
 		}
 
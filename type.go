@@ -2,17 +2,37 @@ package main
 
 import (
 	"fmt"
+	"go/constant"
 )
 
 type typeKind int
 
 const (
 	typeKindInt typeKind = iota
-	typeKindByte
+	typeKindInt8
+	typeKindInt16
+	typeKindInt32
+	typeKindInt64
+	typeKindUint
+	typeKindUint8
+	typeKindUint16
+	typeKindUint32
+	typeKindUint64
+	typeKindUintptr
+	typeKindFloat32
+	typeKindFloat64
 	typeKindBool
 	typeKindPtr
 	typeKindStruct
 	typeKindArray
+	typeKindSlice
+	typeKindMap
+	typeKindFunc
+	typeKindInterface
+	typeKindUntypedInt
+	typeKindUntypedFloat
+	typeKindUntypedBool
+	typeKindUntypedNil
 )
 
 type typ struct {
@@ -21,11 +41,28 @@ type typ struct {
 	size  int
 	align int
 
+	// defined types; size is -1 until the underlying type is known
+	name       string
+	underlying *typ
+
 	// array
 	length int
 
+	// map; base is the element type
+	key *typ
+
 	// struct
 	members []*member
+
+	// func
+	params  []*typ
+	results []*typ
+
+	// interface, sorted by name
+	imethods []*imethod
+
+	// defined types
+	methods []*method
 }
 
 func newType(kind typeKind, size int) *typ {
@@ -33,31 +70,352 @@ func newType(kind typeKind, size int) *typ {
 }
 
 var (
-	typeKindMap = map[string]typeKind{
-		"int":  typeKindInt,
-		"byte": typeKindByte,
-		"bool": typeKindBool,
+	typeKindByName = map[string]typeKind{
+		"int":     typeKindInt,
+		"int8":    typeKindInt8,
+		"int16":   typeKindInt16,
+		"int32":   typeKindInt32,
+		"int64":   typeKindInt64,
+		"uint":    typeKindUint,
+		"uint8":   typeKindUint8,
+		"byte":    typeKindUint8,
+		"uint16":  typeKindUint16,
+		"uint32":  typeKindUint32,
+		"uint64":  typeKindUint64,
+		"uintptr": typeKindUintptr,
+		"float32": typeKindFloat32,
+		"float64": typeKindFloat64,
+		"bool":    typeKindBool,
 	}
 	typeKindSize = map[string]int{
-		"int":  8,
-		"byte": 1,
-		"bool": 1,
+		"int":     8,
+		"int8":    1,
+		"int16":   2,
+		"int32":   4,
+		"int64":   8,
+		"uint":    8,
+		"uint8":   1,
+		"byte":    1,
+		"uint16":  2,
+		"uint32":  4,
+		"uint64":  8,
+		"uintptr": 8,
+		"float32": 4,
+		"float64": 8,
+		"bool":    1,
 	}
 	typeAlignMap = map[typeKind]int{
-		typeKindInt:  8,
-		typeKindByte: 1,
-		typeKindBool: 1,
-		typeKindPtr:  8,
+		typeKindInt:       8,
+		typeKindInt8:      1,
+		typeKindInt16:     2,
+		typeKindInt32:     4,
+		typeKindInt64:     8,
+		typeKindUint:      8,
+		typeKindUint8:     1,
+		typeKindUint16:    2,
+		typeKindUint32:    4,
+		typeKindUint64:    8,
+		typeKindUintptr:   8,
+		typeKindFloat32:   4,
+		typeKindFloat64:   8,
+		typeKindBool:      1,
+		typeKindPtr:       8,
+		typeKindSlice:     8,
+		typeKindMap:       8,
+		typeKindFunc:      8,
+		typeKindInterface: 8,
 	}
-	zeroValueMap = map[typeKind]expression{
-		typeKindInt:  &intLit{val: 0},
-		typeKindByte: &intLit{val: 0},
-		typeKindBool: &intLit{val: 0},
+	// the spelling of the predeclared types; byte is an alias for uint8
+	basicTypeNames = map[typeKind]string{
+		typeKindInt:     "int",
+		typeKindInt8:    "int8",
+		typeKindInt16:   "int16",
+		typeKindInt32:   "int32",
+		typeKindInt64:   "int64",
+		typeKindUint:    "uint",
+		typeKindUint8:   "uint8",
+		typeKindUint16:  "uint16",
+		typeKindUint32:  "uint32",
+		typeKindUint64:  "uint64",
+		typeKindUintptr: "uintptr",
+		typeKindFloat32: "float32",
+		typeKindFloat64: "float64",
+		typeKindBool:    "bool",
 	}
 )
 
 func newLiteralType(s string) *typ {
-	return newType(typeKindMap[s], typeKindSize[s])
+	return newType(typeKindByName[s], typeKindSize[s])
+}
+
+func isInteger(ty *typ) bool {
+	return ty.kind >= typeKindInt && ty.kind <= typeKindUintptr || ty.kind == typeKindUntypedInt
+}
+
+func isUnsigned(ty *typ) bool {
+	return ty.kind >= typeKindUint && ty.kind <= typeKindUintptr
+}
+
+func isFloat(ty *typ) bool {
+	return ty.kind == typeKindFloat32 || ty.kind == typeKindFloat64 || ty.kind == typeKindUntypedFloat
+}
+
+func isNumeric(ty *typ) bool {
+	return isInteger(ty) || isFloat(ty)
+}
+
+func isUntyped(ty *typ) bool {
+	return ty.kind == typeKindUntypedInt || ty.kind == typeKindUntypedFloat || ty.kind == typeKindUntypedBool
+}
+
+// defaultType is the type an untyped constant gets when nothing else
+// determines it.
+func defaultType(ty *typ) *typ {
+	switch ty.kind {
+	case typeKindUntypedInt:
+		return newLiteralType("int")
+	case typeKindUntypedFloat:
+		return newLiteralType("float64")
+	case typeKindUntypedBool:
+		return newLiteralType("bool")
+	}
+	return ty
+}
+
+func zeroValue(ty *typ) expression {
+	if ty.kind == typeKindBool {
+		return &constExpr{ty: ty, val: constant.MakeBool(false)}
+	}
+	return &constExpr{ty: ty, val: constant.MakeInt64(0)}
+}
+
+func (ty *typ) String() string {
+	return ty.format("")
+}
+
+// format spells ty with the names of defined types prefixed by pkg.
+func (ty *typ) format(pkg string) string {
+	if ty.name != "" {
+		return pkg + ty.name
+	}
+	if name, ok := basicTypeNames[ty.kind]; ok {
+		return name
+	}
+	switch ty.kind {
+	case typeKindPtr:
+		return "*" + ty.base.format(pkg)
+	case typeKindArray:
+		return fmt.Sprintf("[%d]%s", ty.length, ty.base.format(pkg))
+	case typeKindSlice:
+		return "[]" + ty.base.format(pkg)
+	case typeKindMap:
+		return "map[" + ty.key.format(pkg) + "]" + ty.base.format(pkg)
+	case typeKindStruct:
+		s := "struct {"
+		for i, m := range ty.members {
+			if i > 0 {
+				s += ";"
+			}
+			if m.embedded {
+				s += " " + m.ty.format(pkg)
+				continue
+			}
+			s += fmt.Sprintf(" %s %s", m.name, m.ty.format(pkg))
+		}
+		return s + " }"
+	case typeKindFunc:
+		return "func" + formatSignature(ty, pkg)
+	case typeKindInterface:
+		if len(ty.imethods) == 0 {
+			return "interface {}"
+		}
+		s := "interface {"
+		for i, m := range ty.imethods {
+			if i > 0 {
+				s += ";"
+			}
+			s += " " + m.name + formatSignature(m.ty, pkg)
+		}
+		return s + " }"
+	case typeKindUntypedInt:
+		return "untyped int"
+	case typeKindUntypedFloat:
+		return "untyped float"
+	case typeKindUntypedBool:
+		return "untyped bool"
+	case typeKindUntypedNil:
+		return "untyped nil"
+	}
+	return "?"
+}
+
+func formatSignature(ty *typ, pkg string) string {
+	s := "("
+	for i, p := range ty.params {
+		if i > 0 {
+			s += ", "
+		}
+		s += p.format(pkg)
+	}
+	s += ")"
+	switch len(ty.results) {
+	case 0:
+	case 1:
+		s += " " + ty.results[0].format(pkg)
+	default:
+		s += " ("
+		for i, r := range ty.results {
+			if i > 0 {
+				s += ", "
+			}
+			s += r.format(pkg)
+		}
+		s += ")"
+	}
+	return s
+}
+
+// setUnderlying completes a defined type with the representation of its
+// underlying type.
+func (ty *typ) setUnderlying(under *typ) {
+	if under.size < 0 {
+		panic(fmt.Sprintf("invalid recursive type %s", ty.name))
+	}
+	under = under.under()
+	ty.kind, ty.base, ty.size, ty.align = under.kind, under.base, under.size, under.align
+	ty.length, ty.members, ty.key = under.length, under.members, under.key
+	ty.params, ty.results, ty.imethods = under.params, under.results, under.imethods
+	ty.underlying = under
+}
+
+func (ty *typ) under() *typ {
+	if ty.underlying != nil {
+		return ty.underlying
+	}
+	return ty
+}
+
+// isNamed reports whether ty is a predeclared or defined type.
+func isNamed(ty *typ) bool {
+	if _, ok := basicTypeNames[ty.kind]; ok {
+		return true
+	}
+	return ty.name != ""
+}
+
+func identical(x, y *typ) bool {
+	if x == y {
+		return true
+	}
+	if x.name != "" || y.name != "" || x.kind != y.kind {
+		// every defined type is different from any other type
+		return false
+	}
+	switch x.kind {
+	case typeKindPtr, typeKindSlice:
+		return identical(x.base, y.base)
+	case typeKindMap:
+		return identical(x.key, y.key) && identical(x.base, y.base)
+	case typeKindArray:
+		return x.length == y.length && identical(x.base, y.base)
+	case typeKindStruct:
+		if len(x.members) != len(y.members) {
+			return false
+		}
+		for i, m := range x.members {
+			if m.name != y.members[i].name || m.embedded != y.members[i].embedded || !identical(m.ty, y.members[i].ty) {
+				return false
+			}
+		}
+	case typeKindFunc:
+		return identicalList(x.params, y.params) && identicalList(x.results, y.results)
+	case typeKindInterface:
+		if len(x.imethods) != len(y.imethods) {
+			return false
+		}
+		for i, m := range x.imethods {
+			if m.name != y.imethods[i].name || !identical(m.ty, y.imethods[i].ty) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func identicalList(x, y []*typ) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if !identical(x[i], y[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// assignable reports whether a value of type v may be assigned to a
+// variable of type t.
+func assignable(v, t *typ) bool {
+	if identical(v, t) {
+		return true
+	}
+	return (!isNamed(v) || !isNamed(t)) && identical(v.under(), t.under())
+}
+
+// checkAssignable returns e as a value of type ty, converted to ty if it is
+// an interface type.
+func checkAssignable(e expression, ty *typ, context string) expression {
+	convertUntyped(e, ty)
+	v := e.getType()
+	if assignable(v, ty) {
+		return e
+	}
+	if ty.kind == typeKindInterface {
+		if m := missingMethod(v, ty); m != "" {
+			panic(fmt.Sprintf("cannot use %s value as %s value in %s: %s does not implement %s (missing method %s)", v, ty, context, v, ty, m))
+		}
+		return convertToInterface(e, ty)
+	}
+	panic(fmt.Sprintf("cannot use %s value as %s value in %s", v, ty, context))
+}
+
+// resolveMember looks up the field selected by e in the type of its
+// operand. A pointer to a struct is dereferenced, and a promoted field is
+// reached through the embedded fields that lead to it.
+func resolveMember(e *memberRef) {
+	ty := e.child.getType()
+	sel := lookupSelection(ty, e.name)
+	if sel == nil || sel.field == nil {
+		panic(fmt.Sprintf("%s.%s undefined (type %s has no field or method %s)", ty, e.name, ty, e.name))
+	}
+	e.child = selectPath(e.child, sel.path)
+	if e.child.getType().kind == typeKindPtr {
+		e.child = &deref{child: e.child}
+		addType(e.child)
+	}
+	e.member = sel.field
+}
+
+func funcType(params, results []*obj) *typ {
+	ty := newType(typeKindFunc, 8)
+	for _, p := range params {
+		ty.params = append(ty.params, p.ty)
+	}
+	for _, r := range results {
+		ty.results = append(ty.results, r.ty)
+	}
+	return ty
+}
+
+// argsSize is the size of the stack words holding values of types tys in a
+// call.
+func argsSize(tys []*typ) int {
+	size := 0
+	for _, ty := range tys {
+		size += alignTo(ty.size, 8)
+	}
+	return size
 }
 
 func pointerTo(base *typ) *typ {
@@ -71,6 +429,9 @@ func alignTo(n, align int) int {
 }
 
 func arrayOf(base *typ, length int) *typ {
+	if base.size < 0 {
+		panic(fmt.Sprintf("invalid recursive type %s", base))
+	}
 	ty := &typ{
 		kind:   typeKindArray,
 		size:   base.size * length,
@@ -81,21 +442,48 @@ func arrayOf(base *typ, length int) *typ {
 	return ty
 }
 
+// sliceOf returns the type []base. A slice is a pointer to its first
+// element, its length and its capacity.
+func sliceOf(base *typ) *typ {
+	ty := newType(typeKindSlice, 24)
+	ty.base = base
+	return ty
+}
+
 type member struct {
 	name   string
 	ty     *typ
 	offset int
+
+	// an embedded field is named after its type and promotes the fields
+	// and methods of that type
+	embedded bool
 }
 
+// newStructType lays out members in order, each at the next offset that is
+// a multiple of its alignment. The struct is aligned like its most aligned
+// member and its size rounded up to that alignment; a final member of size
+// zero gets a byte of padding so that its address stays inside the struct.
 func newStructType(members []*member) *typ {
-	offset := 0
+	offset, align := 0, 1
 	for _, m := range members {
+		if m.ty.size < 0 {
+			panic(fmt.Sprintf("invalid recursive type %s", m.ty))
+		}
+		offset = alignTo(offset, m.ty.align)
 		m.offset = offset
 		offset += m.ty.size
+		if m.ty.align > align {
+			align = m.ty.align
+		}
+	}
+	if n := len(members); n > 0 && members[n-1].ty.size == 0 && offset > 0 {
+		offset++
 	}
 	return &typ{
 		kind:    typeKindStruct,
-		size:    offset,
+		size:    alignTo(offset, align),
+		align:   align,
 		members: members,
 	}
 }
@@ -129,18 +517,45 @@ func addType(n interface{}) {
 		addType(n.post)
 		addType(n.body)
 		return
+	case *mapIterStmt:
+		addType(n.m)
+		return
+	case *heapVarStmt, *deferStmt:
+		return
 	case *expressionStmt:
 		addType(n.child)
 		return
 	case *assignment:
+		if len(n.lhs) == 2 && len(n.rhs) == 1 {
+			switch e := n.rhs[0].(type) {
+			case *typeAssert:
+				e.commaOk = true
+			case *indexExpr:
+				addType(e.child)
+				e.commaOk = e.child.getType().kind == typeKindMap
+			}
+		}
 		if se := n.rhs.convertSingleMultiValuedExpression(); se != nil {
 			addType(se)
 			rhs := se.multiValues()
 			if len(n.lhs) != len(rhs) {
 				panic(fmt.Sprintf("assigment operands must be same length: lhs=%d, rhs=%d", len(n.lhs), len(rhs)))
 			}
+			// the values are converted from temporaries if any of them
+			// needs to be
+			temps := make([]*obj, len(rhs))
+			convs := make(expressionList, len(rhs))
+			n.temps, n.convs = nil, nil
 			for i, e := range rhs {
-				n.lhs[i].setType(e.getType())
+				addType(n.lhs[i])
+				if n.lhs[i].getType() == nil {
+					n.lhs[i].setType(e.getType())
+				}
+				temps[i] = &obj{name: newUniqueName(), ty: e.getType()}
+				convs[i] = checkAssignable(temps[i], n.lhs[i].getType(), "assignment")
+				if convs[i] != expression(temps[i]) {
+					n.temps, n.convs = temps, convs
+				}
 			}
 		} else {
 			if len(n.lhs) != len(n.rhs) {
@@ -148,29 +563,101 @@ func addType(n interface{}) {
 			}
 			for i, e := range n.rhs {
 				addType(e)
-				n.lhs[i].setType(e.getType())
+				addType(n.lhs[i])
+				if n.lhs[i].getType() == nil {
+					if isNil(e) {
+						panic("use of untyped nil in assignment")
+					}
+					n.lhs[i].setType(defaultType(e.getType()))
+				}
+				n.rhs[i] = checkAssignable(e, n.lhs[i].getType(), "assignment")
 			}
 		}
 		return
-	case *intLit:
-		n.setType(newLiteralType("int"))
-		return
 	case *memberRef:
 		addType(n.child)
+		if n.member == nil {
+			resolveMember(n)
+		}
 		n.setType(n.member.ty)
 	case *binary:
 		addType(n.lhs)
 		addType(n.rhs)
 		switch n.op {
-		case "+", "-", "*", "/":
+		case "<<", ">>":
+			convertUntyped(n.lhs, newLiteralType("int"))
+			convertUntyped(n.rhs, newLiteralType("int"))
+			if !isInteger(n.lhs.getType()) {
+				panic(fmt.Sprintf("invalid operation: shifted operand of type %s must be integer", n.lhs.getType()))
+			}
+			if !isInteger(n.rhs.getType()) {
+				panic(fmt.Sprintf("invalid operation: shift count of type %s must be integer", n.rhs.getType()))
+			}
 			n.setType(n.lhs.getType())
-		case "==", "!=", "<", "<=":
+		case "&&", "||":
+			convertUntyped(n.lhs, newLiteralType("bool"))
+			convertUntyped(n.rhs, newLiteralType("bool"))
 			n.setType(newLiteralType("bool"))
+		default:
+			convertUntyped(n.lhs, n.rhs.getType())
+			convertUntyped(n.rhs, n.lhs.getType())
+			lt, rt := n.lhs.getType(), n.rhs.getType()
+			if isNil(n.lhs) || isNil(n.rhs) {
+				if lt.kind == typeKindUntypedNil || (n.op != "==" && n.op != "!=") {
+					panic(fmt.Sprintf("invalid operation: operator %s not defined on nil", n.op))
+				}
+				n.setType(newLiteralType("bool"))
+				return
+			}
+			if lt.kind == typeKindFunc {
+				panic("invalid operation: func can only be compared to nil")
+			}
+			if lt.kind == typeKindInterface {
+				panic(fmt.Sprintf("invalid operation: operator %s not defined on interface values", n.op))
+			}
+			if lt.kind == typeKindSlice || rt.kind == typeKindSlice {
+				panic(fmt.Sprintf("invalid operation: operator %s not defined on slices", n.op))
+			}
+			if lt.kind == typeKindMap || rt.kind == typeKindMap {
+				panic(fmt.Sprintf("invalid operation: operator %s not defined on maps", n.op))
+			}
+			if !identical(lt, rt) {
+				panic(fmt.Sprintf("invalid operation: mismatched types %s and %s", lt, rt))
+			}
+			if lt.kind == typeKindPtr && n.op != "==" && n.op != "!=" {
+				panic(fmt.Sprintf("invalid operation: operator %s not defined on %s", n.op, lt))
+			}
+			if lt.kind == typeKindArray || lt.kind == typeKindStruct {
+				panic(fmt.Sprintf("invalid operation: operator %s not defined on %s", n.op, lt))
+			}
+			switch n.op {
+			case "%", "&", "|", "^", "&^":
+				if isFloat(lt) {
+					panic(fmt.Sprintf("invalid operation: operator %s not defined on %s", n.op, lt))
+				}
+			}
+			switch n.op {
+			case "==", "!=", "<", "<=":
+				n.setType(newLiteralType("bool"))
+			default:
+				n.setType(n.lhs.getType())
+			}
 		}
 		return
+	case *unary:
+		addType(n.child)
+		ok := isInteger(n.child.getType())
+		if n.op == "-" {
+			ok = isNumeric(n.child.getType())
+		}
+		if !ok {
+			panic(fmt.Sprintf("invalid operation: operator %s not defined on %s", n.op, n.child.getType()))
+		}
+		n.setType(n.child.getType())
+		return
 	case *obj:
 		return
-	case *compositeLit:
+	case *compositeLit, *mapLit:
 		return
 	case *deref:
 		addType(n.child)
@@ -183,17 +670,75 @@ func addType(n interface{}) {
 		return
 	case *addr:
 		addType(n.child)
+		if _, ok := n.child.(*compositeLit); !ok && !addressable(n.child) {
+			panic("invalid operation: cannot take address of operand")
+		}
 		ct := n.child.getType()
 		ty := pointerTo(ct)
 		n.setType(ty)
 	case *funcCall:
-		for _, arg := range n.args {
+		for i, arg := range n.args {
 			addType(arg)
+			if i < len(n.target.params) {
+				n.args[i] = checkAssignable(arg, n.target.params[i].ty, "argument")
+			}
 		}
 		if n.target != nil && len(n.target.results) > 0 {
 			n.setType(n.target.results[0].getType())
 		}
 		return
+	case *funcVal:
+		n.fn.isValue = true
+		n.setType(funcType(n.fn.params, n.fn.results))
+	case *methodVal:
+		addType(n.recv)
+		fn := n.method.fn
+		methodValueWrapper(fn)
+		n.setType(funcType(fn.params[1:], fn.results))
+	case *toIface, *typeAssert, *typeCheck:
+		return
+	case *indexExpr:
+		addIndexType(n)
+	case *sliceExpr:
+		addSliceType(n)
+	case *builtinCall:
+		addBuiltinType(n)
+	case *typeSwitchGuard:
+		panic("use of .(type) outside type switch")
+	case *ifaceMethod:
+		panic("method values of interfaces are not supported")
+	case *ifaceCall:
+		addType(n.recv)
+		ty := n.method.ty
+		for i, arg := range n.args {
+			addType(arg)
+			if i < len(ty.params) {
+				n.args[i] = checkAssignable(arg, ty.params[i], "argument")
+			}
+		}
+		if len(ty.results) > 0 {
+			n.setType(ty.results[0])
+		}
+		return
+	case *indirectCall:
+		addType(n.fn)
+		ty := n.fn.getType()
+		if len(n.args) < len(ty.params) {
+			panic(fmt.Sprintf("not enough arguments in call of %s", ty))
+		}
+		if len(n.args) > len(ty.params) {
+			panic(fmt.Sprintf("too many arguments in call of %s", ty))
+		}
+		for i, arg := range n.args {
+			addType(arg)
+			if i < len(ty.params) {
+				n.args[i] = checkAssignable(arg, ty.params[i], "argument")
+			}
+		}
+		if len(ty.results) > 0 {
+			n.setType(ty.results[0])
+		}
+		return
 	default:
 		panic(fmt.Sprintf("Unsupported type: %T", n))
 	}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"go/constant"
+	"strconv"
+)
+
+// pkgName is an imported package. Only unsafe can be imported; its
+// functions are builtins evaluated at compile time.
+type pkgName struct {
+	name string
+	syms map[string]interface{}
+}
+
+var unsafePkg = &pkgName{
+	name: "unsafe",
+	syms: map[string]interface{}{
+		"Alignof":  &builtin{name: "unsafe.Alignof"},
+		"Offsetof": &builtin{name: "unsafe.Offsetof"},
+		"Sizeof":   &builtin{name: "unsafe.Sizeof"},
+	},
+}
+
+// ImportDecl = "import" ( ImportSpec | "(" { ImportSpec ";" } ")" ) .
+// ImportSpec = ImportPath .
+// ImportPath = string_lit .
+func parseImportDecl() {
+	if !consume("(") {
+		parseImportSpec()
+		return
+	}
+	for !consume(")") {
+		parseImportSpec()
+		if !peek(")") {
+			expect(";")
+		}
+	}
+}
+
+func parseImportSpec() {
+	tok := consumeToken(tokenKindLiteral)
+	if tok == nil || tok.val[0] != '"' {
+		panic(fmt.Sprintf("Expected an import path: %+v", tokens[0]))
+	}
+	path, err := strconv.Unquote(tok.val)
+	if err != nil {
+		panic(fmt.Sprintf("invalid import path: %s", tok.val))
+	}
+	if path != "unsafe" {
+		panic(fmt.Sprintf("package %s is not in std", path))
+	}
+	pkgScope.declare(unsafePkg.name, unsafePkg)
+}
+
+// parseQualifiedIdent parses the exported identifier after the package
+// name pkg.
+func parseQualifiedIdent(pkg *pkgName) interface{} {
+	expect(".")
+	tok := consumeToken(tokenKindIdentifier)
+	if tok == nil {
+		panic(fmt.Sprintf("Expected an identifier: %+v", tokens[0]))
+	}
+	sym, ok := pkg.syms[tok.val]
+	if !ok {
+		panic(fmt.Sprintf("undefined: %s.%s", pkg.name, tok.val))
+	}
+	return sym
+}
+
+// unsafeCall evaluates the call e of a function of package unsafe to a
+// constant of type uintptr.
+func unsafeCall(e *builtinCall) expression {
+	checkArgCount(e, 1, 1)
+	arg := e.args[0]
+	addType(arg)
+	ty := defaultType(arg.getType())
+	switch e.name {
+	case "unsafe.Sizeof":
+		return newUintptrConst(ty.size)
+	case "unsafe.Alignof":
+		return newUintptrConst(ty.align)
+	}
+
+	// Offsetof
+	switch arg := arg.(type) {
+	case *memberRef:
+		// a promoted field is at an offset from the struct of the selector
+		off := arg.member.offset
+		for x := arg.child; ; {
+			if d, ok := x.(*deref); ok {
+				if m, ok := d.child.(*memberRef); ok && m.implicit {
+					panic(fmt.Sprintf("invalid argument: field %s is embedded via a pointer", arg.name))
+				}
+			}
+			m, ok := x.(*memberRef)
+			if !ok || !m.implicit {
+				break
+			}
+			off += m.member.offset
+			x = m.child
+		}
+		return newUintptrConst(off)
+	case *methodVal:
+		panic(fmt.Sprintf("invalid argument: %s is a method value", arg.method.name))
+	}
+	panic("invalid argument: argument to unsafe.Offsetof is not a selector expression")
+}
+
+func newUintptrConst(n int) *constExpr {
+	return &constExpr{ty: newLiteralType("uintptr"), val: constant.MakeInt64(int64(n))}
+}